@@ -5,14 +5,59 @@ import (
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"net/http"
+	"os"
 	"regexp"
+	"strings"
 )
 
+// taskAuthEmail is the email of the service account which Cloud Tasks uses to call the server.
+const taskAuthEmail = "remap-build-server-task-auth@remap-b2d08.iam.gserviceaccount.com"
+
+// adminEmailsEnvironmentVariable is the environment variable holding the comma-separated emails of the accounts
+// allowed to call the administrator modes, like the reproducibility check.
+const adminEmailsEnvironmentVariable = "REMAP_ADMIN_EMAILS"
+
+// CheckAuthenticationToken checks whether the request is sent by Cloud Tasks.
 func CheckAuthenticationToken(r *http.Request) error {
-	authenticationToken, err := parseAuthenticationToken(r)
+	email, err := verifyAuthenticationToken(r)
 	if err != nil {
 		return err
 	}
+	if email != taskAuthEmail {
+		return fmt.Errorf("email is invalid")
+	}
+	return nil
+}
+
+// CheckAdminAuthenticationToken checks whether the request is sent by an administrator.
+// No request is allowed if the administrators are not configured.
+func CheckAdminAuthenticationToken(r *http.Request) error {
+	email, err := verifyAuthenticationToken(r)
+	if err != nil {
+		return err
+	}
+	if !isAdminEmail(email, os.Getenv(adminEmailsEnvironmentVariable)) {
+		return fmt.Errorf("the account is not an administrator: %s", email)
+	}
+	return nil
+}
+
+func isAdminEmail(email string, adminEmails string) bool {
+	for _, adminEmail := range strings.Split(adminEmails, ",") {
+		adminEmail = strings.TrimSpace(adminEmail)
+		if adminEmail != "" && adminEmail == email {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyAuthenticationToken verifies the ID token issued by Google, and returns the email in it.
+func verifyAuthenticationToken(r *http.Request) (string, error) {
+	authenticationToken, err := parseAuthenticationToken(r)
+	if err != nil {
+		return "", err
+	}
 	token, err := jwt.Parse(authenticationToken, func(token *jwt.Token) (interface{}, error) {
 		alg := token.Header["alg"]
 		if alg != "RS256" {
@@ -29,22 +74,22 @@ func CheckAuthenticationToken(r *http.Request) error {
 		return ConvertPublickeyToPEM(publicKey)
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
 	if !token.Valid {
-		return fmt.Errorf("token is invalid")
+		return "", fmt.Errorf("token is invalid")
 	}
-	// Checks the iss, email and aud claims.
+	// Checks the iss claim. The email claim is checked by the caller.
 	claims := token.Claims.(jwt.MapClaims)
-	iss := claims["iss"]
-	if iss.(string) != "https://accounts.google.com" {
-		return fmt.Errorf("iss is invalid")
+	iss, _ := claims["iss"].(string)
+	if iss != "https://accounts.google.com" {
+		return "", fmt.Errorf("iss is invalid")
 	}
-	email := claims["email"]
-	if email.(string) != "remap-build-server-task-auth@remap-b2d08.iam.gserviceaccount.com" {
-		return fmt.Errorf("email is invalid")
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return "", fmt.Errorf("email is empty")
 	}
-	return nil
+	return email, nil
 }
 
 func parseAuthenticationToken(r *http.Request) (string, error) {
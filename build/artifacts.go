@@ -0,0 +1,201 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	ArtifactFormatHex string = "hex"
+	ArtifactFormatBin string = "bin"
+	ArtifactFormatUf2 string = "uf2"
+)
+
+// Artifact represents a firmware file produced by the build.
+type Artifact struct {
+	// FileName is the name of the file copied to the top level of the QMK Firmware directory.
+	FileName string
+	// FilePath is the absolute path of the file.
+	FilePath string
+	// Format is the format of the file, like "hex" or "uf2".
+	Format string
+	// ConvertedFrom is the format of the file which this file was converted from.
+	// The empty string means that the file was produced by QMK.
+	ConvertedFrom string
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshotTopLevelFiles records the state of the files at the top level of the directory.
+func snapshotTopLevelFiles(directoryPath string) (map[string]fileState, error) {
+	entries, err := os.ReadDir(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := map[string]fileState{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshot[entry.Name()] = fileState{modTime: info.ModTime(), size: info.Size()}
+	}
+	return snapshot, nil
+}
+
+// createTargetName creates the prefix of the firmware file names, which QMK builds from the keyboard and the keymap.
+// For instance, the target of the keyboard "foo/rev1" and the keymap "remap" is "foo_rev1_remap".
+func createTargetName(options BuildOptions) string {
+	return strings.ReplaceAll(options.KeyboardTarget(), "/", "_") + "_" + options.KeymapName
+}
+
+// converterNames is the converters which QMK accepts as the CONVERT_TO option, in platforms/chibios/converters.
+// QMK appends the converter to the target name, like "foo_rev1_remap_rp2040_ce".
+var converterNames = map[string]bool{
+	"bit_c_pro":       true,
+	"blok":            true,
+	"bonsai_c4":       true,
+	"elite_pi":        true,
+	"helios":          true,
+	"imera":           true,
+	"kb2040":          true,
+	"liatris":         true,
+	"michi":           true,
+	"promicro_rp2040": true,
+	"proton_c":        true,
+	"rp2040_ce":       true,
+	"sparkfun_pm2040": true,
+	"stemcell":        true,
+	"svlinky":         true,
+}
+
+// isTargetName returns true if the name is the target name, or the target name with the converter of the CONVERT_TO
+// option. The target names of the other keymaps, like "foo_remap_via" for the target "foo_remap", are not matched.
+func isTargetName(name string, targetName string) bool {
+	if name == targetName {
+		return true
+	}
+	converterName, ok := strings.CutPrefix(name, targetName+"_")
+	return ok && converterNames[converterName]
+}
+
+var rulesConvertToPattern = regexp.MustCompile(`(?m)^\s*CONVERT_TO\s*[:?]?=\s*(\S+)`)
+
+// createOutputTargetName creates the name of the target which QMK names the outputs with.
+// The converter of the CONVERT_TO option is taken from the environment variables, or the rules.mk files of
+// the keymap, the revision directories and the keyboard directory. The deeper file wins, in the same way as QMK.
+func createOutputTargetName(options BuildOptions, keyboardDirectoryPath string) string {
+	targetName := createTargetName(options)
+	converterName := options.EnvironmentVariables["CONVERT_TO"]
+	if converterName == "" {
+		directoryPaths := []string{keyboardDirectoryPath}
+		directoryPath := keyboardDirectoryPath
+		if options.Revision != "" {
+			for _, name := range strings.Split(options.Revision, "/") {
+				directoryPath = filepath.Join(directoryPath, name)
+				directoryPaths = append(directoryPaths, directoryPath)
+			}
+		}
+		directoryPaths = append(directoryPaths, filepath.Join(keyboardDirectoryPath, "keymaps", options.KeymapName))
+		for _, path := range directoryPaths {
+			content, err := os.ReadFile(filepath.Join(path, "rules.mk"))
+			if err != nil {
+				continue
+			}
+			if match := rulesConvertToPattern.FindSubmatch(content); match != nil {
+				converterName = string(match[1])
+			}
+		}
+	}
+	if !converterNames[converterName] {
+		return targetName
+	}
+	return targetName + "_" + converterName
+}
+
+// isTargetFileName returns true if the file name without the extension is the name of the target.
+func isTargetFileName(fileName string, targetName string) bool {
+	return isTargetName(strings.TrimSuffix(fileName, filepath.Ext(fileName)), targetName)
+}
+
+// collectArtifacts compares the top-level files of the directory with the snapshot taken before the build,
+// and returns the firmware files of the target which were created or updated by the build.
+// The files of the other builds running in the same directory are excluded by the target name.
+func collectArtifacts(directoryPath string, before map[string]fileState, options BuildOptions) ([]Artifact, error) {
+	after, err := snapshotTopLevelFiles(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+	targetName := createTargetName(options)
+	artifacts := []Artifact{}
+	for name, state := range after {
+		if previous, ok := before[name]; ok && previous == state {
+			continue
+		}
+		if !isTargetFileName(name, targetName) {
+			continue
+		}
+		format := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+		switch format {
+		case ArtifactFormatHex, ArtifactFormatBin, ArtifactFormatUf2:
+			artifacts = append(artifacts, Artifact{
+				FileName: name,
+				FilePath: filepath.Join(directoryPath, name),
+				Format:   format,
+			})
+		}
+	}
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].FileName < artifacts[j].FileName
+	})
+	return artifacts, nil
+}
+
+// CreateArtifactObjectName creates the name of the object of the firmware file in the Cloud Storage.
+// The name consists of the task ID, the target name and the hash of the content,
+// so it never collides across the tasks and can be traced back to the task.
+// For instance, "task1_foo_rev1_remap_0123456789abcdef.uf2".
+func CreateArtifactObjectName(taskId string, options BuildOptions, artifact Artifact) (string, error) {
+	hash, err := hashFile(artifact.FilePath)
+	if err != nil {
+		return "", err
+	}
+	return taskId + "_" + createTargetName(options) + "_" + hash[:16] + filepath.Ext(artifact.FileName), nil
+}
+
+// CreateDownloadFileName creates the name of the firmware file which the user downloads.
+// It consists of the keyboard name, the revision, the keymap and the QMK Firmware version,
+// like "Foo_Keyboard_rev1_remap_0.22.14.uf2". The keyboard ID is generated for the build, so it is used
+// only if the keyboard name is empty.
+func CreateDownloadFileName(keyboardName string, options BuildOptions, artifact Artifact) string {
+	name := sanitizeFileName(keyboardName)
+	if name == "" {
+		name = options.KeyboardId
+	}
+	nameOptions := options
+	nameOptions.KeyboardId = name
+	return createTargetName(nameOptions) + "_" + options.QmkFirmwareVersion + filepath.Ext(artifact.FileName)
+}
+
+// sanitizeFileName replaces the characters other than the letters, the digits, "-", "_" and "." with "_",
+// so the name can be used as a part of the file name on any OS.
+func sanitizeFileName(name string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(name))
+	return strings.Trim(sanitized, "_.")
+}
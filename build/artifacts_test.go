@@ -0,0 +1,198 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func writeTopLevelFile(t *testing.T, directoryPath string, name string, content string) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(directoryPath, name), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_createTargetName(t *testing.T) {
+	actual := createTargetName(BuildOptions{KeyboardId: "foo", KeymapName: "remap"})
+	if actual != "foo_remap" {
+		t.Error("Expected foo_remap but got", actual)
+	}
+	actual = createTargetName(BuildOptions{KeyboardId: "foo", Revision: "rev1", KeymapName: "via"})
+	if actual != "foo_rev1_via" {
+		t.Error("Expected foo_rev1_via but got", actual)
+	}
+}
+
+func Test_createOutputTargetName(t *testing.T) {
+	keyboardDirectoryPath := t.TempDir()
+	options := BuildOptions{KeyboardId: "foo", Revision: "rev1", KeymapName: "remap"}
+	if actual := createOutputTargetName(options, keyboardDirectoryPath); actual != "foo_rev1_remap" {
+		t.Error("Expected foo_rev1_remap but got", actual)
+	}
+	createFiles(t, keyboardDirectoryPath, "rules.mk", "rev1/rules.mk", "keymaps/remap/rules.mk")
+	writeTopLevelFile(t, keyboardDirectoryPath, "rules.mk", "CONVERT_TO = promicro_rp2040\n")
+	writeTopLevelFile(t, filepath.Join(keyboardDirectoryPath, "keymaps/remap"), "rules.mk", "CONVERT_TO=rp2040_ce\n")
+	if actual := createOutputTargetName(options, keyboardDirectoryPath); actual != "foo_rev1_remap_rp2040_ce" {
+		t.Error("Expected foo_rev1_remap_rp2040_ce but got", actual)
+	}
+	options.EnvironmentVariables = map[string]string{"CONVERT_TO": "kb2040"}
+	if actual := createOutputTargetName(options, keyboardDirectoryPath); actual != "foo_rev1_remap_kb2040" {
+		t.Error("Expected foo_rev1_remap_kb2040 but got", actual)
+	}
+	// The unknown converter is not appended.
+	options.EnvironmentVariables = map[string]string{"CONVERT_TO": "../evil"}
+	if actual := createOutputTargetName(options, keyboardDirectoryPath); actual != "foo_rev1_remap" {
+		t.Error("Expected foo_rev1_remap but got", actual)
+	}
+}
+
+func Test_collectArtifacts(t *testing.T) {
+	directoryPath := t.TempDir()
+	writeTopLevelFile(t, directoryPath, "Makefile", "all:")
+	writeTopLevelFile(t, directoryPath, "old_remap.hex", "old")
+	before, err := snapshotTopLevelFiles(directoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTopLevelFile(t, directoryPath, "foo_rev1_remap.hex", "hex")
+	writeTopLevelFile(t, directoryPath, "foo_rev1_remap.uf2", "uf2")
+	writeTopLevelFile(t, directoryPath, "foo_rev1_remap.map", "map")
+	writeTopLevelFile(t, directoryPath, "bar_remap.bin", "other build")
+
+	actual, err := collectArtifacts(directoryPath, before, BuildOptions{KeyboardId: "foo", Revision: "rev1", KeymapName: "remap"})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual) != 2 {
+		t.Fatal("Expected 2 but got", actual)
+	}
+	if actual[0].FileName != "foo_rev1_remap.hex" || actual[0].Format != ArtifactFormatHex {
+		t.Error("Expected foo_rev1_remap.hex but got", actual[0])
+	}
+	if actual[1].FileName != "foo_rev1_remap.uf2" || actual[1].Format != ArtifactFormatUf2 {
+		t.Error("Expected foo_rev1_remap.uf2 but got", actual[1])
+	}
+	if actual[1].FilePath != filepath.Join(directoryPath, "foo_rev1_remap.uf2") {
+		t.Error("Expected the absolute path but got", actual[1].FilePath)
+	}
+}
+
+func Test_collectArtifacts_ConvertTo(t *testing.T) {
+	directoryPath := t.TempDir()
+	before, err := snapshotTopLevelFiles(directoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTopLevelFile(t, directoryPath, "foo_remap_rp2040_ce.uf2", "uf2")
+	writeTopLevelFile(t, directoryPath, "foobar_remap.hex", "other build")
+	writeTopLevelFile(t, directoryPath, "foo_remap_via.hex", "other keymap")
+
+	actual, err := collectArtifacts(directoryPath, before, BuildOptions{KeyboardId: "foo", KeymapName: "remap"})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual) != 1 || actual[0].FileName != "foo_remap_rp2040_ce.uf2" || actual[0].Format != ArtifactFormatUf2 {
+		t.Error("Expected foo_remap_rp2040_ce.uf2 but got", actual)
+	}
+}
+
+func Test_collectArtifacts_UpdatedFile(t *testing.T) {
+	directoryPath := t.TempDir()
+	writeTopLevelFile(t, directoryPath, "foo_remap.bin", "old")
+	past := time.Now().Add(-time.Hour)
+	err := os.Chtimes(filepath.Join(directoryPath, "foo_remap.bin"), past, past)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := snapshotTopLevelFiles(directoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := collectArtifacts(directoryPath, before, BuildOptions{KeyboardId: "foo", KeymapName: "remap"})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual) != 0 {
+		t.Error("Expected no artifacts but got", actual)
+	}
+
+	writeTopLevelFile(t, directoryPath, "foo_remap.bin", "new")
+	actual, err = collectArtifacts(directoryPath, before, BuildOptions{KeyboardId: "foo", KeymapName: "remap"})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual) != 1 || actual[0].Format != ArtifactFormatBin {
+		t.Error("Expected foo_remap.bin but got", actual)
+	}
+}
+
+func Test_CreateArtifactObjectName(t *testing.T) {
+	directoryPath := t.TempDir()
+	writeTopLevelFile(t, directoryPath, "foo_rev1_remap.uf2", "uf2")
+	artifact := Artifact{FileName: "foo_rev1_remap.uf2", FilePath: filepath.Join(directoryPath, "foo_rev1_remap.uf2")}
+	options := BuildOptions{KeyboardId: "foo", Revision: "rev1", KeymapName: "remap"}
+	actual, err := CreateArtifactObjectName("task1", options, artifact)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if !regexp.MustCompile(`^task1_foo_rev1_remap_[0-9a-f]{16}\.uf2$`).MatchString(actual) {
+		t.Error("Expected task1_foo_rev1_remap_<hash>.uf2 but got", actual)
+	}
+	again, err := CreateArtifactObjectName("task1", options, artifact)
+	if err != nil || again != actual {
+		t.Error("Expected", actual, "but got", again, err)
+	}
+	writeTopLevelFile(t, directoryPath, "foo_rev1_remap.uf2", "changed")
+	changed, err := CreateArtifactObjectName("task1", options, artifact)
+	if err != nil || changed == actual {
+		t.Error("Expected a different name for the different content but got", changed, err)
+	}
+}
+
+func Test_CreateArtifactObjectName_MissingFile(t *testing.T) {
+	artifact := Artifact{FileName: "foo_remap.hex", FilePath: filepath.Join(t.TempDir(), "foo_remap.hex")}
+	_, err := CreateArtifactObjectName("task1", BuildOptions{KeyboardId: "foo", KeymapName: "remap"}, artifact)
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_CreateDownloadFileName(t *testing.T) {
+	options := BuildOptions{KeyboardId: "foo", Revision: "rev1", KeymapName: "remap", QmkFirmwareVersion: "0.22.14"}
+	actual := CreateDownloadFileName("Foo Keyboard", options, Artifact{FileName: "foo_rev1_remap.uf2"})
+	if actual != "Foo_Keyboard_rev1_remap_0.22.14.uf2" {
+		t.Error("Expected Foo_Keyboard_rev1_remap_0.22.14.uf2 but got", actual)
+	}
+}
+
+func Test_CreateDownloadFileName_NoKeyboardName(t *testing.T) {
+	options := BuildOptions{KeyboardId: "foo", KeymapName: "remap", QmkFirmwareVersion: "0.22.14"}
+	for _, keyboardName := range []string{"", " ", "../"} {
+		actual := CreateDownloadFileName(keyboardName, options, Artifact{FileName: "foo_remap.hex"})
+		if actual != "foo_remap_0.22.14.hex" {
+			t.Error("Expected foo_remap_0.22.14.hex but got", actual, "for", keyboardName)
+		}
+	}
+}
+
+func Test_sanitizeFileName(t *testing.T) {
+	cases := map[string]string{
+		"Foo Keyboard": "Foo_Keyboard",
+		"foo/bar:baz":  "foo_bar_baz",
+		"キーボード v1.0":   "キーボード_v1.0",
+		"..":           "",
+	}
+	for name, expected := range cases {
+		actual := sanitizeFileName(name)
+		if actual != expected {
+			t.Error("Expected", expected, "but got", actual, "for", name)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+// BuildInfoHeaderFileName is the name of the header generated into the keyboard directory.
+// The firmware includes it to identify the Remap build, so the source files cannot use the name.
+const BuildInfoHeaderFileName string = "remap_build_info.h"
+
+// BuildInfoDefine is defined when the build information header exists, so the source files can include it conditionally.
+const BuildInfoDefine string = "REMAP_BUILD_INFO"
+
+// buildInfoValuePattern is the pattern of the string values of the build information.
+// The values are written in the C string literals of the header and passed through make and the shell as the defines,
+// so the quotes, the backslashes and the spaces are not allowed.
+var buildInfoValuePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// BuildInfo identifies the Remap build which produced the firmware.
+type BuildInfo struct {
+	TaskId             string
+	Timestamp          time.Time
+	QmkFirmwareVersion string
+	// ParameterHash is the short hash of the parameters JSON of the task.
+	ParameterHash string
+}
+
+// NewBuildInfo creates the build information of the task.
+// The timestamp is the time when the task was created, so the retries and the reproducibility check
+// of the same task embed the same information.
+func NewBuildInfo(taskId string, task *common.Task, qmkFirmwareVersion string) *BuildInfo {
+	return &BuildInfo{
+		TaskId:             taskId,
+		Timestamp:          task.CreatedAt,
+		QmkFirmwareVersion: qmkFirmwareVersion,
+		ParameterHash:      CreateParameterHash(task.ParametersJson),
+	}
+}
+
+// CreateParameterHash creates the first 8 characters of the SHA-256 hash of the parameters JSON.
+func CreateParameterHash(parametersJson string) string {
+	hash := sha256.Sum256([]byte(parametersJson))
+	return hex.EncodeToString(hash[:])[:8]
+}
+
+// buildInfoDefinition is a macro of the build information and its value in the C syntax.
+type buildInfoDefinition struct {
+	Name  string
+	Value string
+}
+
+// createBuildInfoDefinitions creates the macros of the build information.
+// The same definitions are written to the header and passed as the defines,
+// so defining both does not cause the redefinition warning.
+func createBuildInfoDefinitions(buildInfo *BuildInfo) ([]buildInfoDefinition, error) {
+	for _, value := range []string{buildInfo.TaskId, buildInfo.QmkFirmwareVersion, buildInfo.ParameterHash} {
+		if !buildInfoValuePattern.MatchString(value) {
+			return nil, fmt.Errorf("invalid value of the build information: %s", value)
+		}
+	}
+	return []buildInfoDefinition{
+		{Name: "REMAP_BUILD_TASK_ID", Value: `"` + buildInfo.TaskId + `"`},
+		{Name: "REMAP_BUILD_TIMESTAMP", Value: strconv.FormatInt(buildInfo.Timestamp.Unix(), 10)},
+		{Name: "REMAP_BUILD_QMK_VERSION", Value: `"` + buildInfo.QmkFirmwareVersion + `"`},
+		{Name: "REMAP_BUILD_PARAMETER_HASH", Value: `"` + buildInfo.ParameterHash + `"`},
+	}, nil
+}
+
+// createBuildInfoDefines creates the defines of the build information appended to the OPT_DEFS variable.
+// The quotes are escaped because the shell running the compiler removes them.
+func createBuildInfoDefines(definitions []buildInfoDefinition) []string {
+	defines := make([]string, len(definitions))
+	for i, definition := range definitions {
+		defines[i] = definition.Name + "=" + strings.ReplaceAll(definition.Value, `"`, `\"`)
+	}
+	return defines
+}
+
+// createBuildInfoHeader creates the content of the build information header.
+func createBuildInfoHeader(definitions []buildInfoDefinition) string {
+	var builder strings.Builder
+	builder.WriteString("// This file is generated by the Remap build server. Do not edit.\n")
+	builder.WriteString("#pragma once\n\n")
+	for _, definition := range definitions {
+		builder.WriteString("#define " + definition.Name + " " + definition.Value + "\n")
+	}
+	return builder.String()
+}
+
+// writeBuildInfoHeader writes the build information header into the keyboard directory,
+// which is in the include path of the keyboard and the keymaps.
+func writeBuildInfoHeader(keyboardDirectoryPath string, definitions []buildInfoDefinition) error {
+	headerFilePath := filepath.Join(keyboardDirectoryPath, BuildInfoHeaderFileName)
+	log.Printf("[INFO] Writing the build information header: %s\n", headerFilePath)
+	return os.WriteFile(headerFilePath, []byte(createBuildInfoHeader(definitions)), 0644)
+}
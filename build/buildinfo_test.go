@@ -0,0 +1,97 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+func createTestBuildInfo() *BuildInfo {
+	return &BuildInfo{
+		TaskId:             "task1",
+		Timestamp:          time.Unix(1767225600, 0),
+		QmkFirmwareVersion: "0.22.14",
+		ParameterHash:      "0123abcd",
+	}
+}
+
+func Test_NewBuildInfo(t *testing.T) {
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	task := &common.Task{ParametersJson: `{"version":2}`, CreatedAt: createdAt}
+	actual := NewBuildInfo("task1", task, "0.22.14")
+	if actual.TaskId != "task1" || actual.QmkFirmwareVersion != "0.22.14" || !actual.Timestamp.Equal(createdAt) {
+		t.Error("Expected the task information but got", actual)
+	}
+	if actual.ParameterHash != CreateParameterHash(`{"version":2}`) {
+		t.Error("Expected", CreateParameterHash(`{"version":2}`), "but got", actual.ParameterHash)
+	}
+}
+
+func Test_CreateParameterHash(t *testing.T) {
+	actual := CreateParameterHash("")
+	if actual != "e3b0c442" {
+		t.Error("Expected e3b0c442 but got", actual)
+	}
+	if actual == CreateParameterHash(`{"version":2}`) {
+		t.Error("Expected different hashes for different parameters but got", actual)
+	}
+}
+
+func Test_createBuildInfoDefines(t *testing.T) {
+	definitions, err := createBuildInfoDefinitions(createTestBuildInfo())
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	actual := createBuildInfoDefines(definitions)
+	expected := []string{
+		`REMAP_BUILD_TASK_ID=\"task1\"`,
+		`REMAP_BUILD_TIMESTAMP=1767225600`,
+		`REMAP_BUILD_QMK_VERSION=\"0.22.14\"`,
+		`REMAP_BUILD_PARAMETER_HASH=\"0123abcd\"`,
+	}
+	if len(actual) != len(expected) {
+		t.Fatal("Expected", expected, "but got", actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Error("Expected", expected[i], "but got", actual[i])
+		}
+	}
+}
+
+func Test_createBuildInfoDefinitions_InvalidValue(t *testing.T) {
+	buildInfo := createTestBuildInfo()
+	buildInfo.TaskId = `task1" -DEVIL`
+	_, err := createBuildInfoDefinitions(buildInfo)
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_writeBuildInfoHeader(t *testing.T) {
+	directoryPath := t.TempDir()
+	definitions, err := createBuildInfoDefinitions(createTestBuildInfo())
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	err = writeBuildInfoHeader(directoryPath, definitions)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	content, err := os.ReadFile(filepath.Join(directoryPath, BuildInfoHeaderFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "// This file is generated by the Remap build server. Do not edit.\n" +
+		"#pragma once\n\n" +
+		"#define REMAP_BUILD_TASK_ID \"task1\"\n" +
+		"#define REMAP_BUILD_TIMESTAMP 1767225600\n" +
+		"#define REMAP_BUILD_QMK_VERSION \"0.22.14\"\n" +
+		"#define REMAP_BUILD_PARAMETER_HASH \"0123abcd\"\n"
+	if string(content) != expected {
+		t.Error("Expected", expected, "but got", string(content))
+	}
+}
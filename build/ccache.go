@@ -0,0 +1,270 @@
+package build
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+const (
+	ToolchainAvr     string = "avr"
+	ToolchainArm     string = "arm"
+	ToolchainRiscv   string = "riscv"
+	ToolchainUnknown string = "unknown"
+)
+
+// CompilerCacheConfig represents the ccache shared across the builds.
+type CompilerCacheConfig struct {
+	// BaseDirectoryPath is the directory holding a cache directory for each QMK Firmware version and toolchain.
+	BaseDirectoryPath string
+	// MaxSize is the maximum size of each cache directory in the ccache format, like "2G".
+	// ccache evicts the least recently used entries when the size exceeds it.
+	MaxSize string
+	// MaxTotalBytes is the maximum total size of all the cache directories. The number of the cache directories grows
+	// with the QMK Firmware versions, so the least recently used directories are removed when the size exceeds it.
+	MaxTotalBytes int64
+}
+
+// DefaultCompilerCacheConfig returns the compiler cache configuration for the directory created in the Dockerfile.
+func DefaultCompilerCacheConfig() *CompilerCacheConfig {
+	return &CompilerCacheConfig{
+		BaseDirectoryPath: "/root/ccache/",
+		MaxSize:           "2G",
+		MaxTotalBytes:     8 * 1024 * 1024 * 1024,
+	}
+}
+
+// DetectToolchain detects the toolchain from the MCU specified in the keyboard files.
+func DetectToolchain(keyboardDirectoryPath string, revision string) string {
+	return toolchainOf(DetectKeyboardHardware(keyboardDirectoryPath, revision).Processor)
+}
+
+// toolchainOf returns the toolchain compiling for the processor.
+func toolchainOf(processor string) string {
+	lower := strings.ToLower(processor)
+	switch {
+	case lower == "":
+		return ToolchainUnknown
+	case strings.HasPrefix(lower, "atmega"), strings.HasPrefix(lower, "at90usb"),
+		strings.HasPrefix(lower, "attiny"), strings.HasPrefix(lower, "atxmega"):
+		return ToolchainAvr
+	case strings.HasPrefix(lower, "gd32v"):
+		return ToolchainRiscv
+	default:
+		return ToolchainArm
+	}
+}
+
+// createCompilerCacheDirectoryPath creates the path of the cache directory for the QMK Firmware version and the toolchain.
+func createCompilerCacheDirectoryPath(config *CompilerCacheConfig, qmkFirmwareVersion string, toolchain string) string {
+	return filepath.Join(config.BaseDirectoryPath, qmkFirmwareVersion, toolchain)
+}
+
+// createCompilerCacheEnvironment creates the environment variables of ccache.
+// The absolute paths under the QMK Firmware directory are rewritten to the relative paths,
+// so the cache hits across the keyboards. The result of each compilation is written to the stats log.
+// In the read-only mode, the cache is used but not updated, so the untrusted build cannot poison the cache.
+func createCompilerCacheEnvironment(cacheDirectoryPath string, config *CompilerCacheConfig, qmkHomeDirectoryPath string, temporaryDirectoryPath string, readOnly bool) []string {
+	env := []string{
+		"CCACHE_DIR=" + cacheDirectoryPath,
+		"CCACHE_MAXSIZE=" + config.MaxSize,
+		"CCACHE_BASEDIR=" + qmkHomeDirectoryPath,
+		"CCACHE_NOHASHDIR=true",
+		"CCACHE_TEMPDIR=" + temporaryDirectoryPath,
+		"CCACHE_STATSLOG=" + filepath.Join(temporaryDirectoryPath, "stats.log"),
+	}
+	if readOnly {
+		env = append(env, "CCACHE_READONLY=true")
+	}
+	return env
+}
+
+// compilerCacheHitKeys and compilerCacheMissKeys are the keys of the results of the compilation in the stats log.
+var compilerCacheHitKeys = map[string]bool{
+	"direct_cache_hit":       true,
+	"preprocessed_cache_hit": true,
+}
+var compilerCacheMissKeys = map[string]bool{
+	"cache_miss": true,
+}
+
+// compilerCacheUncacheableKeys is the keys of the compilations which ccache could not cache.
+// The other keys in the log, like "local_storage_hit", are the details of the hit or the miss.
+var compilerCacheUncacheableKeys = map[string]bool{
+	"autoconf_test":                    true,
+	"bad_compiler_arguments":           true,
+	"bad_input_file":                   true,
+	"bad_output_file":                  true,
+	"called_for_link":                  true,
+	"called_for_preprocessing":         true,
+	"compile_failed":                   true,
+	"compiler_check_failed":            true,
+	"compiler_produced_empty_output":   true,
+	"compiler_produced_no_output":      true,
+	"compiler_produced_stdout":         true,
+	"could_not_find_compiler":          true,
+	"could_not_use_modules":            true,
+	"could_not_use_precompiled_header": true,
+	"disabled":                         true,
+	"error_hashing_extra_file":         true,
+	"internal_error":                   true,
+	"missing_cache_file":               true,
+	"modified_input_file":              true,
+	"multiple_source_files":            true,
+	"no_input_file":                    true,
+	"output_to_stdout":                 true,
+	"preprocessor_error":               true,
+	"unsupported_code_directive":       true,
+	"unsupported_compiler_option":      true,
+	"unsupported_environment_variable": true,
+	"unsupported_source_encoding":      true,
+	"unsupported_source_language":      true,
+}
+
+// parseCompilerCacheStatsLog counts the hits and the misses in the stats log of ccache.
+// The log consists of the "# <source file>" lines followed by the result of the compilation.
+// The unknown keys are ignored, so the details added by the newer ccache are not counted as the results.
+func parseCompilerCacheStatsLog(content string) common.CompilerCacheStats {
+	var stats common.CompilerCacheStats
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case compilerCacheHitKeys[line]:
+			stats.Hits++
+		case compilerCacheMissKeys[line]:
+			stats.Misses++
+		case compilerCacheUncacheableKeys[line]:
+			stats.Uncacheable++
+		}
+	}
+	return stats
+}
+
+// readCompilerCacheStats reads the stats log written during the build.
+// It returns nil if the log does not exist.
+func readCompilerCacheStats(temporaryDirectoryPath string) *common.CompilerCacheStats {
+	content, err := os.ReadFile(filepath.Join(temporaryDirectoryPath, "stats.log"))
+	if err != nil {
+		return nil
+	}
+	stats := parseCompilerCacheStatsLog(string(content))
+	return &stats
+}
+
+var compilerCacheUsersMutex sync.Mutex
+
+// compilerCacheUsers is the number of the running builds using each cache directory.
+var compilerCacheUsers = map[string]int{}
+
+// acquireCompilerCacheDirectory creates the cache directory and marks it as used by a build and as used now,
+// so it is not evicted while the build is running. releaseCompilerCacheDirectory must be called after the build.
+func acquireCompilerCacheDirectory(cacheDirectoryPath string) error {
+	compilerCacheUsersMutex.Lock()
+	defer compilerCacheUsersMutex.Unlock()
+	err := os.MkdirAll(cacheDirectoryPath, 0755)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = os.Chtimes(cacheDirectoryPath, now, now)
+	if err != nil {
+		return err
+	}
+	compilerCacheUsers[cacheDirectoryPath]++
+	return nil
+}
+
+func releaseCompilerCacheDirectory(cacheDirectoryPath string) {
+	compilerCacheUsersMutex.Lock()
+	defer compilerCacheUsersMutex.Unlock()
+	compilerCacheUsers[cacheDirectoryPath]--
+	if compilerCacheUsers[cacheDirectoryPath] <= 0 {
+		delete(compilerCacheUsers, cacheDirectoryPath)
+	}
+}
+
+// evictCompilerCaches removes the least recently used cache directories while the total size exceeds the maximum.
+// The cache directories used by the running builds are not removed.
+func evictCompilerCaches(config *CompilerCacheConfig) {
+	compilerCacheUsersMutex.Lock()
+	defer compilerCacheUsersMutex.Unlock()
+	type entry struct {
+		path    string
+		modTime time.Time
+		size    int64
+	}
+	var entries []entry
+	var totalSize int64
+	versionEntries, err := os.ReadDir(config.BaseDirectoryPath)
+	if err != nil {
+		log.Printf("[ERROR] %s\n", err.Error())
+		return
+	}
+	for _, versionEntry := range versionEntries {
+		if !versionEntry.IsDir() {
+			continue
+		}
+		versionDirectoryPath := filepath.Join(config.BaseDirectoryPath, versionEntry.Name())
+		toolchainEntries, err := os.ReadDir(versionDirectoryPath)
+		if err != nil {
+			log.Printf("[ERROR] %s\n", err.Error())
+			continue
+		}
+		for _, toolchainEntry := range toolchainEntries {
+			info, err := toolchainEntry.Info()
+			if err != nil || !info.IsDir() {
+				continue
+			}
+			path := filepath.Join(versionDirectoryPath, toolchainEntry.Name())
+			size := directorySize(path)
+			totalSize += size
+			entries = append(entries, entry{path: path, modTime: info.ModTime(), size: size})
+		}
+	}
+	if totalSize <= config.MaxTotalBytes {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, e := range entries {
+		if totalSize <= config.MaxTotalBytes {
+			break
+		}
+		if _, ok := compilerCacheUsers[e.path]; ok {
+			continue
+		}
+		log.Printf("[INFO] Evicting the compiler cache: %s\n", e.path)
+		err = os.RemoveAll(e.path)
+		if err != nil {
+			log.Printf("[ERROR] %s\n", err.Error())
+			continue
+		}
+		totalSize -= e.size
+	}
+}
+
+// directorySize returns the total size of the regular files in the directory.
+func directorySize(directoryPath string) int64 {
+	var size int64
+	filepath.WalkDir(directoryPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,116 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_DetectToolchain(t *testing.T) {
+	cases := []struct {
+		fileName string
+		content  string
+		expected string
+	}{
+		{"keyboard.json", `{"processor": "atmega32u4"}`, ToolchainAvr},
+		{"info.json", `{"processor": "RP2040"}`, ToolchainArm},
+		{"rules.mk", "MCU = STM32F411\n", ToolchainArm},
+		{"rules.mk", "MCU ?= GD32VF103\n", ToolchainRiscv},
+		{"rules.mk", "MCU=at90usb1286\n", ToolchainAvr},
+		{"config.h", "#pragma once\n", ToolchainUnknown},
+	}
+	for _, c := range cases {
+		keyboardDirectoryPath := t.TempDir()
+		revisionDirectoryPath := filepath.Join(keyboardDirectoryPath, "rev1")
+		err := os.MkdirAll(revisionDirectoryPath, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(revisionDirectoryPath, c.fileName), []byte(c.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := DetectToolchain(keyboardDirectoryPath, "rev1")
+		if actual != c.expected {
+			t.Error("Expected", c.expected, "but got", actual, "for", c.content)
+		}
+	}
+}
+
+func Test_createCompilerCacheDirectoryPath(t *testing.T) {
+	config := &CompilerCacheConfig{BaseDirectoryPath: "/root/ccache/", MaxSize: "2G"}
+	actual := createCompilerCacheDirectoryPath(config, "0.22.14", ToolchainAvr)
+	if actual != "/root/ccache/0.22.14/avr" {
+		t.Error("Expected /root/ccache/0.22.14/avr but got", actual)
+	}
+}
+
+func Test_createCompilerCacheEnvironment_ReadOnly(t *testing.T) {
+	config := &CompilerCacheConfig{BaseDirectoryPath: "/root/ccache/", MaxSize: "2G"}
+	actual := createCompilerCacheEnvironment("/root/ccache/0.22.14/avr", config, "/root/versions/0.22.14", "/tmp/foo", true)
+	expected := []string{
+		"CCACHE_DIR=/root/ccache/0.22.14/avr",
+		"CCACHE_MAXSIZE=2G",
+		"CCACHE_BASEDIR=/root/versions/0.22.14",
+		"CCACHE_NOHASHDIR=true",
+		"CCACHE_TEMPDIR=/tmp/foo",
+		"CCACHE_STATSLOG=/tmp/foo/stats.log",
+		"CCACHE_READONLY=true",
+	}
+	if len(actual) != len(expected) {
+		t.Fatal("Expected", expected, "but got", actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Error("Expected", expected[i], "but got", actual[i])
+		}
+	}
+}
+
+func Test_parseCompilerCacheStatsLog(t *testing.T) {
+	content := "# quantum/quantum.c\ndirect_cache_hit\n# quantum/keymap_common.c\npreprocessed_cache_hit\n" +
+		"# keyboards/foo/foo.c\ndirect_cache_miss\npreprocessed_cache_miss\ncache_miss\nlocal_storage_miss\n" +
+		"# lib/lufa/foo.S\nunsupported_source_language\n# keyboards/foo/bar.c\nlocal_storage_hit\nunknown_key\n"
+	actual := parseCompilerCacheStatsLog(content)
+	if actual.Hits != 2 {
+		t.Error("Expected 2 but got", actual.Hits)
+	}
+	if actual.Misses != 1 {
+		t.Error("Expected 1 but got", actual.Misses)
+	}
+	if actual.Uncacheable != 1 {
+		t.Error("Expected 1 but got", actual.Uncacheable)
+	}
+}
+
+func Test_evictCompilerCaches(t *testing.T) {
+	config := &CompilerCacheConfig{BaseDirectoryPath: t.TempDir(), MaxTotalBytes: 20}
+	content := "0123456789"
+	createFiles(t, config.BaseDirectoryPath, "0.22.14/avr/a", "0.22.14/arm/a", "0.23.0/arm/a", "0.23.0/avr/a")
+	for i, path := range []string{"0.22.14/avr", "0.22.14/arm", "0.23.0/arm", "0.23.0/avr"} {
+		err := os.WriteFile(filepath.Join(config.BaseDirectoryPath, path, "a"), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(time.Duration(i-10) * time.Hour)
+		err = os.Chtimes(filepath.Join(config.BaseDirectoryPath, path), modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The oldest cache directory is used by the running build.
+	err := acquireCompilerCacheDirectory(filepath.Join(config.BaseDirectoryPath, "0.22.14/avr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseCompilerCacheDirectory(filepath.Join(config.BaseDirectoryPath, "0.22.14/avr"))
+
+	evictCompilerCaches(config)
+	assertExistence(t, config.BaseDirectoryPath, map[string]bool{
+		"0.22.14/avr": true,
+		"0.22.14/arm": false,
+		"0.23.0/arm":  false,
+		"0.23.0/avr":  true,
+	})
+}
@@ -0,0 +1,200 @@
+package build
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+// userspaceNamePrefix is the prefix of the userspace directories created by the builds.
+const userspaceNamePrefix = "remap_"
+
+// leftoverTreeNamePrefixes is the prefixes of the temporary trees in the base directory, like the copies of the tree
+// used by the reproducibility check and the trees being materialized from the mirrors.
+var leftoverTreeNamePrefixes = []string{".repro-", ".tmp-"}
+
+// topLevelFirmwareExtensions is the extensions of the firmware files which QMK copies to the top level of the tree,
+// and the files converted from them.
+var topLevelFirmwareExtensions = map[string]bool{
+	".hex": true,
+	".bin": true,
+	".uf2": true,
+}
+
+// CleanBuildOutputs removes the outputs of the build of each variant in the tree: the object directory and the files
+// of the target in the build directory, and the firmware files copied to the top level.
+// The keyboard directory is removed by DeleteKeyboardDirectory.
+func CleanBuildOutputs(keyboardId string, qmkFirmwareVersion string, variants []common.BuildVariant) error {
+	return cleanBuildOutputs(QmkFirmwareBaseDirectoryPath+qmkFirmwareVersion, keyboardId, variants)
+}
+
+func cleanBuildOutputs(qmkHomeDirectoryPath string, keyboardId string, variants []common.BuildVariant) error {
+	buildDirectoryPath := filepath.Join(qmkHomeDirectoryPath, ".build")
+	for _, variant := range variants {
+		targetName := createTargetName(BuildOptions{
+			KeyboardId: keyboardId,
+			Revision:   variant.Revision,
+			KeymapName: variant.KeymapName,
+		})
+		err := removeObjectDirectories(buildDirectoryPath, targetName)
+		if err != nil {
+			return err
+		}
+		err = removeTargetFiles(buildDirectoryPath, targetName, nil)
+		if err != nil {
+			return err
+		}
+		err = removeTargetFiles(qmkHomeDirectoryPath, targetName, topLevelFirmwareExtensions)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeObjectDirectories removes the object directories of the target in the build directory, like "obj_<target>".
+// The object directory of the converted keyboard has the converter in its name, like "obj_<target>_rp2040_ce".
+func removeObjectDirectories(buildDirectoryPath string, targetName string) error {
+	entries, err := os.ReadDir(buildDirectoryPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !isObjectDirectoryName(entry.Name(), targetName) {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(buildDirectoryPath, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isObjectDirectoryName returns true if the directory name is of the object directory of the target.
+func isObjectDirectoryName(directoryName string, targetName string) bool {
+	name, ok := strings.CutPrefix(directoryName, "obj_")
+	return ok && isTargetName(name, targetName)
+}
+
+// removeTargetFiles removes the regular files of the target in the directory, like "<target>.elf".
+// If the extensions are passed, only the files with them are removed.
+func removeTargetFiles(directoryPath string, targetName string, extensions map[string]bool) error {
+	entries, err := os.ReadDir(directoryPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if !isTargetFileName(entry.Name(), targetName) {
+			continue
+		}
+		if extensions != nil && !extensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+			continue
+		}
+		err = os.Remove(filepath.Join(directoryPath, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SweepBuildLeftovers removes the leftovers of the builds which crashed before cleaning up, from every tree in
+// the base directory. It must be called before any build starts, because it removes all the keyboard directories,
+// the userspace directories created by the builds, the build directories and the firmware files at the top level.
+// The keyboards are removed from the trees in the Dockerfile, so no keyboard directory is left by the image.
+func SweepBuildLeftovers(baseDirectoryPath string) {
+	log.Println("Sweeping the leftovers of the builds.")
+	entries, err := os.ReadDir(baseDirectoryPath)
+	if err != nil {
+		log.Printf("[ERROR] %s\n", err.Error())
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(baseDirectoryPath, entry.Name())
+		if hasAnyPrefix(entry.Name(), leftoverTreeNamePrefixes) {
+			log.Printf("[INFO] Removing the leftover tree: %s\n", path)
+			err = os.RemoveAll(path)
+			if err != nil {
+				log.Printf("[ERROR] %s\n", err.Error())
+			}
+			continue
+		}
+		err = sweepTree(path)
+		if err != nil {
+			log.Printf("[ERROR] %s\n", err.Error())
+		}
+	}
+}
+
+// sweepTree removes the leftovers of the builds from the tree.
+func sweepTree(qmkHomeDirectoryPath string) error {
+	var paths []string
+	keyboardsDirectoryPath := filepath.Join(qmkHomeDirectoryPath, "keyboards")
+	keyboardEntries, err := os.ReadDir(keyboardsDirectoryPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range keyboardEntries {
+		paths = append(paths, filepath.Join(keyboardsDirectoryPath, entry.Name()))
+	}
+	usersDirectoryPath := filepath.Join(qmkHomeDirectoryPath, "users")
+	userEntries, err := os.ReadDir(usersDirectoryPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range userEntries {
+		if strings.HasPrefix(entry.Name(), userspaceNamePrefix) {
+			paths = append(paths, filepath.Join(usersDirectoryPath, entry.Name()))
+		}
+	}
+	buildDirectoryPath := filepath.Join(qmkHomeDirectoryPath, ".build")
+	buildEntries, err := os.ReadDir(buildDirectoryPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range buildEntries {
+		paths = append(paths, filepath.Join(buildDirectoryPath, entry.Name()))
+	}
+	topLevelEntries, err := os.ReadDir(qmkHomeDirectoryPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range topLevelEntries {
+		if entry.Type().IsRegular() && topLevelFirmwareExtensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+			paths = append(paths, filepath.Join(qmkHomeDirectoryPath, entry.Name()))
+		}
+	}
+	for _, path := range paths {
+		log.Printf("[INFO] Removing the leftover: %s\n", path)
+		err = os.RemoveAll(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
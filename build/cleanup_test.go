@@ -0,0 +1,138 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+func createFiles(t *testing.T, baseDirectoryPath string, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(baseDirectoryPath, path)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(baseDirectoryPath, path), []byte(path), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func assertExistence(t *testing.T, baseDirectoryPath string, expected map[string]bool) {
+	t.Helper()
+	for path, exists := range expected {
+		_, err := os.Stat(filepath.Join(baseDirectoryPath, path))
+		if exists && err != nil {
+			t.Error("Expected", path, "to exist but got", err)
+		}
+		if !exists && !os.IsNotExist(err) {
+			t.Error("Expected", path, "to be removed but got", err)
+		}
+	}
+}
+
+func Test_cleanBuildOutputs(t *testing.T) {
+	qmkHomeDirectoryPath := t.TempDir()
+	createFiles(t, qmkHomeDirectoryPath,
+		".build/obj_foo_rev1_remap/keymap.o",
+		".build/foo_rev1_remap.elf",
+		".build/foo_rev1_remap.map",
+		".build/obj_bar_remap/keymap.o",
+		".build/bar_remap.elf",
+		"foo_rev1_remap.hex",
+		"foo_rev1_remap.uf2",
+		"foo_rev1_remap.txt",
+		"bar_remap.hex",
+		"Makefile",
+	)
+	err := cleanBuildOutputs(qmkHomeDirectoryPath, "foo", []common.BuildVariant{{KeymapName: "remap", Revision: "rev1"}})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	assertExistence(t, qmkHomeDirectoryPath, map[string]bool{
+		".build/obj_foo_rev1_remap":     false,
+		".build/foo_rev1_remap.elf":     false,
+		".build/foo_rev1_remap.map":     false,
+		".build/obj_bar_remap/keymap.o": true,
+		".build/bar_remap.elf":          true,
+		"foo_rev1_remap.hex":            false,
+		"foo_rev1_remap.uf2":            false,
+		"foo_rev1_remap.txt":            true,
+		"bar_remap.hex":                 true,
+		"Makefile":                      true,
+	})
+}
+
+func Test_cleanBuildOutputs_ConvertTo(t *testing.T) {
+	qmkHomeDirectoryPath := t.TempDir()
+	createFiles(t, qmkHomeDirectoryPath,
+		".build/obj_foo_remap_rp2040_ce/keymap.o",
+		".build/foo_remap_rp2040_ce.elf",
+		".build/obj_foobar_remap/keymap.o",
+		".build/obj_foo_remap_via/keymap.o",
+		".build/foo_remap_via.elf",
+		"foo_remap_rp2040_ce.uf2",
+		"foobar_remap.hex",
+		"foo_remap_via.hex",
+	)
+	err := cleanBuildOutputs(qmkHomeDirectoryPath, "foo", []common.BuildVariant{{KeymapName: "remap"}})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	assertExistence(t, qmkHomeDirectoryPath, map[string]bool{
+		".build/obj_foo_remap_rp2040_ce":    false,
+		".build/foo_remap_rp2040_ce.elf":    false,
+		".build/obj_foobar_remap/keymap.o":  true,
+		".build/obj_foo_remap_via/keymap.o": true,
+		".build/foo_remap_via.elf":          true,
+		"foo_remap_rp2040_ce.uf2":           false,
+		"foobar_remap.hex":                  true,
+		"foo_remap_via.hex":                 true,
+	})
+}
+
+func Test_cleanBuildOutputs_NoBuildDirectory(t *testing.T) {
+	err := cleanBuildOutputs(t.TempDir(), "foo", []common.BuildVariant{{KeymapName: "remap"}})
+	if err != nil {
+		t.Error("Expected nil but got", err)
+	}
+}
+
+func Test_SweepBuildLeftovers(t *testing.T) {
+	baseDirectoryPath := t.TempDir()
+	createFiles(t, baseDirectoryPath,
+		"0.22.14/Makefile",
+		"0.22.14/keyboards/foo/rules.mk",
+		"0.22.14/users/remap_abc/rules.mk",
+		"0.22.14/users/bundled/rules.mk",
+		"0.22.14/.build/obj_foo_remap/keymap.o",
+		"0.22.14/.build/foo_remap.elf",
+		"0.22.14/foo_remap.hex",
+		"0.22.14/foo_remap.bin",
+		"0.22.14/requirements.txt",
+		".repro-abc-1/Makefile",
+		".tmp-fork-foo-bar-0123456789ab/Makefile",
+		"versions.json",
+	)
+	SweepBuildLeftovers(baseDirectoryPath)
+	assertExistence(t, baseDirectoryPath, map[string]bool{
+		"0.22.14/Makefile":               true,
+		"0.22.14/keyboards":              true,
+		"0.22.14/keyboards/foo":          false,
+		"0.22.14/users/remap_abc":        false,
+		"0.22.14/users/bundled/rules.mk": true,
+		"0.22.14/.build":                 true,
+		"0.22.14/.build/obj_foo_remap":   false,
+		"0.22.14/.build/foo_remap.elf":   false,
+		"0.22.14/foo_remap.hex":          false,
+		"0.22.14/foo_remap.bin":          false,
+		"0.22.14/requirements.txt":       true,
+		".repro-abc-1":                   false,
+		".tmp-fork-foo-bar-0123456789ab": false,
+		"versions.json":                  true,
+	})
+}
@@ -0,0 +1,87 @@
+package build
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"remap-keys.app/remap-build-server/convert"
+)
+
+// convertArtifacts produces the firmware files of the formats which the build did not produce,
+// next to the original files. The conversion is driven by the MCU and the bootloader of the keyboard:
+//   - The UF2 file is produced with the family ID of the MCU, only if the bootloader accepts it.
+//   - The HEX file and the BIN file are converted from each other.
+//
+// Nothing is produced for the MCU which does not support UF2, like the AVR MCUs.
+func convertArtifacts(artifacts []Artifact, hardware KeyboardHardware) ([]Artifact, error) {
+	target, ok := convert.LookupTarget(hardware.Processor, hardware.Bootloader)
+	if !ok {
+		return nil, nil
+	}
+	sources := map[string]Artifact{}
+	for _, artifact := range artifacts {
+		sources[artifact.Format] = artifact
+	}
+
+	// The HEX file is preferred as the source, because it has the addresses.
+	var image *convert.Image
+	var source Artifact
+	if hexArtifact, ok := sources[ArtifactFormatHex]; ok {
+		content, err := os.ReadFile(hexArtifact.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		image, err = convert.ParseIntelHex(content)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s failed: %s", hexArtifact.FileName, err.Error())
+		}
+		source = hexArtifact
+	} else if binArtifact, ok := sources[ArtifactFormatBin]; ok {
+		content, err := os.ReadFile(binArtifact.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		if len(content) > convert.MaxImageSize {
+			return nil, fmt.Errorf("%s exceeds the limit of %d bytes", binArtifact.FileName, convert.MaxImageSize)
+		}
+		image = convert.NewImage(target.BaseAddress, content)
+		source = binArtifact
+	} else {
+		return nil, nil
+	}
+
+	var converted []Artifact
+	for _, format := range []string{ArtifactFormatHex, ArtifactFormatBin, ArtifactFormatUf2} {
+		if _, ok := sources[format]; ok {
+			continue
+		}
+		if format == ArtifactFormatUf2 && !target.Uf2 {
+			continue
+		}
+		var content []byte
+		switch format {
+		case ArtifactFormatHex:
+			content = convert.FormatIntelHex(image)
+		case ArtifactFormatBin:
+			content = image.Binary()
+		case ArtifactFormatUf2:
+			content = convert.FormatUf2(image, target.FamilyId)
+		}
+		fileName := source.FileName[:len(source.FileName)-len(filepath.Ext(source.FileName))] + "." + format
+		filePath := filepath.Join(filepath.Dir(source.FilePath), fileName)
+		err := os.WriteFile(filePath, content, 0644)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] Converted %s to %s.\n", source.FileName, fileName)
+		converted = append(converted, Artifact{
+			FileName:      fileName,
+			FilePath:      filePath,
+			Format:        format,
+			ConvertedFrom: source.Format,
+		})
+	}
+	return converted, nil
+}
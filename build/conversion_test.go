@@ -0,0 +1,118 @@
+package build
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_convertArtifacts_FromHex(t *testing.T) {
+	directoryPath := t.TempDir()
+	content := ":020000040800F2\n:0400000001020304F2\n:00000001FF\n"
+	writeTopLevelFile(t, directoryPath, "foo_remap.hex", content)
+	artifacts := []Artifact{{FileName: "foo_remap.hex", FilePath: filepath.Join(directoryPath, "foo_remap.hex"), Format: ArtifactFormatHex}}
+
+	actual, err := convertArtifacts(artifacts, KeyboardHardware{Processor: "STM32F411", Bootloader: "uf2boot"})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual) != 2 {
+		t.Fatal("Expected 2 but got", actual)
+	}
+	if actual[0].FileName != "foo_remap.bin" || actual[0].ConvertedFrom != ArtifactFormatHex {
+		t.Error("Expected foo_remap.bin but got", actual[0])
+	}
+	if actual[1].FileName != "foo_remap.uf2" || actual[1].Format != ArtifactFormatUf2 {
+		t.Error("Expected foo_remap.uf2 but got", actual[1])
+	}
+	bin, err := os.ReadFile(filepath.Join(directoryPath, "foo_remap.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bin, []byte{1, 2, 3, 4}) {
+		t.Error("Expected [1 2 3 4] but got", bin)
+	}
+	uf2, err := os.ReadFile(filepath.Join(directoryPath, "foo_remap.uf2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uf2) != 512 {
+		t.Error("Expected 512 but got", len(uf2))
+	}
+}
+
+func Test_convertArtifacts_FromBin(t *testing.T) {
+	directoryPath := t.TempDir()
+	writeTopLevelFile(t, directoryPath, "foo_remap.bin", "\x01\x02\x03\x04")
+	artifacts := []Artifact{{FileName: "foo_remap.bin", FilePath: filepath.Join(directoryPath, "foo_remap.bin"), Format: ArtifactFormatBin}}
+
+	actual, err := convertArtifacts(artifacts, KeyboardHardware{Processor: "STM32F411", Bootloader: "tinyuf2"})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual) != 2 || actual[0].Format != ArtifactFormatHex || actual[1].Format != ArtifactFormatUf2 {
+		t.Fatal("Expected hex and uf2 but got", actual)
+	}
+	hex, err := os.ReadFile(filepath.Join(directoryPath, "foo_remap.hex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The application is placed after the tinyuf2 bootloader.
+	expected := ":020000040801F1\n:0400000001020304F2\n:00000001FF\n"
+	if string(hex) != expected {
+		t.Error("Expected", expected, "but got", string(hex))
+	}
+}
+
+func Test_convertArtifacts_Stm32Dfu(t *testing.T) {
+	directoryPath := t.TempDir()
+	writeTopLevelFile(t, directoryPath, "foo_remap.bin", "\x01\x02\x03\x04")
+	artifacts := []Artifact{{FileName: "foo_remap.bin", FilePath: filepath.Join(directoryPath, "foo_remap.bin"), Format: ArtifactFormatBin}}
+
+	// The STM32 DFU bootloader does not accept the UF2 file.
+	actual, err := convertArtifacts(artifacts, KeyboardHardware{Processor: "STM32F411", Bootloader: "stm32-dfu"})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual) != 1 || actual[0].Format != ArtifactFormatHex {
+		t.Fatal("Expected hex but got", actual)
+	}
+	_, err = os.Stat(filepath.Join(directoryPath, "foo_remap.uf2"))
+	if !os.IsNotExist(err) {
+		t.Error("Expected no UF2 file but got", err)
+	}
+}
+
+func Test_convertArtifacts_Avr(t *testing.T) {
+	directoryPath := t.TempDir()
+	writeTopLevelFile(t, directoryPath, "foo_remap.hex", ":0400000001020304F2\n:00000001FF\n")
+	artifacts := []Artifact{{FileName: "foo_remap.hex", FilePath: filepath.Join(directoryPath, "foo_remap.hex"), Format: ArtifactFormatHex}}
+
+	actual, err := convertArtifacts(artifacts, KeyboardHardware{Processor: "atmega32u4", Bootloader: "caterina"})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual) != 0 {
+		t.Error("Expected no artifacts but got", actual)
+	}
+}
+
+func Test_DetectKeyboardHardware_Revision(t *testing.T) {
+	keyboardDirectoryPath := t.TempDir()
+	writeTopLevelFile(t, keyboardDirectoryPath, "info.json", `{"processor": "STM32F401", "bootloader": "stm32-dfu"}`)
+	err := os.MkdirAll(filepath.Join(keyboardDirectoryPath, "rev2"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTopLevelFile(t, filepath.Join(keyboardDirectoryPath, "rev2"), "rules.mk", "BOOTLOADER = tinyuf2\n")
+
+	actual := DetectKeyboardHardware(keyboardDirectoryPath, "rev2")
+	if actual.Processor != "STM32F401" || actual.Bootloader != "tinyuf2" {
+		t.Error("Expected STM32F401 and tinyuf2 but got", actual)
+	}
+	actual = DetectKeyboardHardware(keyboardDirectoryPath, "")
+	if actual.Processor != "STM32F401" || actual.Bootloader != "stm32-dfu" {
+		t.Error("Expected STM32F401 and stm32-dfu but got", actual)
+	}
+}
@@ -0,0 +1,176 @@
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KeyboardInfo represents the keyboard information resolved by QMK, which the `qmk info -f json` command prints.
+type KeyboardInfo struct {
+	KeyboardName string `json:"keyboard_name"`
+	Manufacturer string `json:"manufacturer"`
+	Usb          struct {
+		Vid string `json:"vid"`
+		Pid string `json:"pid"`
+	} `json:"usb"`
+	MatrixSize struct {
+		Rows int `json:"rows"`
+		Cols int `json:"cols"`
+	} `json:"matrix_size"`
+	Layouts map[string]struct {
+		Layout []LayoutKey `json:"layout"`
+	} `json:"layouts"`
+}
+
+// LayoutKey represents a key of the layout in the keyboard information.
+type LayoutKey struct {
+	Matrix []int    `json:"matrix"`
+	X      float64  `json:"x"`
+	Y      float64  `json:"y"`
+	W      *float64 `json:"w"`
+	H      *float64 `json:"h"`
+}
+
+// ViaDefinition represents the keyboard definition JSON of Remap and VIA.
+type ViaDefinition struct {
+	Name      string     `json:"name"`
+	VendorId  string     `json:"vendorId"`
+	ProductId string     `json:"productId"`
+	Matrix    ViaMatrix  `json:"matrix"`
+	Layouts   ViaLayouts `json:"layouts"`
+}
+
+type ViaMatrix struct {
+	Rows int `json:"rows"`
+	Cols int `json:"cols"`
+}
+
+type ViaLayouts struct {
+	// Keymap is the layout in the KLE (Keyboard Layout Editor) format.
+	Keymap [][]interface{} `json:"keymap"`
+}
+
+// FetchKeyboardInfo runs the `qmk info -f json` command for the keyboard of the build options.
+// Like LintQmkFirmware, the command always runs in the sandbox.
+func FetchKeyboardInfo(options BuildOptions) (*KeyboardInfo, error) {
+	log.Println("Fetching the keyboard information.")
+	config := options.Sandbox
+	if config == nil {
+		config = DefaultSandboxConfig()
+	}
+	stdout, stderr, err := runQmkCommandInSandbox(
+		QmkFirmwareBaseDirectoryPath+options.QmkFirmwareVersion,
+		[]string{"--no-color", "info", "-kb", options.KeyboardTarget(), "-f", "json"},
+		config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err.Error(), stderr)
+	}
+	return ParseKeyboardInfo([]byte(stdout))
+}
+
+// ParseKeyboardInfo parses the output of the `qmk info -f json` command.
+// The lines printed before the JSON, like the warnings, are skipped.
+func ParseKeyboardInfo(output []byte) (*KeyboardInfo, error) {
+	start := bytes.IndexByte(output, '{')
+	if start < 0 {
+		return nil, fmt.Errorf("the keyboard information is not found in the output")
+	}
+	var info KeyboardInfo
+	err := json.Unmarshal(output[start:], &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// CreateViaDefinition creates the skeleton of the keyboard definition from the keyboard information.
+// The layout named "LAYOUT" is used if it exists. Otherwise, the first layout in the name order is used.
+// The menus and the custom keycodes are left for the keyboard owner.
+func CreateViaDefinition(info *KeyboardInfo) (*ViaDefinition, error) {
+	if len(info.Layouts) == 0 {
+		return nil, fmt.Errorf("the keyboard has no layout")
+	}
+	layoutName := "LAYOUT"
+	if _, ok := info.Layouts[layoutName]; !ok {
+		names := make([]string, 0, len(info.Layouts))
+		for name := range info.Layouts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		layoutName = names[0]
+	}
+	keymap, err := createKleKeymap(info.Layouts[layoutName].Layout)
+	if err != nil {
+		return nil, err
+	}
+	name := info.KeyboardName
+	if info.Manufacturer != "" && !strings.HasPrefix(name, info.Manufacturer) {
+		name = info.Manufacturer + " " + name
+	}
+	return &ViaDefinition{
+		Name:      name,
+		VendorId:  info.Usb.Vid,
+		ProductId: info.Usb.Pid,
+		Matrix:    ViaMatrix{Rows: info.MatrixSize.Rows, Cols: info.MatrixSize.Cols},
+		Layouts:   ViaLayouts{Keymap: keymap},
+	}, nil
+}
+
+// createKleKeymap converts the keys of the layout to the rows of the KLE format.
+// Each key is labeled with "row,col" of the matrix, and placed with the relative "x" and "y" properties.
+func createKleKeymap(keys []LayoutKey) ([][]interface{}, error) {
+	sorted := make([]LayoutKey, len(keys))
+	copy(sorted, keys)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Y != sorted[j].Y {
+			return sorted[i].Y < sorted[j].Y
+		}
+		return sorted[i].X < sorted[j].X
+	})
+	rows := [][]interface{}{}
+	currentY := 0.0
+	for i := 0; i < len(sorted); {
+		rowY := sorted[i].Y
+		row := []interface{}{}
+		currentX := 0.0
+		for ; i < len(sorted) && sorted[i].Y == rowY; i++ {
+			key := sorted[i]
+			if len(key.Matrix) != 2 {
+				return nil, fmt.Errorf("the key at (%v, %v) has no matrix position", key.X, key.Y)
+			}
+			properties := map[string]float64{}
+			if len(row) == 0 && rowY != currentY {
+				properties["y"] = rowY - currentY
+			}
+			if key.X != currentX {
+				properties["x"] = key.X - currentX
+			}
+			width := 1.0
+			if key.W != nil && *key.W != 1 {
+				width = *key.W
+				properties["w"] = width
+			}
+			if key.H != nil && *key.H != 1 {
+				properties["h"] = *key.H
+			}
+			if len(properties) > 0 {
+				row = append(row, properties)
+			}
+			row = append(row, strconv.Itoa(key.Matrix[0])+","+strconv.Itoa(key.Matrix[1]))
+			currentX = key.X + width
+		}
+		rows = append(rows, row)
+		currentY = rowY + 1
+	}
+	return rows, nil
+}
+
+// CreateDefinitionFileName creates the name of the keyboard definition file of the task.
+func CreateDefinitionFileName(taskId string) string {
+	return taskId + "_definition.json"
+}
@@ -0,0 +1,72 @@
+package build
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testKeyboardInfoOutput = `Ψ Some warning
+{
+  "keyboard_name": "Foo 60",
+  "manufacturer": "Bar",
+  "usb": {"vid": "0xFEED", "pid": "0x0001", "device_version": "1.0.0"},
+  "matrix_size": {"rows": 2, "cols": 3},
+  "layouts": {
+    "LAYOUT_all": {"layout": [{"matrix": [0, 0], "x": 0, "y": 0}]},
+    "LAYOUT": {"layout": [
+      {"matrix": [0, 0], "x": 0, "y": 0},
+      {"matrix": [0, 1], "x": 1, "y": 0, "w": 1.5},
+      {"matrix": [0, 2], "x": 3, "y": 0},
+      {"matrix": [1, 0], "x": 0.25, "y": 1.5, "w": 1.75},
+      {"matrix": [1, 2], "x": 2, "y": 1.5, "h": 2}
+    ]}
+  }
+}
+`
+
+func Test_ParseKeyboardInfo(t *testing.T) {
+	actual, err := ParseKeyboardInfo([]byte(testKeyboardInfoOutput))
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if actual.KeyboardName != "Foo 60" || actual.Usb.Vid != "0xFEED" || actual.MatrixSize.Cols != 3 {
+		t.Error("Expected the keyboard information but got", actual)
+	}
+	if len(actual.Layouts["LAYOUT"].Layout) != 5 {
+		t.Error("Expected 5 but got", len(actual.Layouts["LAYOUT"].Layout))
+	}
+}
+
+func Test_ParseKeyboardInfo_NoJson(t *testing.T) {
+	_, err := ParseKeyboardInfo([]byte("☒ Invalid keyboard"))
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_CreateViaDefinition(t *testing.T) {
+	info, err := ParseKeyboardInfo([]byte(testKeyboardInfoOutput))
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	definition, err := CreateViaDefinition(info)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	actual, err := json.Marshal(definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"name":"Bar Foo 60","vendorId":"0xFEED","productId":"0x0001","matrix":{"rows":2,"cols":3},` +
+		`"layouts":{"keymap":[["0,0",{"w":1.5},"0,1",{"x":0.5},"0,2"],[{"w":1.75,"x":0.25,"y":0.5},"1,0",{"h":2},"1,2"]]}}`
+	if string(actual) != expected {
+		t.Error("Expected", expected, "but got", string(actual))
+	}
+}
+
+func Test_CreateViaDefinition_NoLayout(t *testing.T) {
+	_, err := CreateViaDefinition(&KeyboardInfo{})
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
@@ -0,0 +1,61 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+const (
+	// MaxFileSizeBytes is the maximum decoded size of each source file.
+	MaxFileSizeBytes int = 1024 * 1024
+	// MaxTotalFileSizeBytes is the maximum decoded size of all the source files of a build.
+	MaxTotalFileSizeBytes int = 16 * 1024 * 1024
+)
+
+// ValidateFilePath checks whether the path of the file stays in the directory where the file is created.
+// The path must be relative, and must not contain the empty, "." or ".." segments.
+func ValidateFilePath(path string) error {
+	if path == "" || strings.HasPrefix(path, "/") || strings.Contains(path, "\\") {
+		return fmt.Errorf("invalid file path: %s", path)
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid file path: %s", path)
+		}
+	}
+	return nil
+}
+
+// ValidateBuildableFiles checks whether the files have the valid paths, can be decoded and respect the size limits.
+// The total size is counted across all the passed file lists.
+// The top-level file cannot be named BuildInfoHeaderFileName, because it would shadow the generated header.
+func ValidateBuildableFiles(buildableFilesList ...[]common.BuildableFile) error {
+	total := 0
+	for _, buildableFiles := range buildableFilesList {
+		for _, buildableFile := range buildableFiles {
+			err := ValidateFilePath(buildableFile.GetPath())
+			if err != nil {
+				return err
+			}
+			if buildableFile.GetPath() == BuildInfoHeaderFileName {
+				return fmt.Errorf("the file name is reserved: %s", BuildInfoHeaderFileName)
+			}
+			content, err := common.DecodeContent(buildableFile)
+			if err != nil {
+				return err
+			}
+			if len(content) > MaxFileSizeBytes {
+				return fmt.Errorf("the size of %s is %d bytes, which exceeds the limit of %d bytes",
+					buildableFile.GetPath(), len(content), MaxFileSizeBytes)
+			}
+			total += len(content)
+		}
+	}
+	if total > MaxTotalFileSizeBytes {
+		return fmt.Errorf("the total size of the files is %d bytes, which exceeds the limit of %d bytes",
+			total, MaxTotalFileSizeBytes)
+	}
+	return nil
+}
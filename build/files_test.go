@@ -0,0 +1,124 @@
+package build
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+func Test_ValidateBuildableFiles_Valid(t *testing.T) {
+	err := ValidateBuildableFiles(
+		[]common.BuildableFile{common.FirmwareFile{Path: "config.h", Content: "#pragma once"}},
+		[]common.BuildableFile{common.FirmwareFile{Path: "logo.bin", Content: "AAEC/w==", Encoding: common.FileEncodingBase64}},
+	)
+	if err != nil {
+		t.Error("Expected nil but got", err)
+	}
+}
+
+func Test_ValidateBuildableFiles_InvalidEncoding(t *testing.T) {
+	err := ValidateBuildableFiles([]common.BuildableFile{
+		common.FirmwareFile{Path: "logo.bin", Content: "AAEC/w==", Encoding: "hex"},
+	})
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_ValidateBuildableFiles_InvalidBase64(t *testing.T) {
+	err := ValidateBuildableFiles([]common.BuildableFile{
+		common.FirmwareFile{Path: "logo.bin", Content: "not base64!", Encoding: common.FileEncodingBase64},
+	})
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_ValidateBuildableFiles_TooLargeFile(t *testing.T) {
+	content := base64.StdEncoding.EncodeToString(make([]byte, MaxFileSizeBytes+1))
+	err := ValidateBuildableFiles([]common.BuildableFile{
+		common.FirmwareFile{Path: "blob.bin", Content: content, Encoding: common.FileEncodingBase64},
+	})
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_ValidateBuildableFiles_TooLargeTotal(t *testing.T) {
+	content := strings.Repeat("a", MaxFileSizeBytes)
+	var buildableFiles []common.BuildableFile
+	for i := 0; i <= MaxTotalFileSizeBytes/MaxFileSizeBytes; i++ {
+		buildableFiles = append(buildableFiles, common.FirmwareFile{Path: "file.h", Content: content})
+	}
+	err := ValidateBuildableFiles(buildableFiles)
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_CreateFiles_Binary(t *testing.T) {
+	baseDirectoryPath := t.TempDir()
+	expected := []byte{0x00, 0x01, 0x02, 0xff, '\r', '\n'}
+	err := CreateFiles(baseDirectoryPath, []common.BuildableFile{
+		common.FirmwareFile{Path: "images/logo.bin", Content: base64.StdEncoding.EncodeToString(expected), Encoding: common.FileEncodingBase64},
+		common.FirmwareFile{Path: "config.h", Content: "#pragma once\r\n"},
+	})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	actual, err := os.ReadFile(filepath.Join(baseDirectoryPath, "images", "logo.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Error("Expected", expected, "but got", actual)
+	}
+	actual, err = os.ReadFile(filepath.Join(baseDirectoryPath, "config.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != "#pragma once\r\n" {
+		t.Error("Expected #pragma once but got", string(actual))
+	}
+}
+
+func Test_ValidateFilePath_Valid(t *testing.T) {
+	for _, path := range []string{"config.h", "keymaps/default/keymap.c", "lib/.hidden/logo.bin"} {
+		err := ValidateFilePath(path)
+		if err != nil {
+			t.Error("Expected nil but got", err, "for", path)
+		}
+	}
+}
+
+func Test_ValidateFilePath_Invalid(t *testing.T) {
+	for _, path := range []string{"", "/etc/passwd", "../config.h", "keymaps/../../rules.mk", "keymaps//keymap.c", "./config.h", "keymaps/", "keymaps\\keymap.c"} {
+		err := ValidateFilePath(path)
+		if err == nil {
+			t.Error("Expected error but got nil for", path)
+		}
+	}
+}
+
+func Test_ValidateBuildableFiles_InvalidPath(t *testing.T) {
+	err := ValidateBuildableFiles([]common.BuildableFile{
+		common.FirmwareFile{Path: "../../../rules.mk", Content: "SRC += evil.c"},
+	})
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_ValidateBuildableFiles_ReservedName(t *testing.T) {
+	err := ValidateBuildableFiles([]common.BuildableFile{
+		common.FirmwareFile{Path: BuildInfoHeaderFileName, Content: "#pragma once"},
+	})
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
@@ -0,0 +1,94 @@
+package build
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MaxDefinesCount is the maximum number of the extra defines of a firmware.
+const MaxDefinesCount int = 64
+
+var yesNoPattern = regexp.MustCompile(`^(yes|no)$`)
+var identifierValuePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+var definePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(=[A-Za-z0-9_.+-]*)?$`)
+
+// allowedEnvironmentVariables is the allowlist of the variables passed with the `-e` option of the `qmk compile` command.
+// The value of each variable must match the pattern.
+var allowedEnvironmentVariables = map[string]*regexp.Regexp{
+	"AUDIO_ENABLE":           yesNoPattern,
+	"AUTO_SHIFT_ENABLE":      yesNoPattern,
+	"BACKLIGHT_ENABLE":       yesNoPattern,
+	"BOOTMAGIC_ENABLE":       yesNoPattern,
+	"CAPS_WORD_ENABLE":       yesNoPattern,
+	"COMBO_ENABLE":           yesNoPattern,
+	"COMMAND_ENABLE":         yesNoPattern,
+	"CONSOLE_ENABLE":         yesNoPattern,
+	"CONVERT_TO":             identifierValuePattern,
+	"DEBOUNCE_TYPE":          identifierValuePattern,
+	"DYNAMIC_MACRO_ENABLE":   yesNoPattern,
+	"ENCODER_ENABLE":         yesNoPattern,
+	"ENCODER_MAP_ENABLE":     yesNoPattern,
+	"EXTRAKEY_ENABLE":        yesNoPattern,
+	"KEY_OVERRIDE_ENABLE":    yesNoPattern,
+	"LEADER_ENABLE":          yesNoPattern,
+	"LTO_ENABLE":             yesNoPattern,
+	"MOUSEKEY_ENABLE":        yesNoPattern,
+	"NKRO_ENABLE":            yesNoPattern,
+	"OLED_ENABLE":            yesNoPattern,
+	"POINTING_DEVICE_ENABLE": yesNoPattern,
+	"RGBLIGHT_ENABLE":        yesNoPattern,
+	"RGB_MATRIX_ENABLE":      yesNoPattern,
+	"TAP_DANCE_ENABLE":       yesNoPattern,
+	"UNICODE_ENABLE":         yesNoPattern,
+	"VIA_ENABLE":             yesNoPattern,
+}
+
+// ValidateBuildFlags checks whether the extra environment variables and defines can be passed to the build.
+// Each environment variable must be in the allowlist and its value must match the pattern of the variable.
+// Each define must be a form of "NAME" or "NAME=VALUE".
+func ValidateBuildFlags(environmentVariables map[string]string, defines []string) error {
+	for key, value := range environmentVariables {
+		pattern, ok := allowedEnvironmentVariables[key]
+		if !ok {
+			return fmt.Errorf("the environment variable is not allowed: %s", key)
+		}
+		if !pattern.MatchString(value) {
+			return fmt.Errorf("invalid value of the environment variable %s: %s", key, value)
+		}
+	}
+	if len(defines) > MaxDefinesCount {
+		return fmt.Errorf("too many defines: %d", len(defines))
+	}
+	for _, define := range defines {
+		if !definePattern.MatchString(define) {
+			return fmt.Errorf("invalid define: %s", define)
+		}
+	}
+	return nil
+}
+
+// createEnvironmentArguments creates the `-e KEY=VALUE` arguments of the `qmk compile` command.
+// The arguments are sorted by the key to make the command line stable.
+func createEnvironmentArguments(environmentVariables map[string]string) []string {
+	keys := make([]string, 0, len(environmentVariables))
+	for key := range environmentVariables {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	args := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		args = append(args, "-e", key+"="+environmentVariables[key])
+	}
+	return args
+}
+
+// createOptDefs creates the value of the OPT_DEFS variable including the passed extra defines.
+func createOptDefs(defines []string) string {
+	optDefs := []string{"-DBUILD_ON_REMAP"}
+	for _, define := range defines {
+		optDefs = append(optDefs, "-D"+define)
+	}
+	return strings.Join(optDefs, " ")
+}
@@ -0,0 +1,79 @@
+package build
+
+import "testing"
+
+func Test_ValidateBuildFlags_Empty(t *testing.T) {
+	err := ValidateBuildFlags(nil, nil)
+	if err != nil {
+		t.Error("Expected nil but got", err)
+	}
+}
+
+func Test_ValidateBuildFlags_AllowedEnvironmentVariables(t *testing.T) {
+	err := ValidateBuildFlags(map[string]string{"CONSOLE_ENABLE": "yes", "CONVERT_TO": "rp2040_ce"}, nil)
+	if err != nil {
+		t.Error("Expected nil but got", err)
+	}
+}
+
+func Test_ValidateBuildFlags_NotAllowedEnvironmentVariable(t *testing.T) {
+	err := ValidateBuildFlags(map[string]string{"CC": "gcc"}, nil)
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_ValidateBuildFlags_InvalidEnvironmentVariableValue(t *testing.T) {
+	err := ValidateBuildFlags(map[string]string{"CONSOLE_ENABLE": "yes $(shell id)"}, nil)
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_ValidateBuildFlags_ValidDefines(t *testing.T) {
+	err := ValidateBuildFlags(nil, []string{"FOO", "TAPPING_TERM=200", "BAR_BAZ="})
+	if err != nil {
+		t.Error("Expected nil but got", err)
+	}
+}
+
+func Test_ValidateBuildFlags_InvalidDefines(t *testing.T) {
+	for _, define := range []string{"", "1FOO", "FOO BAR", "FOO=\"bar\"", "FOO=$(shell id)"} {
+		err := ValidateBuildFlags(nil, []string{define})
+		if err == nil {
+			t.Error("Expected error but got nil for", define)
+		}
+	}
+}
+
+func Test_ValidateBuildFlags_TooManyDefines(t *testing.T) {
+	defines := make([]string, MaxDefinesCount+1)
+	for i := range defines {
+		defines[i] = "FOO"
+	}
+	err := ValidateBuildFlags(nil, defines)
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_createEnvironmentArguments(t *testing.T) {
+	actual := createEnvironmentArguments(map[string]string{"NKRO_ENABLE": "no", "CONSOLE_ENABLE": "yes"})
+	expected := []string{"-e", "CONSOLE_ENABLE=yes", "-e", "NKRO_ENABLE=no"}
+	if len(actual) != len(expected) {
+		t.Fatal("Expected", expected, "but got", actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Error("Expected", expected, "but got", actual)
+		}
+	}
+}
+
+func Test_createOptDefs(t *testing.T) {
+	actual := createOptDefs([]string{"FOO", "BAR=1"})
+	expected := "-DBUILD_ON_REMAP -DFOO -DBAR=1"
+	if actual != expected {
+		t.Error("Expected", expected, "but got", actual)
+	}
+}
@@ -0,0 +1,79 @@
+package build
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// KeyboardHardware represents the MCU and the bootloader specified in the keyboard files.
+type KeyboardHardware struct {
+	Processor  string
+	Bootloader string
+}
+
+var rulesMcuPattern = regexp.MustCompile(`(?m)^\s*MCU\s*[:?]?=\s*(\S+)`)
+var rulesBootloaderPattern = regexp.MustCompile(`(?m)^\s*BOOTLOADER\s*[:?]?=\s*(\S+)`)
+
+// DetectKeyboardHardware detects the MCU and the bootloader of the keyboard.
+// The "processor" and "bootloader" fields of keyboard.json and info.json, and the MCU and BOOTLOADER variables
+// of rules.mk are looked up from the keyboard directory to the revision directory. The deeper directory wins,
+// in the same way as QMK. If the MCU is not found in them, the first MCU found in the keyboard directory is used.
+func DetectKeyboardHardware(keyboardDirectoryPath string, revision string) KeyboardHardware {
+	var hardware KeyboardHardware
+	directoryPath := keyboardDirectoryPath
+	readKeyboardHardware(directoryPath, &hardware)
+	if revision != "" {
+		for _, name := range strings.Split(revision, "/") {
+			directoryPath = filepath.Join(directoryPath, name)
+			readKeyboardHardware(directoryPath, &hardware)
+		}
+	}
+	if hardware.Processor != "" {
+		return hardware
+	}
+	_ = filepath.WalkDir(keyboardDirectoryPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || hardware.Processor != "" {
+			return nil
+		}
+		readKeyboardHardware(path, &hardware)
+		return nil
+	})
+	return hardware
+}
+
+// readKeyboardHardware overwrites the hardware with the values found in the files of the directory.
+func readKeyboardHardware(directoryPath string, hardware *KeyboardHardware) {
+	for _, name := range []string{"info.json", "keyboard.json"} {
+		content, err := os.ReadFile(filepath.Join(directoryPath, name))
+		if err != nil {
+			continue
+		}
+		var info struct {
+			Processor  string `json:"processor"`
+			Bootloader string `json:"bootloader"`
+		}
+		if json.Unmarshal(content, &info) != nil {
+			continue
+		}
+		if info.Processor != "" {
+			hardware.Processor = info.Processor
+		}
+		if info.Bootloader != "" {
+			hardware.Bootloader = info.Bootloader
+		}
+	}
+	content, err := os.ReadFile(filepath.Join(directoryPath, "rules.mk"))
+	if err != nil {
+		return
+	}
+	if match := rulesMcuPattern.FindSubmatch(content); match != nil {
+		hardware.Processor = string(match[1])
+	}
+	if match := rulesBootloaderPattern.FindSubmatch(content); match != nil {
+		hardware.Bootloader = string(match[1])
+	}
+}
@@ -0,0 +1,110 @@
+package build
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+const (
+	// LintModeDisabled means that the lint stage is skipped.
+	LintModeDisabled string = ""
+	// LintModeEnabled means that the lint messages are attached to the task, but the build continues.
+	LintModeEnabled string = "enabled"
+	// LintModeStrict means that the build fails if any lint warning or error is reported.
+	LintModeStrict string = "strict"
+)
+
+const (
+	LintLevelWarning string = "warning"
+	LintLevelError   string = "error"
+)
+
+var ansiEscapePattern = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// LintResult represents the result of the `qmk lint` command.
+type LintResult struct {
+	Success  bool
+	Stdout   string
+	Stderr   string
+	Messages []common.LintMessage
+}
+
+// ValidateLintMode checks whether the lint mode is known.
+func ValidateLintMode(lintMode string) error {
+	switch lintMode {
+	case LintModeDisabled, LintModeEnabled, LintModeStrict:
+		return nil
+	default:
+		return fmt.Errorf("invalid lint mode: %s", lintMode)
+	}
+}
+
+// LintQmkFirmware runs the `qmk lint` command for the keyboard and the keymap of the build options.
+// The command always runs in the sandbox, because it loads the keyboard sources. The sandbox configuration of
+// the build options is used if it is specified.
+func LintQmkFirmware(options BuildOptions) LintResult {
+	log.Println("Linting a QMK Firmware started.")
+	config := options.Sandbox
+	if config == nil {
+		config = DefaultSandboxConfig()
+	}
+	stdoutString, stderrString, err := runQmkCommandInSandbox(
+		QmkFirmwareBaseDirectoryPath+options.QmkFirmwareVersion,
+		[]string{"--no-color", "lint", "-kb", options.KeyboardTarget(), "-km", options.KeymapName},
+		config)
+	log.Println("Linting a QMK Firmware finished.")
+	messages := ParseLintOutput(stdoutString + "\n" + stderrString)
+	for i := range messages {
+		messages[i].KeymapName = options.KeymapName
+		messages[i].Revision = options.Revision
+	}
+	if err != nil {
+		log.Printf("[ERROR] %s\n", err.Error())
+	}
+	return LintResult{
+		Success:  err == nil,
+		Stdout:   stdoutString,
+		Stderr:   stderrString,
+		Messages: messages,
+	}
+}
+
+// ParseLintOutput parses the output of the `qmk lint` command and returns the warnings and errors.
+// Each log line of the QMK CLI starts with the mark of the log level like the followings:
+// * "⚠ ckpr5gut7qls715olr70: Missing LICENSE file"
+// * "☒ ckpr5gut7qls715olr70: Invalid keyboard.json"
+// * "[WARNING] ckpr5gut7qls715olr70: Missing LICENSE file" (without the unicode support)
+// The other lines, like the information lines starting with "Ψ", are ignored.
+func ParseLintOutput(output string) []common.LintMessage {
+	messages := []common.LintMessage{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(ansiEscapePattern.ReplaceAllString(line, ""))
+		level, message := parseLintLine(line)
+		if level == "" || message == "" {
+			continue
+		}
+		messages = append(messages, common.LintMessage{
+			Level:   level,
+			Message: message,
+		})
+	}
+	return messages
+}
+
+func parseLintLine(line string) (string, string) {
+	for _, prefix := range []string{"⚠", "[WARNING]"} {
+		if strings.HasPrefix(line, prefix) {
+			return LintLevelWarning, strings.TrimSpace(strings.TrimPrefix(line, prefix))
+		}
+	}
+	for _, prefix := range []string{"☒", "¬_¬", "[ERROR]", "[CRITICAL]"} {
+		if strings.HasPrefix(line, prefix) {
+			return LintLevelError, strings.TrimSpace(strings.TrimPrefix(line, prefix))
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,69 @@
+package build
+
+import "testing"
+
+func Test_ValidateLintMode(t *testing.T) {
+	for _, lintMode := range []string{LintModeDisabled, LintModeEnabled, LintModeStrict} {
+		err := ValidateLintMode(lintMode)
+		if err != nil {
+			t.Error("Expected nil but got", err)
+		}
+	}
+	err := ValidateLintMode("foo")
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_ParseLintOutput_Empty(t *testing.T) {
+	actual := ParseLintOutput("")
+	if len(actual) != 0 {
+		t.Error("Expected 0 but got", len(actual))
+	}
+}
+
+func Test_ParseLintOutput_NoProblems(t *testing.T) {
+	actual := ParseLintOutput("Ψ Lint check passed!\n")
+	if len(actual) != 0 {
+		t.Error("Expected 0 but got", len(actual))
+	}
+}
+
+func Test_ParseLintOutput_WarningsAndErrors(t *testing.T) {
+	output := `Ψ Linting ckpr5gut7qls715olr70
+⚠ ckpr5gut7qls715olr70: Missing LICENSE file
+☒ ckpr5gut7qls715olr70: Invalid keyboard.json
+[WARNING] ckpr5gut7qls715olr70/keymaps/remap: Build marker "keyboard.json" not found.
+[ERROR] Lint check failed for ckpr5gut7qls715olr70!`
+	actual := ParseLintOutput(output)
+	if len(actual) != 4 {
+		t.Fatal("Expected 4 but got", len(actual))
+	}
+	expected := []struct {
+		level   string
+		message string
+	}{
+		{LintLevelWarning, "ckpr5gut7qls715olr70: Missing LICENSE file"},
+		{LintLevelError, "ckpr5gut7qls715olr70: Invalid keyboard.json"},
+		{LintLevelWarning, `ckpr5gut7qls715olr70/keymaps/remap: Build marker "keyboard.json" not found.`},
+		{LintLevelError, "Lint check failed for ckpr5gut7qls715olr70!"},
+	}
+	for i, e := range expected {
+		if actual[i].Level != e.level {
+			t.Error("Expected", e.level, "but got", actual[i].Level)
+		}
+		if actual[i].Message != e.message {
+			t.Error("Expected", e.message, "but got", actual[i].Message)
+		}
+	}
+}
+
+func Test_ParseLintOutput_WithColor(t *testing.T) {
+	actual := ParseLintOutput("\x1b[33m⚠\x1b[0m foo: bar")
+	if len(actual) != 1 {
+		t.Fatal("Expected 1 but got", len(actual))
+	}
+	if actual[0].Level != LintLevelWarning || actual[0].Message != "foo: bar" {
+		t.Error("Expected the warning foo: bar but got", actual[0])
+	}
+}
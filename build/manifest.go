@@ -0,0 +1,162 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+// ManifestSchemaVersion is the version of the manifest format.
+const ManifestSchemaVersion int = 1
+
+// Manifest describes what a built firmware file is. It is published next to the firmware files,
+// so the flashing tools and the support staff can identify the firmware file.
+type Manifest struct {
+	SchemaVersion        int                    `json:"schemaVersion"`
+	TaskId               string                 `json:"taskId"`
+	Uid                  string                 `json:"uid"`
+	FirmwareId           string                 `json:"firmwareId,omitempty"`
+	ProjectId            string                 `json:"projectId,omitempty"`
+	KeyboardDefinitionId string                 `json:"keyboardDefinitionId,omitempty"`
+	KeyboardId           string                 `json:"keyboardId"`
+	QmkFirmwareVersion   string                 `json:"qmkFirmwareVersion"`
+	Parameters           *common.ParametersJson `json:"parameters,omitempty"`
+	ParameterHash        string                 `json:"parameterHash,omitempty"`
+	EnvironmentVariables map[string]string      `json:"environmentVariables,omitempty"`
+	Defines              []string               `json:"defines,omitempty"`
+	Processor            string                 `json:"processor,omitempty"`
+	Bootloader           string                 `json:"bootloader,omitempty"`
+	Toolchains           map[string]string      `json:"toolchains,omitempty"`
+	Artifacts            []ManifestArtifact     `json:"artifacts"`
+	RequestedAt          time.Time              `json:"requestedAt"`
+	StartedAt            time.Time              `json:"startedAt"`
+	FinishedAt           time.Time              `json:"finishedAt"`
+}
+
+// ManifestArtifact describes a firmware file in the manifest.
+type ManifestArtifact struct {
+	KeymapName       string `json:"keymapName"`
+	Revision         string `json:"revision,omitempty"`
+	FileName         string `json:"fileName"`
+	FirmwareFilePath string `json:"firmwareFilePath"`
+	Format           string `json:"format"`
+	ConvertedFrom    string `json:"convertedFrom,omitempty"`
+	Size             int64  `json:"size"`
+	Sha256           string `json:"sha256"`
+}
+
+// NewManifest creates the manifest from the task and the build options.
+// The firmware and the parameters are nil for the builds of the Workbench projects.
+func NewManifest(taskId string, task *common.Task, firmware *common.Firmware, parametersJson *common.ParametersJson, options BuildOptions) *Manifest {
+	manifest := &Manifest{
+		SchemaVersion:        ManifestSchemaVersion,
+		TaskId:               taskId,
+		Uid:                  task.Uid,
+		FirmwareId:           task.FirmwareId,
+		ProjectId:            task.ProjectId,
+		KeyboardId:           options.KeyboardId,
+		QmkFirmwareVersion:   options.QmkFirmwareVersion,
+		Parameters:           parametersJson,
+		EnvironmentVariables: options.EnvironmentVariables,
+		Defines:              options.Defines,
+		Artifacts:            []ManifestArtifact{},
+		RequestedAt:          task.CreatedAt,
+		StartedAt:            time.Now(),
+	}
+	if options.BuildInfo != nil {
+		manifest.ParameterHash = options.BuildInfo.ParameterHash
+	}
+	if firmware != nil {
+		manifest.KeyboardDefinitionId = firmware.KeyboardDefinitionId
+	}
+	return manifest
+}
+
+// AddArtifact adds the firmware file uploaded to the remote path with the hash and the size of the local file.
+func (m *Manifest) AddArtifact(variant common.BuildVariant, artifact Artifact, remoteFirmwareFilePath string) error {
+	file, err := os.Open(artifact.FilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	m.Artifacts = append(m.Artifacts, ManifestArtifact{
+		KeymapName:       variant.KeymapName,
+		Revision:         variant.Revision,
+		FileName:         artifact.FileName,
+		FirmwareFilePath: remoteFirmwareFilePath,
+		Format:           artifact.Format,
+		ConvertedFrom:    artifact.ConvertedFrom,
+		Size:             size,
+		Sha256:           hex.EncodeToString(hash.Sum(nil)),
+	})
+	return nil
+}
+
+// Finish records the hardware, the versions of the toolchain used for it and the finished time.
+func (m *Manifest) Finish(hardware KeyboardHardware) {
+	m.Processor = hardware.Processor
+	m.Bootloader = hardware.Bootloader
+	m.Toolchains = FetchToolchainVersions(toolchainOf(hardware.Processor))
+	m.FinishedAt = time.Now()
+}
+
+// Marshal returns the manifest in the indented JSON format.
+func (m *Manifest) Marshal() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// CreateManifestFileName creates the name of the manifest file of the task.
+func CreateManifestFileName(taskId string) string {
+	return taskId + "_manifest.json"
+}
+
+// toolchainCommands is the commands printing the version of each tool, keyed by the toolchain.
+var toolchainCommands = map[string]map[string][]string{
+	ToolchainAvr:   {"avr-gcc": {"avr-gcc", "-dumpversion"}},
+	ToolchainArm:   {"arm-none-eabi-gcc": {"arm-none-eabi-gcc", "-dumpversion"}},
+	ToolchainRiscv: {"riscv32-unknown-elf-gcc": {"riscv32-unknown-elf-gcc", "-dumpversion"}},
+}
+
+var toolchainVersionsCache = map[string]map[string]string{}
+var toolchainVersionsMutex sync.Mutex
+
+// FetchToolchainVersions fetches the versions of the QMK CLI and the compiler of the toolchain.
+// The tools are not changed while the server is running, so the result is cached.
+// The tools which cannot be run are omitted.
+func FetchToolchainVersions(toolchain string) map[string]string {
+	toolchainVersionsMutex.Lock()
+	defer toolchainVersionsMutex.Unlock()
+	if versions, ok := toolchainVersionsCache[toolchain]; ok {
+		return versions
+	}
+	commands := map[string][]string{"qmk": {QmkCommandPath, "--version"}}
+	for name, command := range toolchainCommands[toolchain] {
+		commands[name] = command
+	}
+	versions := map[string]string{}
+	for name, command := range commands {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		output, err := exec.CommandContext(ctx, command[0], command[1:]...).Output()
+		cancel()
+		if err != nil {
+			continue
+		}
+		versions[name] = strings.TrimSpace(string(output))
+	}
+	toolchainVersionsCache[toolchain] = versions
+	return versions
+}
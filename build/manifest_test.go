@@ -0,0 +1,99 @@
+package build
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+func Test_NewManifest(t *testing.T) {
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	task := &common.Task{Uid: "user1", FirmwareId: "firmware1", CreatedAt: createdAt}
+	firmware := &common.Firmware{KeyboardDefinitionId: "definition1"}
+	parametersJson := &common.ParametersJson{Version: 1}
+	options := BuildOptions{
+		KeyboardId:           "keyboard1",
+		QmkFirmwareVersion:   "0.22.14",
+		EnvironmentVariables: map[string]string{"RGBLIGHT_ENABLE": "yes"},
+		Defines:              []string{"FOO"},
+		BuildInfo:            &BuildInfo{ParameterHash: "0123abcd"},
+	}
+	actual := NewManifest("task1", task, firmware, parametersJson, options)
+	if actual.TaskId != "task1" || actual.Uid != "user1" || actual.FirmwareId != "firmware1" {
+		t.Error("Expected the task information but got", actual)
+	}
+	if actual.KeyboardDefinitionId != "definition1" || actual.KeyboardId != "keyboard1" {
+		t.Error("Expected the keyboard information but got", actual)
+	}
+	if actual.QmkFirmwareVersion != "0.22.14" || actual.Parameters != parametersJson {
+		t.Error("Expected the version and the parameters but got", actual)
+	}
+	if actual.EnvironmentVariables["RGBLIGHT_ENABLE"] != "yes" || actual.Defines[0] != "FOO" {
+		t.Error("Expected the build flags but got", actual)
+	}
+	if !actual.RequestedAt.Equal(createdAt) {
+		t.Error("Expected", createdAt, "but got", actual.RequestedAt)
+	}
+	if actual.ParameterHash != "0123abcd" {
+		t.Error("Expected 0123abcd but got", actual.ParameterHash)
+	}
+}
+
+func Test_Manifest_AddArtifact(t *testing.T) {
+	directoryPath := t.TempDir()
+	writeTopLevelFile(t, directoryPath, "keyboard1_remap.hex", "abc")
+	manifest := NewManifest("task1", &common.Task{}, nil, nil, BuildOptions{})
+	err := manifest.AddArtifact(
+		common.BuildVariant{KeymapName: "remap"},
+		Artifact{FileName: "keyboard1_remap.hex", FilePath: filepath.Join(directoryPath, "keyboard1_remap.hex"), Format: ArtifactFormatHex},
+		"firmware/user1/built/keyboard1_remap-1.hex")
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(manifest.Artifacts) != 1 {
+		t.Fatal("Expected 1 but got", len(manifest.Artifacts))
+	}
+	actual := manifest.Artifacts[0]
+	expected := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if actual.Sha256 != expected {
+		t.Error("Expected", expected, "but got", actual.Sha256)
+	}
+	if actual.Size != 3 {
+		t.Error("Expected 3 but got", actual.Size)
+	}
+	if actual.FirmwareFilePath != "firmware/user1/built/keyboard1_remap-1.hex" {
+		t.Error("Expected the remote path but got", actual.FirmwareFilePath)
+	}
+}
+
+func Test_Manifest_Marshal(t *testing.T) {
+	manifest := NewManifest("task1", &common.Task{ProjectId: "project1"}, nil, nil, BuildOptions{KeyboardId: "keyboard1"})
+	content, err := manifest.Marshal()
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	var actual map[string]interface{}
+	err = json.Unmarshal(content, &actual)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if actual["schemaVersion"] != float64(ManifestSchemaVersion) || actual["projectId"] != "project1" {
+		t.Error("Expected the schema version and the project ID but got", actual)
+	}
+	if _, ok := actual["firmwareId"]; ok {
+		t.Error("Expected no firmware ID but got", actual["firmwareId"])
+	}
+	if _, ok := actual["artifacts"].([]interface{}); !ok {
+		t.Error("Expected the empty artifacts but got", actual["artifacts"])
+	}
+}
+
+func Test_CreateManifestFileName(t *testing.T) {
+	actual := CreateManifestFileName("task1")
+	if actual != "task1_manifest.json" {
+		t.Error("Expected task1_manifest.json but got", actual)
+	}
+}
@@ -0,0 +1,63 @@
+package build
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+// MinFreeDiskBytes is the minimum free space of the filesystem of the QMK Firmware tree required to start a build.
+const MinFreeDiskBytes uint64 = 2 * 1024 * 1024 * 1024
+
+// InfrastructureError represents a failure of the build environment, not of the sources of the user,
+// like the missing QMK Firmware tree or the full disk. The task failed by it can be retried.
+type InfrastructureError struct {
+	Message string
+}
+
+func (e *InfrastructureError) Error() string {
+	return e.Message
+}
+
+// Preflight checks whether the build environment can build the variants: the QMK Firmware tree and the QMK CLI are
+// installed, the disk has enough free space, and the compiler for the MCU of each variant is installed.
+// The keyboard files must be created before calling it, because the MCU is detected from them.
+// It returns an InfrastructureError if any check fails.
+func Preflight(options BuildOptions, variants []common.BuildVariant) error {
+	return preflight(QmkFirmwareBaseDirectoryPath+options.QmkFirmwareVersion, QmkCommandPath, MinFreeDiskBytes, options.KeyboardId, variants)
+}
+
+func preflight(qmkHomeDirectoryPath string, qmkCommandPath string, minFreeDiskBytes uint64, keyboardId string, variants []common.BuildVariant) error {
+	log.Println("Checking the build environment.")
+	_, err := os.Stat(filepath.Join(qmkHomeDirectoryPath, "Makefile"))
+	if err != nil {
+		return &InfrastructureError{Message: fmt.Sprintf("the QMK Firmware tree is not installed: %s", qmkHomeDirectoryPath)}
+	}
+	info, err := os.Stat(qmkCommandPath)
+	if err != nil || info.IsDir() || info.Mode().Perm()&0111 == 0 {
+		return &InfrastructureError{Message: fmt.Sprintf("the QMK CLI is not installed: %s", qmkCommandPath)}
+	}
+	freeBytes, err := freeDiskBytes(qmkHomeDirectoryPath)
+	if err != nil {
+		return &InfrastructureError{Message: fmt.Sprintf("checking the free space failed: %s", err.Error())}
+	}
+	if freeBytes < minFreeDiskBytes {
+		return &InfrastructureError{Message: fmt.Sprintf("the disk has only %d bytes free, but %d bytes are required", freeBytes, minFreeDiskBytes)}
+	}
+	keyboardDirectoryPath := filepath.Join(qmkHomeDirectoryPath, "keyboards", keyboardId)
+	for _, variant := range variants {
+		toolchain := DetectToolchain(keyboardDirectoryPath, variant.Revision)
+		// The compiler for the unknown toolchain cannot be decided, so the build reports it if it is missing.
+		for compiler := range toolchainCommands[toolchain] {
+			_, err := exec.LookPath(compiler)
+			if err != nil {
+				return &InfrastructureError{Message: fmt.Sprintf("the compiler %s for the %s toolchain is not installed", compiler, toolchain)}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build linux
+
+package build
+
+import "syscall"
+
+// freeDiskBytes returns the size of the space available to the unprivileged users in the filesystem of the path.
+func freeDiskBytes(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
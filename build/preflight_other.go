@@ -0,0 +1,9 @@
+//go:build !linux
+
+package build
+
+import "fmt"
+
+func freeDiskBytes(path string) (uint64, error) {
+	return 0, fmt.Errorf("checking the free space is supported only on Linux")
+}
@@ -0,0 +1,84 @@
+package build
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+// createPreflightEnvironment creates the QMK Firmware tree with the keyboard "foo" of the MCU,
+// the QMK CLI and the directory of the compilers set to PATH.
+func createPreflightEnvironment(t *testing.T, mcu string, compilers ...string) (string, string) {
+	t.Helper()
+	qmkHomeDirectoryPath := t.TempDir()
+	createFiles(t, qmkHomeDirectoryPath, "Makefile")
+	err := os.MkdirAll(filepath.Join(qmkHomeDirectoryPath, "keyboards", "foo"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(qmkHomeDirectoryPath, "keyboards", "foo", "rules.mk"), []byte("MCU = "+mcu+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binDirectoryPath := t.TempDir()
+	for _, name := range append(compilers, "qmk") {
+		err = os.WriteFile(filepath.Join(binDirectoryPath, name), []byte("#!/bin/sh\n"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", binDirectoryPath)
+	return qmkHomeDirectoryPath, filepath.Join(binDirectoryPath, "qmk")
+}
+
+func assertInfrastructureError(t *testing.T, err error) {
+	t.Helper()
+	var infrastructureError *InfrastructureError
+	if !errors.As(err, &infrastructureError) {
+		t.Error("Expected InfrastructureError but got", err)
+	}
+}
+
+func Test_preflight_Valid(t *testing.T) {
+	qmkHomeDirectoryPath, qmkCommandPath := createPreflightEnvironment(t, "atmega32u4", "avr-gcc")
+	err := preflight(qmkHomeDirectoryPath, qmkCommandPath, 0, "foo", []common.BuildVariant{{KeymapName: "remap"}})
+	if err != nil {
+		t.Error("Expected nil but got", err)
+	}
+}
+
+func Test_preflight_MissingTree(t *testing.T) {
+	_, qmkCommandPath := createPreflightEnvironment(t, "atmega32u4", "avr-gcc")
+	err := preflight(filepath.Join(t.TempDir(), "0.22.14"), qmkCommandPath, 0, "foo", []common.BuildVariant{{KeymapName: "remap"}})
+	assertInfrastructureError(t, err)
+}
+
+func Test_preflight_MissingQmkCommand(t *testing.T) {
+	qmkHomeDirectoryPath, _ := createPreflightEnvironment(t, "atmega32u4", "avr-gcc")
+	err := preflight(qmkHomeDirectoryPath, filepath.Join(t.TempDir(), "qmk"), 0, "foo", []common.BuildVariant{{KeymapName: "remap"}})
+	assertInfrastructureError(t, err)
+}
+
+func Test_preflight_DiskFull(t *testing.T) {
+	qmkHomeDirectoryPath, qmkCommandPath := createPreflightEnvironment(t, "atmega32u4", "avr-gcc")
+	err := preflight(qmkHomeDirectoryPath, qmkCommandPath, math.MaxUint64, "foo", []common.BuildVariant{{KeymapName: "remap"}})
+	assertInfrastructureError(t, err)
+}
+
+func Test_preflight_MissingCompiler(t *testing.T) {
+	qmkHomeDirectoryPath, qmkCommandPath := createPreflightEnvironment(t, "STM32F411", "avr-gcc")
+	err := preflight(qmkHomeDirectoryPath, qmkCommandPath, 0, "foo", []common.BuildVariant{{KeymapName: "remap"}})
+	assertInfrastructureError(t, err)
+}
+
+func Test_preflight_UnknownToolchain(t *testing.T) {
+	qmkHomeDirectoryPath, qmkCommandPath := createPreflightEnvironment(t, "")
+	err := preflight(qmkHomeDirectoryPath, qmkCommandPath, 0, "foo", []common.BuildVariant{{KeymapName: "remap"}})
+	if err != nil {
+		t.Error("Expected nil but got", err)
+	}
+}
@@ -1,13 +1,15 @@
 package build
 
 import (
-	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
-	"time"
+	"regexp"
 
 	"github.com/rs/xid"
 	"remap-keys.app/remap-build-server/common"
@@ -16,11 +18,61 @@ import (
 // QmkFirmwareBaseDirectoryPath is QMK Firmware base directory path.
 const QmkFirmwareBaseDirectoryPath string = "/root/versions/"
 
+// QmkCommandPath is the path of the QMK CLI installed in the Dockerfile.
+const QmkCommandPath string = "/root/.local/bin/qmk"
+
+// DefaultKeymapName is the keymap name used when neither the task nor the firmware specifies it.
+const DefaultKeymapName string = "remap"
+
+var keymapNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+var revisionPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+(/[a-zA-Z0-9_-]+)*$`)
+
+// BuildOptions represents the options to build a QMK Firmware.
+type BuildOptions struct {
+	KeyboardId         string
+	Revision           string
+	KeymapName         string
+	QmkFirmwareVersion string
+	// EnvironmentVariables is passed with the `-e` option. It must be validated by ValidateBuildFlags.
+	EnvironmentVariables map[string]string
+	// Defines is appended to the OPT_DEFS variable. It must be validated by ValidateBuildFlags.
+	Defines []string
+	// UserName is the name of the userspace directory. The empty string means that no userspace is used.
+	UserName string
+	// Sandbox is the sandbox configuration of the compile step. nil means that the sandbox is not used.
+	Sandbox *SandboxConfig
+	// CompilerCache is the compiler cache configuration. nil means that the compiler cache is not used.
+	// The sandboxed build uses the cache in the read-only mode.
+	CompilerCache *CompilerCacheConfig
+	// BuildInfo is embedded in the firmware with the header and the defines. nil means that it is not embedded.
+	BuildInfo *BuildInfo
+	// Reproducible removes the timestamps and the paths of the build machine from the firmware.
+	// It is set only by CheckReproducibility, because it also fixes the version and the build date shown by QMK.
+	Reproducible bool
+}
+
+// KeyboardTarget returns the keyboard name passed to the `qmk compile` command.
+// If the revision is specified, it is appended after the keyboard ID with the "/" character.
+func (o BuildOptions) KeyboardTarget() string {
+	if o.Revision == "" {
+		return o.KeyboardId
+	}
+	return o.KeyboardId + "/" + o.Revision
+}
+
 // BuildResult represents the result of the build.
 type BuildResult struct {
 	Success bool
 	Stdout  string
 	Stderr  string
+	// LimitExceeded is the limit of the sandbox hit during the build, like "cpu" or "memory".
+	LimitExceeded string
+	// CompilerCacheStats is the hits and the misses of the compiler cache. nil if the cache is not used.
+	CompilerCacheStats *common.CompilerCacheStats
+	// Artifacts is the firmware files produced by the successful build.
+	Artifacts []Artifact
+	// Hardware is the MCU and the bootloader of the keyboard detected after the successful build.
+	Hardware KeyboardHardware
 }
 
 // GenerateKeyboardId generates the keyboard ID.
@@ -34,13 +86,13 @@ func GenerateKeyboardId(keyboardDirectoryName string) string {
 	return guid.String()
 }
 
-func createFile(path string, content string) error {
+func createFile(path string, content []byte) error {
 	f, err := os.Create(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	_, err = f.WriteString(content)
+	_, err = f.Write(content)
 	if err != nil {
 		return err
 	}
@@ -66,7 +118,11 @@ func CreateFiles(baseDirectoryPath string, buildableFiles []common.BuildableFile
 		}
 		targetFilePath := filepath.Join(targetDirectoryPath, file)
 		log.Printf("[INFO] targetFilePath: %s\n", targetFilePath)
-		err := createFile(targetFilePath, buildableFile.GetContent())
+		content, err := common.DecodeContent(buildableFile)
+		if err != nil {
+			return err
+		}
+		err = createFile(targetFilePath, content)
 		if err != nil {
 			return err
 		}
@@ -74,38 +130,285 @@ func CreateFiles(baseDirectoryPath string, buildableFiles []common.BuildableFile
 	return nil
 }
 
+// ValidateKeymapName checks whether the keymap name can be used as a keymap directory name.
+func ValidateKeymapName(keymapName string) error {
+	if !keymapNamePattern.MatchString(keymapName) {
+		return fmt.Errorf("invalid keymap name: %s", keymapName)
+	}
+	return nil
+}
+
+// ValidateRevision checks whether the revision can be used as a keyboard revision directory path.
+// The empty string is valid and means that the keyboard has no revision.
+func ValidateRevision(revision string) error {
+	if revision == "" {
+		return nil
+	}
+	if !revisionPattern.MatchString(revision) {
+		return fmt.Errorf("invalid revision: %s", revision)
+	}
+	return nil
+}
+
+// ResolveBuildVariants resolves the variants built in the task.
+// The keymap name of each variant is decided in the following order:
+//  1. The keymap name of the variant.
+//  2. The keymap name of the task.
+//  3. The passed default keymap name (the keymap name of the firmware or the project).
+//  4. DefaultKeymapName.
+//
+// If the task has no variants, one variant without the revision is returned.
+func ResolveBuildVariants(task *common.Task, defaultKeymapName string) ([]common.BuildVariant, error) {
+	keymapName := DefaultKeymapName
+	if defaultKeymapName != "" {
+		keymapName = defaultKeymapName
+	}
+	if task.KeymapName != "" {
+		keymapName = task.KeymapName
+	}
+	variants := task.Variants
+	if len(variants) == 0 {
+		variants = []common.BuildVariant{{}}
+	}
+	result := make([]common.BuildVariant, len(variants))
+	for i, variant := range variants {
+		if variant.KeymapName == "" {
+			variant.KeymapName = keymapName
+		}
+		err := ValidateKeymapName(variant.KeymapName)
+		if err != nil {
+			return nil, err
+		}
+		err = ValidateRevision(variant.Revision)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = variant
+	}
+	return result, nil
+}
+
+// CreateKeymapFiles creates the keymap files into the keymap directory of each keymap used by the variants.
+func CreateKeymapFiles(keyboardDirectoryPath string, variants []common.BuildVariant, buildableFiles []common.BuildableFile) error {
+	created := map[string]bool{}
+	for _, variant := range variants {
+		if created[variant.KeymapName] {
+			continue
+		}
+		keymapDirectoryPath := filepath.Join(keyboardDirectoryPath, "keymaps", variant.KeymapName)
+		err := os.MkdirAll(keymapDirectoryPath, 0755)
+		if err != nil {
+			return err
+		}
+		err = CreateFiles(keymapDirectoryPath, buildableFiles)
+		if err != nil {
+			return err
+		}
+		created[variant.KeymapName] = true
+	}
+	return nil
+}
+
 // BuildQmkFirmware builds a QMK Firmware.
-func BuildQmkFirmware(keyboardId string, qmkFirmwareVersion string) BuildResult {
+// If the sandbox configuration is specified, the compile step runs in the sandbox and
+// the LimitExceeded field of the result reports which limit was hit.
+func BuildQmkFirmware(options BuildOptions) BuildResult {
 	log.Println("Building a QMK Firmware started.")
-	cmd := exec.Command(
-		"/root/.local/bin/qmk", "compile",
-		"-kb", keyboardId,
-		"-km", "remap")
-	cmd.Dir = "/root/versions/" + qmkFirmwareVersion
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, "OPT_DEFS=-DBUILD_ON_REMAP")
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	err := cmd.Run()
+	qmkHomeDirectoryPath := QmkFirmwareBaseDirectoryPath + options.QmkFirmwareVersion
+	args := []string{
+		"compile",
+		"-kb", options.KeyboardTarget(),
+		"-km", options.KeymapName}
+	args = append(args, createEnvironmentArguments(options.EnvironmentVariables)...)
+	if options.UserName != "" {
+		args = append(args, "-e", "USER_NAME="+options.UserName)
+	}
+	defines := options.Defines
+	if options.BuildInfo != nil {
+		definitions, err := createBuildInfoDefinitions(options.BuildInfo)
+		if err != nil {
+			return createErrorBuildResult(err)
+		}
+		err = writeBuildInfoHeader(filepath.Join(qmkHomeDirectoryPath, "keyboards", options.KeyboardId), definitions)
+		if err != nil {
+			return createErrorBuildResult(err)
+		}
+		defines = append(append([]string{}, options.Defines...), BuildInfoDefine)
+		defines = append(defines, createBuildInfoDefines(definitions)...)
+	}
+	optDefs := "OPT_DEFS=" + createOptDefs(defines)
+	if options.CompilerCache != nil {
+		args = append(args, "-e", "CC_PREFIX=ccache")
+	}
+
+	var cmd *exec.Cmd
+	var temporaryDirectoryPath string
+	ctx := context.Background()
+	outputLimit := int64(math.MaxInt64)
+	if options.Sandbox == nil {
+		cmd = exec.Command(QmkCommandPath, args...)
+		cmd.Env = append(os.Environ(), createPythonPathEnvironment(qmkHomeDirectoryPath)...)
+		if options.CompilerCache != nil {
+			var err error
+			temporaryDirectoryPath, err = os.MkdirTemp("", "remap-ccache-")
+			if err != nil {
+				return createErrorBuildResult(err)
+			}
+			defer os.RemoveAll(temporaryDirectoryPath)
+		}
+	} else {
+		log.Println("The sandbox is enabled.")
+		homeDirectoryPath, err := os.MkdirTemp("", "remap-sandbox-")
+		if err != nil {
+			return createErrorBuildResult(err)
+		}
+		defer os.RemoveAll(homeDirectoryPath)
+		outputTargetName := createOutputTargetName(options, filepath.Join(qmkHomeDirectoryPath, "keyboards", options.KeyboardId))
+		restoreDirectories, err := prepareSandboxDirectories(qmkHomeDirectoryPath, homeDirectoryPath, createTargetName(options), outputTargetName, options.Sandbox)
+		if err != nil {
+			return createErrorBuildResult(err)
+		}
+		defer restoreDirectories()
+		// The home directory is the only directory writable by the sandbox user.
+		temporaryDirectoryPath = homeDirectoryPath
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Sandbox.Timeout)
+		defer cancel()
+		prlimitArgs := append(createPrlimitArguments(options.Sandbox), QmkCommandPath)
+		cmd = exec.CommandContext(ctx, "/usr/bin/prlimit", append(prlimitArgs, args...)...)
+		cmd.Env = createSandboxEnvironment(qmkHomeDirectoryPath, homeDirectoryPath)
+		applySandbox(cmd, options.Sandbox)
+		outputLimit = options.Sandbox.MaxOutputBytes
+	}
+	cmd.Dir = qmkHomeDirectoryPath
+	cmd.Env = append(cmd.Env, optDefs)
+	if options.Reproducible {
+		cmd.Env = append(cmd.Env, createReproducibleEnvironment(qmkHomeDirectoryPath)...)
+	}
+	if options.CompilerCache != nil {
+		toolchain := DetectToolchain(filepath.Join(qmkHomeDirectoryPath, "keyboards", options.KeyboardId), options.Revision)
+		cacheDirectoryPath := createCompilerCacheDirectoryPath(options.CompilerCache, options.QmkFirmwareVersion, toolchain)
+		err := acquireCompilerCacheDirectory(cacheDirectoryPath)
+		if err != nil {
+			return createErrorBuildResult(err)
+		}
+		// The other cache directories are evicted after the build, so the total size is bounded.
+		defer func() {
+			releaseCompilerCacheDirectory(cacheDirectoryPath)
+			evictCompilerCaches(options.CompilerCache)
+		}()
+		log.Printf("[INFO] The compiler cache is enabled: %s\n", cacheDirectoryPath)
+		cmd.Env = append(cmd.Env, createCompilerCacheEnvironment(
+			cacheDirectoryPath, options.CompilerCache, qmkHomeDirectoryPath, temporaryDirectoryPath, options.Sandbox != nil)...)
+	}
+	stdout := &limitedBuffer{limit: outputLimit}
+	stderr := &limitedBuffer{limit: outputLimit}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// QMK copies the firmware files to the top level of the QMK Firmware directory.
+	// Take the snapshot to find the files produced by this build.
+	snapshot, err := snapshotTopLevelFiles(qmkHomeDirectoryPath)
+	if err != nil {
+		return createErrorBuildResult(err)
+	}
+	err = cmd.Run()
 	log.Println("Building a QMK Firmware finished.")
 	stdoutString := stdout.String()
+	var compilerCacheStats *common.CompilerCacheStats
+	if options.CompilerCache != nil {
+		compilerCacheStats = readCompilerCacheStats(temporaryDirectoryPath)
+		log.Printf("[INFO] compilerCacheStats: %+v\n", compilerCacheStats)
+	}
+	var limitExceeded string
+	if options.Sandbox != nil {
+		timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+		limitExceeded = signaledLimit(err)
+		if limitExceeded == "" {
+			limitExceeded = detectLimitExceeded(stdoutString+stderr.String(), stdout.truncated || stderr.truncated, timedOut)
+		}
+		if limitExceeded != "" {
+			log.Printf("[ERROR] The limit of the sandbox was hit: %s\n", limitExceeded)
+		}
+	}
 	if err != nil {
 		log.Println("Building failed.")
 		stderrString := stderr.String()
 		log.Printf("[ERROR] %s\n", err.Error())
 		return BuildResult{
-			Success: false,
-			Stdout:  stdoutString,
-			Stderr:  stderrString,
+			Success:            false,
+			Stdout:             stdoutString,
+			Stderr:             stderrString,
+			LimitExceeded:      limitExceeded,
+			CompilerCacheStats: compilerCacheStats,
 		}
 	}
 	log.Println("Building succeeded.")
+	artifacts, err := collectArtifacts(qmkHomeDirectoryPath, snapshot, options)
+	if err != nil {
+		return createErrorBuildResult(err)
+	}
+	if len(artifacts) == 0 {
+		log.Println("[ERROR] No firmware file was produced by the build.")
+		return BuildResult{
+			Success:            false,
+			Stdout:             stdoutString,
+			Stderr:             "No firmware file was produced by the build.",
+			CompilerCacheStats: compilerCacheStats,
+		}
+	}
+	hardware := DetectKeyboardHardware(filepath.Join(qmkHomeDirectoryPath, "keyboards", options.KeyboardId), options.Revision)
+	converted, err := convertArtifacts(artifacts, hardware)
+	if err != nil {
+		// The original files are still available, so the build does not fail.
+		log.Printf("[ERROR] Converting the firmware files failed: %s\n", err.Error())
+	}
+	artifacts = append(artifacts, converted...)
+	log.Printf("[INFO] artifacts: %+v\n", artifacts)
+	return BuildResult{
+		Success:            true,
+		Stdout:             stdoutString,
+		Stderr:             "",
+		LimitExceeded:      limitExceeded,
+		CompilerCacheStats: compilerCacheStats,
+		Artifacts:          artifacts,
+		Hardware:           hardware,
+	}
+}
+
+// runQmkCommandInSandbox runs the QMK CLI command, which only reads the QMK Firmware directory, in the sandbox.
+// Like the compile step, the command runs with the scrubbed environment variables and the resource limits,
+// and nothing in the QMK Firmware directory is writable.
+func runQmkCommandInSandbox(qmkHomeDirectoryPath string, args []string, config *SandboxConfig) (string, string, error) {
+	homeDirectoryPath, err := os.MkdirTemp("", "remap-sandbox-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(homeDirectoryPath)
+	err = prepareSandboxHomeDirectory(homeDirectoryPath, config)
+	if err != nil {
+		return "", "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	prlimitArgs := append(createPrlimitArguments(config), QmkCommandPath)
+	cmd := exec.CommandContext(ctx, "/usr/bin/prlimit", append(prlimitArgs, args...)...)
+	cmd.Dir = qmkHomeDirectoryPath
+	cmd.Env = createSandboxEnvironment(qmkHomeDirectoryPath, homeDirectoryPath)
+	applySandbox(cmd, config)
+	stdout := &limitedBuffer{limit: config.MaxOutputBytes}
+	stderr := &limitedBuffer{limit: config.MaxOutputBytes}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+func createErrorBuildResult(err error) BuildResult {
+	log.Printf("[ERROR] %s\n", err.Error())
 	return BuildResult{
-		Success: true,
-		Stdout:  stdoutString,
-		Stderr:  "",
+		Success: false,
+		Stdout:  "",
+		Stderr:  err.Error(),
 	}
 }
 
@@ -139,21 +442,3 @@ func PrepareKeyboardDirectory(keyboardId string, qmkFirmwareVersion string) (str
 	}
 	return keyboardDirectoryFullPath, nil
 }
-
-// CreateFirmwareFileNameWithTimestamp creates a firmware file name with timestamp.
-// This function does the following:
-//  1. Create the timestamp string based on the epoch.
-//  2. Append the timestamp string after the firmware file name with the "_" character.
-//     For instance, "ckpr5gut7qls715olr70_remap.uf2" -> "ckpr5gut7qls715olr70_remap_1580000000.uf2"
-//  3. Return the firmware file name with the timestamp.
-func CreateFirmwareFileNameWithTimestamp(firmwareFileName string) string {
-	if firmwareFileName == "" {
-		return ""
-	}
-	epoch := strconv.FormatInt(time.Now().Unix(), 10)
-	return firmwareFileName[:len(firmwareFileName)-len(filepath.Ext(firmwareFileName))] + "_" + epoch + filepath.Ext(firmwareFileName)
-}
-
-func CreateFirmwareFilePath(qmkFirmwareBaseDirectoryPath string, qmkFirmwareVersion string, firmwareFileName string) string {
-	return filepath.Join(qmkFirmwareBaseDirectoryPath+qmkFirmwareVersion, CreateFirmwareFileNameWithTimestamp(firmwareFileName))
-}
@@ -1,42 +1,130 @@
 package build
 
 import (
-	"regexp"
 	"testing"
+
+	"remap-keys.app/remap-build-server/common"
 )
 
-func Test_CreateFirmwareFileNameWithTimestamp_WithoutExt(t *testing.T) {
-	actual := CreateFirmwareFileNameWithTimestamp("foo")
-	if actual == "" {
-		t.Error("Expected not empty string but got", actual)
+func Test_ValidateKeymapName_Valid(t *testing.T) {
+	for _, keymapName := range []string{"remap", "default", "via", "my-keymap_2"} {
+		err := ValidateKeymapName(keymapName)
+		if err != nil {
+			t.Error("Expected nil but got", err)
+		}
 	}
-	if !validateFileNameWithTimestampFormat(actual) {
-		t.Error("Expected valid file name but got", actual)
+}
+
+func Test_ValidateKeymapName_Invalid(t *testing.T) {
+	for _, keymapName := range []string{"", "..", "foo/bar", "foo bar", "../remap"} {
+		err := ValidateKeymapName(keymapName)
+		if err == nil {
+			t.Error("Expected error but got nil for", keymapName)
+		}
+	}
+}
+
+func Test_ValidateRevision_Valid(t *testing.T) {
+	for _, revision := range []string{"", "rev1", "rev2/left"} {
+		err := ValidateRevision(revision)
+		if err != nil {
+			t.Error("Expected nil but got", err)
+		}
+	}
+}
+
+func Test_ValidateRevision_Invalid(t *testing.T) {
+	for _, revision := range []string{"..", "../rev1", "/rev1", "rev1/", "rev1//left"} {
+		err := ValidateRevision(revision)
+		if err == nil {
+			t.Error("Expected error but got nil for", revision)
+		}
 	}
 }
 
-func Test_CreateFirmwareFileNameWithTimestamp_WithExt(t *testing.T) {
-	actual := CreateFirmwareFileNameWithTimestamp("foo.hex")
-	if actual == "" {
-		t.Error("Expected not empty string but got", actual)
+func Test_ResolveBuildVariants_NoVariants(t *testing.T) {
+	actual, err := ResolveBuildVariants(&common.Task{}, "")
+	if err != nil {
+		t.Error("Expected nil but got", err)
+	}
+	if len(actual) != 1 {
+		t.Fatal("Expected 1 but got", len(actual))
+	}
+	if actual[0].KeymapName != DefaultKeymapName {
+		t.Error("Expected", DefaultKeymapName, "but got", actual[0].KeymapName)
+	}
+	if actual[0].Revision != "" {
+		t.Error("Expected empty string but got", actual[0].Revision)
+	}
+}
+
+func Test_ResolveBuildVariants_DefaultKeymapName(t *testing.T) {
+	actual, err := ResolveBuildVariants(&common.Task{}, "via")
+	if err != nil {
+		t.Error("Expected nil but got", err)
 	}
-	if !validateFileNameWithTimestampFormat(actual) {
-		t.Error("Expected valid file name but got", actual)
+	if actual[0].KeymapName != "via" {
+		t.Error("Expected via but got", actual[0].KeymapName)
 	}
 }
 
-func Test_CreateFirmwareFileNameWithTimestamp_Empty(t *testing.T) {
-	actual := CreateFirmwareFileNameWithTimestamp("")
-	if actual != "" {
-		t.Error("Expected empty string but got", actual)
+func Test_ResolveBuildVariants_TaskKeymapName(t *testing.T) {
+	actual, err := ResolveBuildVariants(&common.Task{KeymapName: "default"}, "via")
+	if err != nil {
+		t.Error("Expected nil but got", err)
+	}
+	if actual[0].KeymapName != "default" {
+		t.Error("Expected default but got", actual[0].KeymapName)
 	}
 }
 
-func validateFileNameWithTimestampFormat(source string) bool {
-	pattern := `[a-zA-Z0-9_]+_[0-9]+(.[a-zA-Z0-9]+)?`
-	re, err := regexp.Compile(pattern)
+func Test_ResolveBuildVariants_MultipleVariants(t *testing.T) {
+	task := &common.Task{
+		KeymapName: "default",
+		Variants: []common.BuildVariant{
+			{KeymapName: "", Revision: "rev1"},
+			{KeymapName: "via", Revision: "rev2"},
+		},
+	}
+	actual, err := ResolveBuildVariants(task, "")
 	if err != nil {
-		panic(err)
+		t.Error("Expected nil but got", err)
+	}
+	if len(actual) != 2 {
+		t.Fatal("Expected 2 but got", len(actual))
+	}
+	if actual[0].KeymapName != "default" || actual[0].Revision != "rev1" {
+		t.Error("Expected default/rev1 but got", actual[0])
+	}
+	if actual[1].KeymapName != "via" || actual[1].Revision != "rev2" {
+		t.Error("Expected via/rev2 but got", actual[1])
+	}
+}
+
+func Test_ResolveBuildVariants_InvalidKeymapName(t *testing.T) {
+	_, err := ResolveBuildVariants(&common.Task{KeymapName: "../foo"}, "")
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_ResolveBuildVariants_InvalidRevision(t *testing.T) {
+	task := &common.Task{
+		Variants: []common.BuildVariant{{Revision: "../rev1"}},
+	}
+	_, err := ResolveBuildVariants(task, "")
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_BuildOptions_KeyboardTarget(t *testing.T) {
+	actual := BuildOptions{KeyboardId: "foo"}.KeyboardTarget()
+	if actual != "foo" {
+		t.Error("Expected foo but got", actual)
+	}
+	actual = BuildOptions{KeyboardId: "foo", Revision: "rev1"}.KeyboardTarget()
+	if actual != "foo/rev1" {
+		t.Error("Expected foo/rev1 but got", actual)
 	}
-	return re.MatchString(source)
 }
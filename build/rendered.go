@@ -0,0 +1,62 @@
+package build
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"unicode/utf8"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+// RenderedFile represents a source file after the parameters are replaced, as it is written for the build.
+type RenderedFile struct {
+	Category string `json:"category"`
+	Path     string `json:"path"`
+	Encoding string `json:"encoding"`
+	Content  string `json:"content"`
+}
+
+// CreateRenderedFiles creates the rendered files of each category, sorted by the category and the path.
+// The binary files and the content which is not a valid UTF-8 string are encoded with base64.
+func CreateRenderedFiles(buildableFilesMap map[string][]common.BuildableFile) ([]RenderedFile, error) {
+	result := []RenderedFile{}
+	for category, buildableFiles := range buildableFilesMap {
+		for _, buildableFile := range buildableFiles {
+			content, err := common.DecodeContent(buildableFile)
+			if err != nil {
+				return nil, err
+			}
+			renderedFile := RenderedFile{
+				Category: category,
+				Path:     buildableFile.GetPath(),
+				Encoding: common.FileEncodingUtf8,
+				Content:  string(content),
+			}
+			if buildableFile.GetEncoding() == common.FileEncodingBase64 || !utf8.Valid(content) {
+				renderedFile.Encoding = common.FileEncodingBase64
+				renderedFile.Content = base64.StdEncoding.EncodeToString(content)
+			}
+			result = append(result, renderedFile)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Category != result[j].Category {
+			return result[i].Category < result[j].Category
+		}
+		return result[i].Path < result[j].Path
+	})
+	return result, nil
+}
+
+// MarshalRenderedFiles marshals the rendered files to the JSON uploaded next to the firmware files.
+func MarshalRenderedFiles(renderedFiles []RenderedFile) ([]byte, error) {
+	return json.MarshalIndent(map[string]interface{}{
+		"files": renderedFiles,
+	}, "", "  ")
+}
+
+// CreateRenderedFilesFileName creates the name of the file holding the rendered files of the task.
+func CreateRenderedFilesFileName(taskId string) string {
+	return taskId + "_rendered.json"
+}
@@ -0,0 +1,56 @@
+package build
+
+import (
+	"testing"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+func Test_CreateRenderedFiles(t *testing.T) {
+	actual, err := CreateRenderedFiles(map[string][]common.BuildableFile{
+		"keymap": {
+			common.FirmwareFile{Path: "keymap.c", Content: "// keymap"},
+		},
+		"keyboard": {
+			common.FirmwareFile{Path: "rules.mk", Content: "OLED_ENABLE = yes"},
+			common.FirmwareFile{Path: "logo.bin", Content: "AAEC/w==", Encoding: common.FileEncodingBase64},
+			common.FirmwareFile{Path: "config.h", Content: "#pragma once"},
+		},
+	})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	expected := []RenderedFile{
+		{Category: "keyboard", Path: "config.h", Encoding: common.FileEncodingUtf8, Content: "#pragma once"},
+		{Category: "keyboard", Path: "logo.bin", Encoding: common.FileEncodingBase64, Content: "AAEC/w=="},
+		{Category: "keyboard", Path: "rules.mk", Encoding: common.FileEncodingUtf8, Content: "OLED_ENABLE = yes"},
+		{Category: "keymap", Path: "keymap.c", Encoding: common.FileEncodingUtf8, Content: "// keymap"},
+	}
+	if len(actual) != len(expected) {
+		t.Fatal("Expected", len(expected), "but got", len(actual))
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Error("Expected", expected[i], "but got", actual[i])
+		}
+	}
+}
+
+func Test_CreateRenderedFiles_InvalidUtf8(t *testing.T) {
+	actual, err := CreateRenderedFiles(map[string][]common.BuildableFile{
+		"keyboard": {common.FirmwareFile{Path: "font.c", Content: "\xff\xfe"}},
+	})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if actual[0].Encoding != common.FileEncodingBase64 || actual[0].Content != "//4=" {
+		t.Error("Expected base64 //4= but got", actual[0].Encoding, actual[0].Content)
+	}
+}
+
+func Test_CreateRenderedFilesFileName(t *testing.T) {
+	actual := CreateRenderedFilesFileName("task1")
+	if actual != "task1_rendered.json" {
+		t.Error("Expected task1_rendered.json but got", actual)
+	}
+}
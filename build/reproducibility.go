@@ -0,0 +1,283 @@
+package build
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/xid"
+	"remap-keys.app/remap-build-server/common"
+)
+
+// createReproducibleEnvironment creates the environment variables which remove the timestamps and the paths
+// of the build machine from the firmware:
+//   - SOURCE_DATE_EPOCH fixes __DATE__ and __TIME__ to the date of the commit checked out in the tree.
+//   - SKIP_VERSION makes QMK write the fixed build date and version into version.h.
+//     The QMK Firmware version is recorded in the manifest instead.
+//   - EXTRAFLAGS maps the absolute path of the tree to ".", so the firmware does not depend on where the tree is.
+func createReproducibleEnvironment(qmkHomeDirectoryPath string) []string {
+	return []string{
+		"SOURCE_DATE_EPOCH=" + fetchSourceDateEpoch(qmkHomeDirectoryPath),
+		"SKIP_VERSION=yes",
+		"EXTRAFLAGS=-ffile-prefix-map=" + filepath.Clean(qmkHomeDirectoryPath) + "=.",
+	}
+}
+
+// fetchSourceDateEpoch fetches the UNIX time of the commit checked out in the tree.
+// It returns "0" if the time cannot be fetched, so the value is still stable.
+func fetchSourceDateEpoch(qmkHomeDirectoryPath string) string {
+	output, err := exec.Command("git", "-C", qmkHomeDirectoryPath, "log", "-1", "--format=%ct").Output()
+	if err != nil {
+		return "0"
+	}
+	epoch := strings.TrimSpace(string(output))
+	if _, err := strconv.ParseInt(epoch, 10, 64); err != nil {
+		return "0"
+	}
+	return epoch
+}
+
+// CheckReproducibility builds the firmware twice in the isolated copies of the QMK Firmware tree, and compares
+// the firmware files and the sections of the ELF files. The keyboard directory and the userspace directory must be
+// prepared in the tree of the passed options. The compiler cache is not used, so every object file is compiled twice.
+// Both builds remove the timestamps and the paths of the build machine from the firmware.
+func CheckReproducibility(options BuildOptions) (*common.ReproducibilityResult, error) {
+	log.Println("Checking the reproducibility of the build.")
+	options.CompilerCache = nil
+	options.Reproducible = true
+	sourceDirectoryPath := QmkFirmwareBaseDirectoryPath + options.QmkFirmwareVersion
+	id := xid.New().String()
+	var hashes [2]map[string]string
+	var elfFilePaths [2]string
+	for i := range hashes {
+		cloneName := fmt.Sprintf(".repro-%s-%d", id, i+1)
+		cloneDirectoryPath := QmkFirmwareBaseDirectoryPath + cloneName
+		defer os.RemoveAll(cloneDirectoryPath)
+		err := cloneQmkFirmwareTree(sourceDirectoryPath, cloneDirectoryPath)
+		if err != nil {
+			return nil, err
+		}
+		cloneOptions := options
+		cloneOptions.QmkFirmwareVersion = cloneName
+		buildResult := BuildQmkFirmware(cloneOptions)
+		if !buildResult.Success {
+			return nil, fmt.Errorf("the build %d of %s failed: %s", i+1, createTargetName(options), buildResult.Stderr)
+		}
+		hashes[i] = map[string]string{}
+		for _, artifact := range buildResult.Artifacts {
+			hash, err := hashFile(artifact.FilePath)
+			if err != nil {
+				return nil, err
+			}
+			hashes[i][artifact.FileName] = hash
+		}
+		elfFilePaths[i] = filepath.Join(cloneDirectoryPath, ".build", createTargetName(cloneOptions)+".elf")
+	}
+	result := &common.ReproducibilityResult{
+		KeymapName:          options.KeymapName,
+		Revision:            options.Revision,
+		ArtifactDifferences: compareArtifactHashes(hashes[0], hashes[1]),
+	}
+	sectionDifferences, err := compareElfSections(elfFilePaths[0], elfFilePaths[1])
+	if err != nil {
+		// The firmware files are compared even if the ELF file is not available.
+		log.Printf("[ERROR] Comparing the ELF files failed: %s\n", err.Error())
+	}
+	result.SectionDifferences = sectionDifferences
+	result.Reproducible = len(result.ArtifactDifferences) == 0 && len(result.SectionDifferences) == 0
+	log.Printf("[INFO] reproducible: %v\n", result.Reproducible)
+	return result, nil
+}
+
+// cloneQmkFirmwareTree creates the copy of the tree whose files are hard links to the files of the source tree,
+// so the copy is cheap. The build directory is not copied, and the files at the top level are copied instead of
+// linked, because QMK overwrites the firmware files there.
+func cloneQmkFirmwareTree(sourceDirectoryPath string, targetDirectoryPath string) error {
+	return filepath.WalkDir(sourceDirectoryPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(sourceDirectoryPath, path)
+		if err != nil {
+			return err
+		}
+		targetPath := filepath.Join(targetDirectoryPath, relativePath)
+		if d.IsDir() {
+			if relativePath == ".build" {
+				return filepath.SkipDir
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return os.Mkdir(targetPath, info.Mode().Perm())
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, targetPath)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if filepath.Dir(relativePath) == "." {
+			return copyFile(path, targetPath)
+		}
+		return os.Link(path, targetPath)
+	})
+}
+
+func copyFile(sourcePath string, targetPath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	target, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	_, err = io.Copy(target, source)
+	return err
+}
+
+func hashFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// compareArtifactHashes returns the firmware files whose hashes differ or which exist in only one of the builds,
+// sorted by the file name. The hash of the missing file is the empty string.
+func compareArtifactHashes(first map[string]string, second map[string]string) []common.ArtifactDifference {
+	fileNames := map[string]bool{}
+	for fileName := range first {
+		fileNames[fileName] = true
+	}
+	for fileName := range second {
+		fileNames[fileName] = true
+	}
+	differences := []common.ArtifactDifference{}
+	for fileName := range fileNames {
+		if first[fileName] != second[fileName] {
+			differences = append(differences, common.ArtifactDifference{
+				FileName:     fileName,
+				FirstSha256:  first[fileName],
+				SecondSha256: second[fileName],
+			})
+		}
+	}
+	sort.Slice(differences, func(i, j int) bool {
+		return differences[i].FileName < differences[j].FileName
+	})
+	return differences
+}
+
+// compareElfSections returns the sections whose contents differ between the ELF files, in the order of the first file.
+// The sections with the same name are paired in the order of their appearance.
+// The size of the section missing in one of the files is zero.
+func compareElfSections(firstFilePath string, secondFilePath string) ([]common.SectionDifference, error) {
+	firstSections, firstNames, err := readElfSections(firstFilePath)
+	if err != nil {
+		return nil, err
+	}
+	secondSections, secondNames, err := readElfSections(secondFilePath)
+	if err != nil {
+		return nil, err
+	}
+	names := firstNames
+	for _, name := range secondNames {
+		if _, ok := firstSections[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	differences := []common.SectionDifference{}
+	for _, name := range names {
+		first := firstSections[name]
+		second := secondSections[name]
+		if bytes.Equal(first, second) {
+			continue
+		}
+		offset := 0
+		for offset < len(first) && offset < len(second) && first[offset] == second[offset] {
+			offset++
+		}
+		differences = append(differences, common.SectionDifference{
+			Name:       strings.SplitN(name, "#", 2)[0],
+			FirstSize:  int64(len(first)),
+			SecondSize: int64(len(second)),
+			Offset:     int64(offset),
+		})
+	}
+	return differences, nil
+}
+
+// readElfSections reads the contents of the sections which have the data in the file.
+// The sections are keyed by the name, and the second and later sections with the same name have the "#N" suffix.
+func readElfSections(path string) (map[string][]byte, []string, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	sections := map[string][]byte{}
+	var names []string
+	counts := map[string]int{}
+	for _, section := range file.Sections {
+		if section.Type == elf.SHT_NULL || section.Type == elf.SHT_NOBITS {
+			continue
+		}
+		name := section.Name
+		counts[name]++
+		if counts[name] > 1 {
+			name = fmt.Sprintf("%s#%d", name, counts[name])
+		}
+		data, err := section.Data()
+		if err != nil {
+			return nil, nil, err
+		}
+		sections[name] = data
+		names = append(names, name)
+	}
+	return sections, names, nil
+}
+
+// FormatReproducibilityResults formats the differences of the non-reproducible builds to the human-readable text.
+func FormatReproducibilityResults(results []common.ReproducibilityResult) string {
+	var builder strings.Builder
+	for _, result := range results {
+		if result.Reproducible {
+			continue
+		}
+		fmt.Fprintf(&builder, "keymap=%s, revision=%s is not reproducible\n", result.KeymapName, result.Revision)
+		for _, difference := range result.ArtifactDifferences {
+			fmt.Fprintf(&builder, "  %s: sha256 %s != %s\n", difference.FileName, difference.FirstSha256, difference.SecondSha256)
+		}
+		for _, difference := range result.SectionDifferences {
+			fmt.Fprintf(&builder, "  section %s: %d and %d bytes, first difference at 0x%x\n",
+				difference.Name, difference.FirstSize, difference.SecondSize, difference.Offset)
+		}
+	}
+	return builder.String()
+}
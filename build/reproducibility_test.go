@@ -0,0 +1,167 @@
+package build
+
+import (
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+func Test_createReproducibleEnvironment(t *testing.T) {
+	directoryPath := t.TempDir()
+	actual := createReproducibleEnvironment(directoryPath + "/")
+	expected := []string{
+		"SOURCE_DATE_EPOCH=0",
+		"SKIP_VERSION=yes",
+		"EXTRAFLAGS=-ffile-prefix-map=" + directoryPath + "=.",
+	}
+	if len(actual) != len(expected) {
+		t.Fatal("Expected", expected, "but got", actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Error("Expected", expected[i], "but got", actual[i])
+		}
+	}
+}
+
+func Test_cloneQmkFirmwareTree(t *testing.T) {
+	sourceDirectoryPath := t.TempDir()
+	files := map[string]string{
+		"foo_remap.hex":          "old",
+		"Makefile":               "all:",
+		"keyboards/foo/rules.mk": "OLED_ENABLE = yes",
+		".build/obj_foo/main.o":  "object",
+	}
+	for path, content := range files {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(sourceDirectoryPath, path)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(sourceDirectoryPath, path), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	targetDirectoryPath := filepath.Join(t.TempDir(), "clone")
+	err := cloneQmkFirmwareTree(sourceDirectoryPath, targetDirectoryPath)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	content, err := os.ReadFile(filepath.Join(targetDirectoryPath, "keyboards", "foo", "rules.mk"))
+	if err != nil || string(content) != "OLED_ENABLE = yes" {
+		t.Error("Expected OLED_ENABLE = yes but got", string(content), err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDirectoryPath, ".build")); !os.IsNotExist(err) {
+		t.Error("Expected the build directory not to be copied but got", err)
+	}
+	// The firmware file at the top level is a copy, so overwriting it does not change the source tree.
+	err = os.WriteFile(filepath.Join(targetDirectoryPath, "foo_remap.hex"), []byte("new"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err = os.ReadFile(filepath.Join(sourceDirectoryPath, "foo_remap.hex"))
+	if err != nil || string(content) != "old" {
+		t.Error("Expected old but got", string(content), err)
+	}
+}
+
+func Test_compareArtifactHashes(t *testing.T) {
+	actual := compareArtifactHashes(
+		map[string]string{"foo_remap.hex": "aaa", "foo_remap.bin": "bbb", "foo_remap.uf2": "ccc"},
+		map[string]string{"foo_remap.hex": "aaa", "foo_remap.bin": "ddd"})
+	expected := []common.ArtifactDifference{
+		{FileName: "foo_remap.bin", FirstSha256: "bbb", SecondSha256: "ddd"},
+		{FileName: "foo_remap.uf2", FirstSha256: "ccc", SecondSha256: ""},
+	}
+	if len(actual) != len(expected) {
+		t.Fatal("Expected", expected, "but got", actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Error("Expected", expected[i], "but got", actual[i])
+		}
+	}
+}
+
+// copyTestExecutable copies the running test binary, which is an ELF file on Linux, and flips a byte of the section.
+func copyTestExecutable(t *testing.T, sectionName string, offset int64) string {
+	t.Helper()
+	executablePath, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := elf.Open(executablePath)
+	if err != nil {
+		t.Skip("The test binary is not an ELF file:", err)
+	}
+	section := file.Section(sectionName)
+	file.Close()
+	if section == nil {
+		t.Skip("The test binary has no section:", sectionName)
+	}
+	content, err := os.ReadFile(executablePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset >= 0 {
+		content[int64(section.Offset)+offset] ^= 0xff
+	}
+	path := filepath.Join(t.TempDir(), "firmware.elf")
+	err = os.WriteFile(path, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_compareElfSections_Same(t *testing.T) {
+	first := copyTestExecutable(t, ".text", -1)
+	second := copyTestExecutable(t, ".text", -1)
+	actual, err := compareElfSections(first, second)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual) != 0 {
+		t.Error("Expected no differences but got", actual)
+	}
+}
+
+func Test_compareElfSections_Different(t *testing.T) {
+	first := copyTestExecutable(t, ".text", -1)
+	second := copyTestExecutable(t, ".text", 16)
+	actual, err := compareElfSections(first, second)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual) != 1 {
+		t.Fatal("Expected 1 difference but got", actual)
+	}
+	if actual[0].Name != ".text" || actual[0].Offset != 16 || actual[0].FirstSize != actual[0].SecondSize {
+		t.Error("Expected .text at 16 but got", actual[0])
+	}
+}
+
+func Test_FormatReproducibilityResults(t *testing.T) {
+	actual := FormatReproducibilityResults([]common.ReproducibilityResult{
+		{KeymapName: "remap", Reproducible: true},
+		{
+			KeymapName:          "via",
+			Revision:            "rev1",
+			ArtifactDifferences: []common.ArtifactDifference{{FileName: "foo_rev1_via.hex", FirstSha256: "aaa", SecondSha256: "bbb"}},
+			SectionDifferences:  []common.SectionDifference{{Name: ".text", FirstSize: 32, SecondSize: 32, Offset: 16}},
+		},
+	})
+	expected := strings.Join([]string{
+		"keymap=via, revision=rev1 is not reproducible",
+		"  foo_rev1_via.hex: sha256 aaa != bbb",
+		"  section .text: 32 and 32 bytes, first difference at 0x10",
+		"",
+	}, "\n")
+	if actual != expected {
+		t.Error("Expected", expected, "but got", actual)
+	}
+}
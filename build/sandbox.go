@@ -0,0 +1,135 @@
+package build
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+const (
+	LimitCpu       string = "cpu"
+	LimitMemory    string = "memory"
+	LimitProcesses string = "processes"
+	LimitFileSize  string = "fileSize"
+	LimitOutput    string = "output"
+	LimitTimeout   string = "timeout"
+)
+
+// SandboxConfig represents the restrictions applied to the compile step for untrusted sources.
+type SandboxConfig struct {
+	// UserId and GroupId are the IDs of the unprivileged user running the compile step.
+	UserId  int
+	GroupId int
+	// CpuSeconds is the maximum CPU time of each process.
+	CpuSeconds int64
+	// MemoryBytes is the maximum size of the virtual memory of each process.
+	MemoryBytes int64
+	// MaxProcesses is the maximum number of the processes of the sandbox user.
+	MaxProcesses int64
+	// MaxFileSizeBytes is the maximum size of each file written by the processes.
+	MaxFileSizeBytes int64
+	// MaxOutputBytes is the maximum size of each of the captured stdout and stderr.
+	MaxOutputBytes int64
+	// Timeout is the maximum wall-clock time of the compile step.
+	Timeout time.Duration
+}
+
+// DefaultSandboxConfig returns the sandbox configuration for the "remap-build" user created in the Dockerfile.
+func DefaultSandboxConfig() *SandboxConfig {
+	return &SandboxConfig{
+		UserId:           10001,
+		GroupId:          10001,
+		CpuSeconds:       600,
+		MemoryBytes:      2 * 1024 * 1024 * 1024,
+		MaxProcesses:     256,
+		MaxFileSizeBytes: 64 * 1024 * 1024,
+		MaxOutputBytes:   4 * 1024 * 1024,
+		Timeout:          15 * time.Minute,
+	}
+}
+
+// createPrlimitArguments creates the arguments of the `prlimit` command to apply the resource limits.
+func createPrlimitArguments(config *SandboxConfig) []string {
+	return []string{
+		"--cpu=" + strconv.FormatInt(config.CpuSeconds, 10),
+		"--as=" + strconv.FormatInt(config.MemoryBytes, 10),
+		"--nproc=" + strconv.FormatInt(config.MaxProcesses, 10),
+		"--fsize=" + strconv.FormatInt(config.MaxFileSizeBytes, 10),
+		"--",
+	}
+}
+
+// createSandboxEnvironment creates the scrubbed environment variables of the compile step.
+// The environment variables of the server, including credentials, are not inherited.
+func createSandboxEnvironment(qmkHomeDirectoryPath string, homeDirectoryPath string) []string {
+	return append([]string{
+		"PATH=/root/.local/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"HOME=" + homeDirectoryPath,
+		"LANG=C.UTF-8",
+		"PYTHONUSERBASE=/root/.local",
+		"QMK_HOME=" + qmkHomeDirectoryPath,
+	}, createPythonPathEnvironment(qmkHomeDirectoryPath)...)
+}
+
+// createPythonPathEnvironment creates the PYTHONPATH environment variable pointing to the Python packages
+// installed in the tree materialized from a fork. The other trees use the packages installed in the Dockerfile.
+func createPythonPathEnvironment(qmkHomeDirectoryPath string) []string {
+	packagesDirectoryPath := filepath.Join(qmkHomeDirectoryPath, common.PythonPackagesDirectoryName)
+	if _, err := os.Stat(packagesDirectoryPath); err != nil {
+		return nil
+	}
+	return []string{"PYTHONPATH=" + packagesDirectoryPath}
+}
+
+// detectLimitExceeded detects which limit was hit from the output of the compile step.
+// Returns the empty string if no limit was hit.
+func detectLimitExceeded(output string, outputTruncated bool, timedOut bool) string {
+	if timedOut {
+		return LimitTimeout
+	}
+	switch {
+	case strings.Contains(output, "CPU time limit exceeded"):
+		return LimitCpu
+	case strings.Contains(output, "File size limit exceeded"):
+		return LimitFileSize
+	case strings.Contains(output, "virtual memory exhausted"),
+		strings.Contains(output, "Cannot allocate memory"),
+		strings.Contains(output, "out of memory"):
+		return LimitMemory
+	case strings.Contains(output, "Resource temporarily unavailable"):
+		return LimitProcesses
+	}
+	if outputTruncated {
+		return LimitOutput
+	}
+	return ""
+}
+
+// limitedBuffer is a buffer which discards the data written after the limit.
+// Write never fails, so the process writing the output is not interrupted.
+type limitedBuffer struct {
+	buffer    bytes.Buffer
+	limit     int64
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.limit - int64(b.buffer.Len())
+	if int64(len(p)) > remaining {
+		b.truncated = true
+		if remaining > 0 {
+			b.buffer.Write(p[:remaining])
+		}
+		return len(p), nil
+	}
+	return b.buffer.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buffer.String()
+}
@@ -0,0 +1,265 @@
+//go:build linux
+
+package build
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// applySandbox runs the command as the sandbox user in a new network namespace without any network interface.
+// The command runs in its own process group, so all the processes are killed when the command is canceled.
+func applySandbox(cmd *exec.Cmd, config *SandboxConfig) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWNET,
+		Credential: &syscall.Credential{
+			Uid: uint32(config.UserId),
+			Gid: uint32(config.GroupId),
+		},
+		Setpgid: true,
+	}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 10 * time.Second
+}
+
+// sandboxBuildFileExtensions is the extensions of the files which the build writes for the target
+// in the ".build" directory.
+var sandboxBuildFileExtensions = []string{".elf", ".map", ".hex", ".bin", ".uf2", ".eep", ".lss", ".lst", ".sym"}
+
+// chownTree changes the owner of the directory and all the entries in it.
+func chownTree(directoryPath string, uid int, gid int) error {
+	return filepath.WalkDir(directoryPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+// createSandboxFile creates the empty file owned by the sandbox user. The existing file is replaced.
+// The linker and objcopy overwrite the empty file in place, so the directory itself does not need to be writable.
+func createSandboxFile(filePath string, config *SandboxConfig) error {
+	err := os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Chown(filePath, config.UserId, config.GroupId)
+}
+
+// activeSandboxTargets is the targets being built in the sandbox for each QMK Firmware directory.
+// The restore after a build keeps the outputs of the other targets still being built.
+var activeSandboxTargets = struct {
+	sync.Mutex
+	targets map[string]map[string]int
+}{targets: map[string]map[string]int{}}
+
+// isActiveSandboxEntry returns true if the entry in the ".build" directory or the top level belongs to
+// the target being built in the sandbox.
+func isActiveSandboxEntry(qmkHomeDirectoryPath string, name string, isDirectory bool) bool {
+	for targetName := range activeSandboxTargets.targets[qmkHomeDirectoryPath] {
+		if isDirectory && isObjectDirectoryName(name, targetName) {
+			return true
+		}
+		if !isDirectory && isTargetFileName(name, targetName) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOwnedBy returns true if the entry is owned by the user.
+func isOwnedBy(path string, uid int) bool {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(stat.Uid) == uid
+}
+
+// prepareSandboxHomeDirectory makes the home directory writable by the sandbox user.
+func prepareSandboxHomeDirectory(homeDirectoryPath string, config *SandboxConfig) error {
+	return os.Chown(homeDirectoryPath, config.UserId, config.GroupId)
+}
+
+// prepareSandboxDirectories makes only the output locations of the target writable by the sandbox user,
+// and returns the function restoring them after the build. The QMK Firmware directory and the ".build" directory
+// stay owned by root, so the sandbox user cannot create any other file in them.
+//   - The object directories of the target are created and owned by the sandbox user.
+//   - The files of the output target in the ".build" directory and the firmware files copied to the top level
+//     are created empty and owned by the sandbox user.
+//
+// The output target name has the converter of the CONVERT_TO option, if any.
+func prepareSandboxDirectories(qmkHomeDirectoryPath string, homeDirectoryPath string, targetName string, outputTargetName string, config *SandboxConfig) (func(), error) {
+	buildDirectoryPath := filepath.Join(qmkHomeDirectoryPath, ".build")
+	err := os.MkdirAll(buildDirectoryPath, 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = prepareSandboxHomeDirectory(homeDirectoryPath, config)
+	if err != nil {
+		return nil, err
+	}
+	restore := func() {
+		activeSandboxTargets.Lock()
+		defer activeSandboxTargets.Unlock()
+		targets := activeSandboxTargets.targets[qmkHomeDirectoryPath]
+		targets[targetName]--
+		if targets[targetName] <= 0 {
+			delete(targets, targetName)
+		}
+		err := restoreSandboxDirectories(qmkHomeDirectoryPath, targetName, config)
+		if err != nil {
+			log.Printf("[ERROR] %s\n", err.Error())
+		}
+		if len(targets) == 0 {
+			delete(activeSandboxTargets.targets, qmkHomeDirectoryPath)
+		}
+	}
+	activeSandboxTargets.Lock()
+	if activeSandboxTargets.targets[qmkHomeDirectoryPath] == nil {
+		activeSandboxTargets.targets[qmkHomeDirectoryPath] = map[string]int{}
+	}
+	activeSandboxTargets.targets[qmkHomeDirectoryPath][targetName]++
+	activeSandboxTargets.Unlock()
+	objectDirectoryNames := []string{"obj_" + targetName}
+	if outputTargetName != targetName {
+		objectDirectoryNames = append(objectDirectoryNames, "obj_"+outputTargetName)
+	}
+	for _, name := range objectDirectoryNames {
+		objectDirectoryPath := filepath.Join(buildDirectoryPath, name)
+		err = os.MkdirAll(objectDirectoryPath, 0755)
+		if err == nil {
+			err = chownTree(objectDirectoryPath, config.UserId, config.GroupId)
+		}
+		if err != nil {
+			restore()
+			return nil, err
+		}
+	}
+	var filePaths []string
+	for _, ext := range sandboxBuildFileExtensions {
+		filePaths = append(filePaths, filepath.Join(buildDirectoryPath, outputTargetName+ext))
+	}
+	for ext := range topLevelFirmwareExtensions {
+		filePaths = append(filePaths, filepath.Join(qmkHomeDirectoryPath, outputTargetName+ext))
+	}
+	for _, filePath := range filePaths {
+		err = createSandboxFile(filePath, config)
+		if err != nil {
+			restore()
+			return nil, err
+		}
+	}
+	return restore, nil
+}
+
+// restoreSandboxDirectories gives the outputs of the target back to root, and removes the empty files which
+// the build did not write and anything else owned by the sandbox user in the ".build" directory and the top level.
+// The outputs of the other targets still being built are kept.
+func restoreSandboxDirectories(qmkHomeDirectoryPath string, targetName string, config *SandboxConfig) error {
+	buildDirectoryPath := filepath.Join(qmkHomeDirectoryPath, ".build")
+	for _, directoryPath := range []string{buildDirectoryPath, qmkHomeDirectoryPath} {
+		entries, err := os.ReadDir(directoryPath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			path := filepath.Join(directoryPath, entry.Name())
+			if isActiveSandboxEntry(qmkHomeDirectoryPath, entry.Name(), entry.IsDir()) {
+				continue
+			}
+			if directoryPath == buildDirectoryPath && entry.IsDir() {
+				if isObjectDirectoryName(entry.Name(), targetName) {
+					err = chownTree(path, 0, 0)
+				} else {
+					err = removeOwnedEntries(path, config.UserId)
+				}
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if !isOwnedBy(path, config.UserId) {
+				continue
+			}
+			info, err := os.Lstat(path)
+			if err != nil {
+				return err
+			}
+			if isTargetFileName(entry.Name(), targetName) && info.Mode().IsRegular() && info.Size() > 0 {
+				err = os.Lchown(path, 0, 0)
+			} else {
+				err = os.RemoveAll(path)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeOwnedEntries removes the entries owned by the user in the directory, like the files planted
+// into the object directory of the other target.
+func removeOwnedEntries(directoryPath string, uid int) error {
+	var paths []string
+	err := filepath.WalkDir(directoryPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if isOwnedBy(path, uid) {
+			paths = append(paths, path)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		err = os.RemoveAll(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signaledLimit returns the limit which the signal terminating the process means.
+func signaledLimit(err error) string {
+	var exitError *exec.ExitError
+	if !errors.As(err, &exitError) {
+		return ""
+	}
+	status, ok := exitError.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+	switch status.Signal() {
+	case syscall.SIGXCPU:
+		return LimitCpu
+	case syscall.SIGXFSZ:
+		return LimitFileSize
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+//go:build linux
+
+package build
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func assertOwner(t *testing.T, path string, uid int, gid int) {
+	t.Helper()
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	if int(stat.Uid) != uid || int(stat.Gid) != gid {
+		t.Error("Expected", uid, gid, "for", path, "but got", stat.Uid, stat.Gid)
+	}
+}
+
+func assertMode(t *testing.T, path string, expected fs.FileMode) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := info.Mode().Perm() | (info.Mode() & fs.ModeSticky)
+	if actual != expected {
+		t.Error("Expected", expected, "for", path, "but got", actual)
+	}
+}
+
+func Test_prepareSandboxDirectories(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Changing the owner requires root.")
+	}
+	qmkHomeDirectoryPath := t.TempDir()
+	homeDirectoryPath := t.TempDir()
+	createFiles(t, qmkHomeDirectoryPath,
+		".build/obj_foo_remap/keymap.o",
+		".build/obj_bar_remap/keymap.o",
+		"Makefile",
+	)
+	err := os.Chmod(qmkHomeDirectoryPath, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultSandboxConfig()
+
+	restore, err := prepareSandboxDirectories(qmkHomeDirectoryPath, homeDirectoryPath, "foo_remap", "foo_remap", config)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	assertOwner(t, qmkHomeDirectoryPath, 0, 0)
+	assertMode(t, qmkHomeDirectoryPath, 0755)
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build"), 0, 0)
+	assertMode(t, filepath.Join(qmkHomeDirectoryPath, ".build"), 0755)
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build/obj_foo_remap"), config.UserId, config.GroupId)
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build/obj_foo_remap/keymap.o"), config.UserId, config.GroupId)
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build/obj_bar_remap/keymap.o"), 0, 0)
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build/foo_remap.elf"), config.UserId, config.GroupId)
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, "foo_remap.hex"), config.UserId, config.GroupId)
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, "Makefile"), 0, 0)
+	assertOwner(t, homeDirectoryPath, config.UserId, config.GroupId)
+
+	// Simulate the build writing the outputs and planting the files for the other target.
+	for _, name := range []string{".build/foo_remap.elf", "foo_remap.hex"} {
+		err = os.WriteFile(filepath.Join(qmkHomeDirectoryPath, name), []byte("firmware"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	planted := filepath.Join(qmkHomeDirectoryPath, ".build/obj_bar_remap/planted.o")
+	createFiles(t, qmkHomeDirectoryPath, ".build/obj_bar_remap/planted.o")
+	err = os.Chown(planted, config.UserId, config.GroupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore()
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build/obj_foo_remap"), 0, 0)
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build/obj_foo_remap/keymap.o"), 0, 0)
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build/foo_remap.elf"), 0, 0)
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, "foo_remap.hex"), 0, 0)
+	for _, name := range []string{".build/foo_remap.bin", "foo_remap.uf2", ".build/obj_bar_remap/planted.o"} {
+		if _, err := os.Lstat(filepath.Join(qmkHomeDirectoryPath, name)); !os.IsNotExist(err) {
+			t.Error("Expected", name, "to be removed but got", err)
+		}
+	}
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build/obj_bar_remap/keymap.o"), 0, 0)
+}
+
+func Test_prepareSandboxDirectories_Converter(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Changing the owner requires root.")
+	}
+	qmkHomeDirectoryPath := t.TempDir()
+	config := DefaultSandboxConfig()
+
+	restore, err := prepareSandboxDirectories(qmkHomeDirectoryPath, t.TempDir(), "foo_remap", "foo_remap_rp2040_ce", config)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build/obj_foo_remap"), config.UserId, config.GroupId)
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build/obj_foo_remap_rp2040_ce"), config.UserId, config.GroupId)
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, "foo_remap_rp2040_ce.uf2"), config.UserId, config.GroupId)
+	restore()
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build/obj_foo_remap_rp2040_ce"), 0, 0)
+	if _, err := os.Lstat(filepath.Join(qmkHomeDirectoryPath, "foo_remap_rp2040_ce.uf2")); !os.IsNotExist(err) {
+		t.Error("Expected the empty file to be removed but got", err)
+	}
+}
+
+func Test_prepareSandboxDirectories_Concurrent(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Changing the owner requires root.")
+	}
+	qmkHomeDirectoryPath := t.TempDir()
+	config := DefaultSandboxConfig()
+
+	restore1, err := prepareSandboxDirectories(qmkHomeDirectoryPath, t.TempDir(), "foo_remap", "foo_remap", config)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	restore2, err := prepareSandboxDirectories(qmkHomeDirectoryPath, t.TempDir(), "bar_remap", "bar_remap", config)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	restore1()
+	// The other build is still running, so its outputs are kept.
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build/obj_bar_remap"), config.UserId, config.GroupId)
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, "bar_remap.hex"), config.UserId, config.GroupId)
+	restore2()
+	assertOwner(t, filepath.Join(qmkHomeDirectoryPath, ".build/obj_bar_remap"), 0, 0)
+	if _, err := os.Lstat(filepath.Join(qmkHomeDirectoryPath, "bar_remap.hex")); !os.IsNotExist(err) {
+		t.Error("Expected the empty file to be removed but got", err)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package build
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func applySandbox(cmd *exec.Cmd, config *SandboxConfig) {
+}
+
+func prepareSandboxHomeDirectory(homeDirectoryPath string, config *SandboxConfig) error {
+	return fmt.Errorf("the sandbox is supported only on Linux")
+}
+
+func prepareSandboxDirectories(qmkHomeDirectoryPath string, homeDirectoryPath string, targetName string, outputTargetName string, config *SandboxConfig) (func(), error) {
+	return nil, fmt.Errorf("the sandbox is supported only on Linux")
+}
+
+func signaledLimit(err error) string {
+	return ""
+}
@@ -0,0 +1,109 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+func Test_detectLimitExceeded_NoLimit(t *testing.T) {
+	actual := detectLimitExceeded("Compiling: keyboards/foo/foo.c [OK]", false, false)
+	if actual != "" {
+		t.Error("Expected empty string but got", actual)
+	}
+}
+
+func Test_detectLimitExceeded_Limits(t *testing.T) {
+	cases := []struct {
+		output   string
+		expected string
+	}{
+		{"make: *** [foo.o] CPU time limit exceeded", LimitCpu},
+		{"make: *** [foo.elf] File size limit exceeded", LimitFileSize},
+		{"cc1: out of memory allocating 65536 bytes", LimitMemory},
+		{"virtual memory exhausted: Cannot allocate memory", LimitMemory},
+		{"make: fork: Resource temporarily unavailable", LimitProcesses},
+	}
+	for _, c := range cases {
+		actual := detectLimitExceeded(c.output, false, false)
+		if actual != c.expected {
+			t.Error("Expected", c.expected, "but got", actual)
+		}
+	}
+}
+
+func Test_detectLimitExceeded_Timeout(t *testing.T) {
+	actual := detectLimitExceeded("CPU time limit exceeded", true, true)
+	if actual != LimitTimeout {
+		t.Error("Expected", LimitTimeout, "but got", actual)
+	}
+}
+
+func Test_detectLimitExceeded_Output(t *testing.T) {
+	actual := detectLimitExceeded("foo", true, false)
+	if actual != LimitOutput {
+		t.Error("Expected", LimitOutput, "but got", actual)
+	}
+}
+
+func Test_limitedBuffer(t *testing.T) {
+	buffer := &limitedBuffer{limit: 5}
+	n, err := buffer.Write([]byte("abc"))
+	if n != 3 || err != nil {
+		t.Error("Expected 3 and nil but got", n, err)
+	}
+	if buffer.truncated {
+		t.Error("Expected not truncated")
+	}
+	n, err = buffer.Write([]byte("defg"))
+	if n != 4 || err != nil {
+		t.Error("Expected 4 and nil but got", n, err)
+	}
+	if !buffer.truncated {
+		t.Error("Expected truncated")
+	}
+	if buffer.String() != "abcde" {
+		t.Error("Expected abcde but got", buffer.String())
+	}
+}
+
+func Test_createSandboxEnvironment(t *testing.T) {
+	actual := createSandboxEnvironment("/root/versions/0.22.14", "/tmp/remap-sandbox-1")
+	joined := strings.Join(actual, "\n")
+	if !strings.Contains(joined, "QMK_HOME=/root/versions/0.22.14") {
+		t.Error("Expected QMK_HOME but got", actual)
+	}
+	if !strings.Contains(joined, "HOME=/tmp/remap-sandbox-1") {
+		t.Error("Expected HOME but got", actual)
+	}
+	if strings.Contains(joined, "GOOGLE_") {
+		t.Error("Expected no credentials but got", actual)
+	}
+}
+
+func Test_createPythonPathEnvironment(t *testing.T) {
+	qmkHomeDirectoryPath := t.TempDir()
+	if actual := createPythonPathEnvironment(qmkHomeDirectoryPath); len(actual) != 0 {
+		t.Error("Expected no PYTHONPATH but got", actual)
+	}
+	packagesDirectoryPath := filepath.Join(qmkHomeDirectoryPath, common.PythonPackagesDirectoryName)
+	err := os.Mkdir(packagesDirectoryPath, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := createSandboxEnvironment(qmkHomeDirectoryPath, t.TempDir())
+	if actual[len(actual)-1] != "PYTHONPATH="+packagesDirectoryPath {
+		t.Error("Expected PYTHONPATH but got", actual)
+	}
+}
+
+func Test_createPrlimitArguments(t *testing.T) {
+	actual := createPrlimitArguments(&SandboxConfig{CpuSeconds: 1, MemoryBytes: 2, MaxProcesses: 3, MaxFileSizeBytes: 4})
+	expected := []string{"--cpu=1", "--as=2", "--nproc=3", "--fsize=4", "--"}
+	if strings.Join(actual, " ") != strings.Join(expected, " ") {
+		t.Error("Expected", expected, "but got", actual)
+	}
+}
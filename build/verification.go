@@ -0,0 +1,102 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"remap-keys.app/remap-build-server/convert"
+)
+
+// bootloaderFormats is the formats of the firmware file which each bootloader accepts.
+var bootloaderFormats = map[string][]string{
+	"atmel-dfu":    {ArtifactFormatHex},
+	"bootloadhid":  {ArtifactFormatHex},
+	"caterina":     {ArtifactFormatHex},
+	"halfkay":      {ArtifactFormatHex},
+	"lufa-dfu":     {ArtifactFormatHex},
+	"qmk-dfu":      {ArtifactFormatHex},
+	"qmk-hid":      {ArtifactFormatHex},
+	"usbasploader": {ArtifactFormatHex},
+	"lufa-ms":      {ArtifactFormatBin},
+	"apm32-dfu":    {ArtifactFormatBin},
+	"at32-dfu":     {ArtifactFormatBin},
+	"gd32v-dfu":    {ArtifactFormatBin},
+	"kiibohd":      {ArtifactFormatBin},
+	"md-boot":      {ArtifactFormatBin},
+	"stm32-dfu":    {ArtifactFormatBin},
+	"stm32duino":   {ArtifactFormatBin},
+	"wb32-dfu":     {ArtifactFormatBin},
+	"rp2040":       {ArtifactFormatUf2},
+	"tinyuf2":      {ArtifactFormatUf2, ArtifactFormatBin},
+	"uf2boot":      {ArtifactFormatUf2, ArtifactFormatBin},
+}
+
+// VerifyArtifacts checks whether the firmware files can be flashed before they are handed to the user.
+//   - Each file is not empty.
+//   - The records of the HEX file have the valid checksums.
+//   - The blocks of the UF2 file have the valid magic numbers, block numbers, block counts and family ID.
+//   - The files produced by QMK include the format which the bootloader of the keyboard accepts.
+func VerifyArtifacts(artifacts []Artifact, hardware KeyboardHardware) error {
+	if len(artifacts) == 0 {
+		return fmt.Errorf("no firmware file was produced")
+	}
+	for _, artifact := range artifacts {
+		err := verifyArtifact(artifact, hardware)
+		if err != nil {
+			return fmt.Errorf("%s is corrupt: %s", artifact.FileName, err.Error())
+		}
+	}
+	expectedFormats, ok := bootloaderFormats[strings.ToLower(hardware.Bootloader)]
+	if !ok {
+		return nil
+	}
+	var producedFormats []string
+	for _, artifact := range artifacts {
+		if artifact.ConvertedFrom != "" {
+			continue
+		}
+		for _, format := range expectedFormats {
+			if artifact.Format == format {
+				return nil
+			}
+		}
+		producedFormats = append(producedFormats, artifact.Format)
+	}
+	return fmt.Errorf("the bootloader %s expects %s, but the build produced %s",
+		hardware.Bootloader, strings.Join(expectedFormats, " or "), strings.Join(producedFormats, ", "))
+}
+
+// verifyArtifact checks the content of the firmware file according to the format.
+func verifyArtifact(artifact Artifact, hardware KeyboardHardware) error {
+	content, err := os.ReadFile(artifact.FilePath)
+	if err != nil {
+		return err
+	}
+	if len(content) == 0 {
+		return fmt.Errorf("the file is empty")
+	}
+	switch artifact.Format {
+	case ArtifactFormatHex:
+		image, err := convert.ParseIntelHex(content)
+		if err != nil {
+			return err
+		}
+		if image.Size() == 0 {
+			return fmt.Errorf("the file has no data")
+		}
+	case ArtifactFormatUf2:
+		var familyId uint32
+		if target, ok := convert.LookupTarget(hardware.Processor, hardware.Bootloader); ok {
+			familyId = target.FamilyId
+		}
+		return convert.ValidateUf2(content, familyId)
+	case ArtifactFormatBin:
+		if len(content) > convert.MaxImageSize {
+			return fmt.Errorf("the size %d exceeds the limit of %d bytes", len(content), convert.MaxImageSize)
+		}
+	default:
+		return fmt.Errorf("unknown format: %s", artifact.Format)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package build
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func createTestArtifact(t *testing.T, directoryPath string, fileName string, format string, content string) Artifact {
+	t.Helper()
+	writeTopLevelFile(t, directoryPath, fileName, content)
+	return Artifact{FileName: fileName, FilePath: filepath.Join(directoryPath, fileName), Format: format}
+}
+
+func Test_VerifyArtifacts_Valid(t *testing.T) {
+	directoryPath := t.TempDir()
+	artifacts := []Artifact{
+		createTestArtifact(t, directoryPath, "foo_remap.hex", ArtifactFormatHex, ":0400000001020304F2\n:00000001FF\n"),
+	}
+	err := VerifyArtifacts(artifacts, KeyboardHardware{Processor: "atmega32u4", Bootloader: "caterina"})
+	if err != nil {
+		t.Error("Expected nil but got", err)
+	}
+}
+
+func Test_VerifyArtifacts_Converted(t *testing.T) {
+	directoryPath := t.TempDir()
+	hardware := KeyboardHardware{Processor: "STM32F411", Bootloader: "stm32-dfu"}
+	source := createTestArtifact(t, directoryPath, "foo_remap.bin", ArtifactFormatBin, "\x01\x02\x03\x04")
+	converted, err := convertArtifacts([]Artifact{source}, hardware)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	err = VerifyArtifacts(append([]Artifact{source}, converted...), hardware)
+	if err != nil {
+		t.Error("Expected nil but got", err)
+	}
+}
+
+func Test_VerifyArtifacts_Invalid(t *testing.T) {
+	directoryPath := t.TempDir()
+	cases := map[string]struct {
+		artifacts []Artifact
+		hardware  KeyboardHardware
+	}{
+		"no artifacts": {nil, KeyboardHardware{}},
+		"empty file": {[]Artifact{
+			createTestArtifact(t, directoryPath, "empty_remap.bin", ArtifactFormatBin, ""),
+		}, KeyboardHardware{}},
+		"checksum": {[]Artifact{
+			createTestArtifact(t, directoryPath, "checksum_remap.hex", ArtifactFormatHex, ":0400000001020304F3\n:00000001FF\n"),
+		}, KeyboardHardware{}},
+		"truncated hex": {[]Artifact{
+			createTestArtifact(t, directoryPath, "truncated_remap.hex", ArtifactFormatHex, ":0400000001020304F2\n"),
+		}, KeyboardHardware{}},
+		"truncated uf2": {[]Artifact{
+			createTestArtifact(t, directoryPath, "truncated_remap.uf2", ArtifactFormatUf2, "UF2\n"),
+		}, KeyboardHardware{}},
+		"unexpected format": {[]Artifact{
+			createTestArtifact(t, directoryPath, "format_remap.bin", ArtifactFormatBin, "\x01\x02\x03\x04"),
+		}, KeyboardHardware{Processor: "atmega32u4", Bootloader: "caterina"}},
+	}
+	for name, c := range cases {
+		err := VerifyArtifacts(c.artifacts, c.hardware)
+		if err == nil {
+			t.Error("Expected error but got nil for", name)
+		}
+	}
+}
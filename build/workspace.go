@@ -0,0 +1,424 @@
+package build
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+// WorkspaceConfig represents the persistent build directories of the Workbench projects.
+type WorkspaceConfig struct {
+	// BaseDirectoryPath is the directory holding a workspace for each QMK Firmware version and project.
+	BaseDirectoryPath string
+	// MaxWorkspaces is the maximum number of the workspaces kept on the disk.
+	// The least recently used workspaces are evicted when the number exceeds it.
+	MaxWorkspaces int
+}
+
+// DefaultWorkspaceConfig returns the workspace configuration for the directory created in the Dockerfile.
+func DefaultWorkspaceConfig() *WorkspaceConfig {
+	return &WorkspaceConfig{
+		BaseDirectoryPath: "/root/workspaces/",
+		MaxWorkspaces:     32,
+	}
+}
+
+// Workspace keeps the keyboard directory, the userspace directory and the object directories of a project
+// across the builds, so make can rebuild only the changed files.
+// While the workspace is open, the directories are moved into the QMK Firmware directory,
+// and they are moved back to the workspace by Close.
+type Workspace struct {
+	config               *WorkspaceConfig
+	directoryPath        string
+	qmkHomeDirectoryPath string
+	keyboardId           string
+	userName             string
+	variants             []common.BuildVariant
+}
+
+var workspaceLocksMutex sync.Mutex
+var workspaceLocks = map[string]*workspaceLock{}
+
+// workspaceLock serializes the builds of the same workspace. users is the number of the builds holding or waiting for it.
+type workspaceLock struct {
+	mutex sync.Mutex
+	users int
+}
+
+// CreateWorkspaceName creates the name of the workspace of the project.
+// It is also used as the keyboard ID and the userspace name, which must be stable across the builds of the project.
+func CreateWorkspaceName(projectId string) string {
+	hash := sha256.Sum256([]byte(projectId))
+	return userspaceNamePrefix + hex.EncodeToString(hash[:8])
+}
+
+// OpenWorkspace opens the workspace of the project for the QMK Firmware version, and moves the directories kept in
+// the workspace into the QMK Firmware directory. If the project is being built, it waits until the build finishes.
+// The empty user name means that no userspace is used. Close must be called after the build.
+func OpenWorkspace(config *WorkspaceConfig, qmkFirmwareVersion string, projectId string, keyboardId string, userName string, variants []common.BuildVariant) (*Workspace, error) {
+	return openWorkspace(config, QmkFirmwareBaseDirectoryPath+qmkFirmwareVersion,
+		filepath.Join(config.BaseDirectoryPath, qmkFirmwareVersion, CreateWorkspaceName(projectId)),
+		keyboardId, userName, variants)
+}
+
+func openWorkspace(config *WorkspaceConfig, qmkHomeDirectoryPath string, directoryPath string, keyboardId string, userName string, variants []common.BuildVariant) (*Workspace, error) {
+	workspace := &Workspace{
+		config:               config,
+		directoryPath:        directoryPath,
+		qmkHomeDirectoryPath: qmkHomeDirectoryPath,
+		keyboardId:           keyboardId,
+		userName:             userName,
+		variants:             variants,
+	}
+	acquireWorkspaceLock(directoryPath)
+	log.Printf("[INFO] Opening the workspace: %s\n", directoryPath)
+	// The outputs left in the tree by the previous build must not be linked into the firmware.
+	err := cleanBuildOutputs(qmkHomeDirectoryPath, keyboardId, variants)
+	if err == nil {
+		err = workspace.restore()
+	}
+	if err != nil {
+		// The broken workspace is discarded, and the build starts from the empty directories.
+		log.Printf("[ERROR] Restoring the workspace failed: %s\n", err.Error())
+		err = workspace.reset()
+		if err != nil {
+			releaseWorkspaceLock(directoryPath)
+			return nil, err
+		}
+	}
+	return workspace, nil
+}
+
+// KeyboardDirectoryPath returns the path of the keyboard directory in the QMK Firmware directory.
+func (w *Workspace) KeyboardDirectoryPath() string {
+	return filepath.Join(w.qmkHomeDirectoryPath, "keyboards", w.keyboardId)
+}
+
+// UserspaceDirectoryPath returns the path of the userspace directory in the QMK Firmware directory.
+func (w *Workspace) UserspaceDirectoryPath() string {
+	return filepath.Join(w.qmkHomeDirectoryPath, "users", w.userName)
+}
+
+// pairs returns the pairs of the path in the workspace and the path in the QMK Firmware directory.
+func (w *Workspace) pairs() [][2]string {
+	pairs := [][2]string{{filepath.Join(w.directoryPath, "keyboard"), w.KeyboardDirectoryPath()}}
+	if w.userName != "" {
+		pairs = append(pairs, [2]string{filepath.Join(w.directoryPath, "userspace"), w.UserspaceDirectoryPath()})
+	}
+	for _, variant := range w.variants {
+		name := "obj_" + createTargetName(BuildOptions{
+			KeyboardId: w.keyboardId,
+			Revision:   variant.Revision,
+			KeymapName: variant.KeymapName,
+		})
+		pairs = append(pairs, [2]string{filepath.Join(w.directoryPath, "obj", name), filepath.Join(w.qmkHomeDirectoryPath, ".build", name)})
+	}
+	return pairs
+}
+
+// restore moves the directories kept in the workspace into the QMK Firmware directory.
+// The directories left in the QMK Firmware directory by the previous build are replaced.
+func (w *Workspace) restore() error {
+	err := os.MkdirAll(filepath.Join(w.directoryPath, "obj"), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Join(w.qmkHomeDirectoryPath, ".build"), 0755)
+	if err != nil {
+		return err
+	}
+	for _, pair := range w.pairs() {
+		err = os.RemoveAll(pair[1])
+		if err != nil {
+			return err
+		}
+		_, err = os.Stat(pair[0])
+		if os.IsNotExist(err) {
+			continue
+		}
+		err = os.Rename(pair[0], pair[1])
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Restored from the workspace: %s\n", pair[1])
+	}
+	return w.createMissingDirectories()
+}
+
+// reset discards the workspace and creates the empty directories in the QMK Firmware directory.
+func (w *Workspace) reset() error {
+	err := os.RemoveAll(w.directoryPath)
+	if err != nil {
+		return err
+	}
+	for _, pair := range w.pairs() {
+		err = os.RemoveAll(pair[1])
+		if err != nil {
+			return err
+		}
+	}
+	return w.createMissingDirectories()
+}
+
+// createMissingDirectories creates the keyboard directory and the userspace directory if they do not exist.
+func (w *Workspace) createMissingDirectories() error {
+	err := os.MkdirAll(w.KeyboardDirectoryPath(), 0755)
+	if err != nil {
+		return err
+	}
+	if w.userName != "" {
+		return os.MkdirAll(w.UserspaceDirectoryPath(), 0755)
+	}
+	return nil
+}
+
+// Close moves the directories back to the workspace and removes the other build outputs,
+// then evicts the least recently used workspaces.
+// If moving fails, the workspace is discarded, so the next build starts from the empty directories.
+func (w *Workspace) Close() error {
+	defer releaseWorkspaceLock(w.directoryPath)
+	log.Printf("[INFO] Closing the workspace: %s\n", w.directoryPath)
+	err := w.save()
+	if err != nil {
+		log.Printf("[ERROR] Saving the workspace failed: %s\n", err.Error())
+		for _, pair := range w.pairs() {
+			os.RemoveAll(pair[1])
+		}
+		err = os.RemoveAll(w.directoryPath)
+		if err != nil {
+			return err
+		}
+	}
+	// The build outputs are removed while the workspace is locked,
+	// so the directories restored by the next build of the project are not removed.
+	err = cleanBuildOutputs(w.qmkHomeDirectoryPath, w.keyboardId, w.variants)
+	if err != nil {
+		return err
+	}
+	evictWorkspaces(w.config)
+	return nil
+}
+
+// save moves the directories in the QMK Firmware directory to the workspace, and marks the workspace as used now.
+func (w *Workspace) save() error {
+	err := os.MkdirAll(filepath.Join(w.directoryPath, "obj"), 0755)
+	if err != nil {
+		return err
+	}
+	// The userspace directory is not used by this build, so the kept one is stale.
+	if w.userName == "" {
+		err = os.RemoveAll(filepath.Join(w.directoryPath, "userspace"))
+		if err != nil {
+			return err
+		}
+	}
+	for _, pair := range w.pairs() {
+		err = os.RemoveAll(pair[0])
+		if err != nil {
+			return err
+		}
+		_, err = os.Stat(pair[1])
+		if os.IsNotExist(err) {
+			continue
+		}
+		err = os.Rename(pair[1], pair[0])
+		if err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	return os.Chtimes(w.directoryPath, now, now)
+}
+
+func acquireWorkspaceLock(directoryPath string) {
+	workspaceLocksMutex.Lock()
+	lock, ok := workspaceLocks[directoryPath]
+	if !ok {
+		lock = &workspaceLock{}
+		workspaceLocks[directoryPath] = lock
+	}
+	lock.users++
+	workspaceLocksMutex.Unlock()
+	lock.mutex.Lock()
+}
+
+func releaseWorkspaceLock(directoryPath string) {
+	workspaceLocksMutex.Lock()
+	defer workspaceLocksMutex.Unlock()
+	lock := workspaceLocks[directoryPath]
+	lock.mutex.Unlock()
+	lock.users--
+	if lock.users == 0 {
+		delete(workspaceLocks, directoryPath)
+	}
+}
+
+// evictWorkspaces removes the least recently used workspaces over the maximum number.
+// The workspaces used by the running builds are not removed.
+func evictWorkspaces(config *WorkspaceConfig) {
+	workspaceLocksMutex.Lock()
+	defer workspaceLocksMutex.Unlock()
+	type entry struct {
+		path    string
+		modTime time.Time
+	}
+	var entries []entry
+	versionEntries, err := os.ReadDir(config.BaseDirectoryPath)
+	if err != nil {
+		log.Printf("[ERROR] %s\n", err.Error())
+		return
+	}
+	for _, versionEntry := range versionEntries {
+		if !versionEntry.IsDir() {
+			continue
+		}
+		versionDirectoryPath := filepath.Join(config.BaseDirectoryPath, versionEntry.Name())
+		workspaceEntries, err := os.ReadDir(versionDirectoryPath)
+		if err != nil {
+			log.Printf("[ERROR] %s\n", err.Error())
+			continue
+		}
+		for _, workspaceEntry := range workspaceEntries {
+			info, err := workspaceEntry.Info()
+			if err != nil || !info.IsDir() {
+				continue
+			}
+			entries = append(entries, entry{path: filepath.Join(versionDirectoryPath, workspaceEntry.Name()), modTime: info.ModTime()})
+		}
+	}
+	if len(entries) <= config.MaxWorkspaces {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	count := len(entries)
+	for _, e := range entries {
+		if count <= config.MaxWorkspaces {
+			break
+		}
+		if _, ok := workspaceLocks[e.path]; ok {
+			continue
+		}
+		log.Printf("[INFO] Evicting the workspace: %s\n", e.path)
+		err = os.RemoveAll(e.path)
+		if err != nil {
+			log.Printf("[ERROR] %s\n", err.Error())
+			continue
+		}
+		count--
+	}
+}
+
+// SyncKeyboardFiles makes the keyboard directory have the keyboard files and the keymap files of each keymap used by
+// the variants, in the same layout as CreateFiles and CreateKeymapFiles. Only the changed files are rewritten,
+// so the modification times of the other files are kept for the incremental build.
+func SyncKeyboardFiles(keyboardDirectoryPath string, variants []common.BuildVariant, keyboardFiles []common.BuildableFile, keymapFiles []common.BuildableFile) error {
+	files := map[string][]byte{}
+	err := addBuildableFiles(files, "", keyboardFiles)
+	if err != nil {
+		return err
+	}
+	for _, variant := range variants {
+		err = addBuildableFiles(files, filepath.Join("keymaps", variant.KeymapName), keymapFiles)
+		if err != nil {
+			return err
+		}
+	}
+	return syncFiles(keyboardDirectoryPath, files)
+}
+
+// SyncFiles makes the directory have the files. Only the changed files are rewritten.
+func SyncFiles(baseDirectoryPath string, buildableFiles []common.BuildableFile) error {
+	files := map[string][]byte{}
+	err := addBuildableFiles(files, "", buildableFiles)
+	if err != nil {
+		return err
+	}
+	return syncFiles(baseDirectoryPath, files)
+}
+
+func addBuildableFiles(files map[string][]byte, directoryPath string, buildableFiles []common.BuildableFile) error {
+	for _, buildableFile := range buildableFiles {
+		err := ValidateFilePath(buildableFile.GetPath())
+		if err != nil {
+			return err
+		}
+		content, err := common.DecodeContent(buildableFile)
+		if err != nil {
+			return err
+		}
+		files[filepath.Join(directoryPath, buildableFile.GetPath())] = content
+	}
+	return nil
+}
+
+// syncFiles removes the files which are not in the passed files and the empty directories,
+// then writes the passed files whose contents differ from the existing files.
+// The passed files are keyed by the path relative to the directory.
+func syncFiles(baseDirectoryPath string, files map[string][]byte) error {
+	var directoryPaths []string
+	err := filepath.WalkDir(baseDirectoryPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == baseDirectoryPath {
+			return nil
+		}
+		if d.IsDir() {
+			directoryPaths = append(directoryPaths, path)
+			return nil
+		}
+		relativePath, err := filepath.Rel(baseDirectoryPath, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := files[relativePath]; ok && d.Type().IsRegular() {
+			return nil
+		}
+		return os.Remove(path)
+	})
+	if err != nil {
+		return err
+	}
+	// Remove the empty directories from the deepest one.
+	for i := len(directoryPaths) - 1; i >= 0; i-- {
+		entries, err := os.ReadDir(directoryPaths[i])
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			err = os.Remove(directoryPaths[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for relativePath, content := range files {
+		targetFilePath := filepath.Join(baseDirectoryPath, relativePath)
+		existing, err := os.ReadFile(targetFilePath)
+		if err == nil && bytes.Equal(existing, content) {
+			continue
+		}
+		log.Printf("[INFO] targetFilePath: %s\n", targetFilePath)
+		err = os.MkdirAll(filepath.Dir(targetFilePath), 0755)
+		if err != nil {
+			return err
+		}
+		err = createFile(targetFilePath, content)
+		if err != nil {
+			return fmt.Errorf("writing %s failed: %s", relativePath, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+func Test_CreateWorkspaceName(t *testing.T) {
+	actual := CreateWorkspaceName("project1")
+	if !regexp.MustCompile(`^remap_[0-9a-f]{16}$`).MatchString(actual) {
+		t.Error("Expected remap_<hash> but got", actual)
+	}
+	if actual != CreateWorkspaceName("project1") {
+		t.Error("Expected the same name for the same project but got", actual)
+	}
+	if actual == CreateWorkspaceName("project2") {
+		t.Error("Expected different names for different projects but got", actual)
+	}
+}
+
+func Test_SyncFiles_KeepsUnchangedFiles(t *testing.T) {
+	directoryPath := t.TempDir()
+	err := SyncFiles(directoryPath, []common.BuildableFile{
+		common.FirmwareFile{Path: "config.h", Content: "#pragma once"},
+		common.FirmwareFile{Path: "lib/old.c", Content: "// old"},
+	})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	err = os.Chtimes(filepath.Join(directoryPath, "config.h"), past, past)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SyncFiles(directoryPath, []common.BuildableFile{
+		common.FirmwareFile{Path: "config.h", Content: "#pragma once"},
+		common.FirmwareFile{Path: "rules.mk", Content: "OLED_ENABLE = yes"},
+	})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	info, err := os.Stat(filepath.Join(directoryPath, "config.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(past) {
+		t.Error("Expected", past, "but got", info.ModTime())
+	}
+	content, err := os.ReadFile(filepath.Join(directoryPath, "rules.mk"))
+	if err != nil || string(content) != "OLED_ENABLE = yes" {
+		t.Error("Expected OLED_ENABLE = yes but got", string(content), err)
+	}
+	_, err = os.Stat(filepath.Join(directoryPath, "lib"))
+	if !os.IsNotExist(err) {
+		t.Error("Expected the stale directory to be removed but got", err)
+	}
+}
+
+func Test_SyncFiles_RewritesChangedFiles(t *testing.T) {
+	directoryPath := t.TempDir()
+	err := SyncFiles(directoryPath, []common.BuildableFile{common.FirmwareFile{Path: "config.h", Content: "#define A 1"}})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	err = SyncFiles(directoryPath, []common.BuildableFile{common.FirmwareFile{Path: "config.h", Content: "#define A 2"}})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	content, err := os.ReadFile(filepath.Join(directoryPath, "config.h"))
+	if err != nil || string(content) != "#define A 2" {
+		t.Error("Expected #define A 2 but got", string(content), err)
+	}
+}
+
+func Test_SyncFiles_FileReplacedByDirectory(t *testing.T) {
+	directoryPath := t.TempDir()
+	err := SyncFiles(directoryPath, []common.BuildableFile{common.FirmwareFile{Path: "lib", Content: "file"}})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	err = SyncFiles(directoryPath, []common.BuildableFile{common.FirmwareFile{Path: "lib/font.c", Content: "// font"}})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	content, err := os.ReadFile(filepath.Join(directoryPath, "lib", "font.c"))
+	if err != nil || string(content) != "// font" {
+		t.Error("Expected // font but got", string(content), err)
+	}
+}
+
+func Test_SyncFiles_InvalidPath(t *testing.T) {
+	err := SyncFiles(t.TempDir(), []common.BuildableFile{common.FirmwareFile{Path: "../rules.mk", Content: ""}})
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_SyncKeyboardFiles(t *testing.T) {
+	directoryPath := t.TempDir()
+	err := SyncKeyboardFiles(directoryPath,
+		[]common.BuildVariant{{KeymapName: "remap"}, {KeymapName: "via", Revision: "rev1"}},
+		[]common.BuildableFile{common.FirmwareFile{Path: "rules.mk", Content: "OLED_ENABLE = yes"}},
+		[]common.BuildableFile{common.FirmwareFile{Path: "keymap.c", Content: "// keymap"}})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	for _, path := range []string{"rules.mk", "keymaps/remap/keymap.c", "keymaps/via/keymap.c"} {
+		if _, err := os.Stat(filepath.Join(directoryPath, path)); err != nil {
+			t.Error("Expected", path, "to exist but got", err)
+		}
+	}
+}
+
+func Test_Workspace_OpenAndClose(t *testing.T) {
+	config := &WorkspaceConfig{BaseDirectoryPath: t.TempDir(), MaxWorkspaces: 2}
+	qmkHomeDirectoryPath := t.TempDir()
+	directoryPath := filepath.Join(config.BaseDirectoryPath, "0.22.14", "remap_1")
+	variants := []common.BuildVariant{{KeymapName: "remap"}}
+
+	workspace, err := openWorkspace(config, qmkHomeDirectoryPath, directoryPath, "foo", "remap_1", variants)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	err = SyncFiles(workspace.KeyboardDirectoryPath(), []common.BuildableFile{common.FirmwareFile{Path: "config.h", Content: "#pragma once"}})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	_, err = os.Stat(workspace.UserspaceDirectoryPath())
+	if err != nil {
+		t.Error("Expected the userspace directory but got", err)
+	}
+	objFilePath := filepath.Join(qmkHomeDirectoryPath, ".build", "obj_foo_remap", "keymap.o")
+	err = os.MkdirAll(filepath.Dir(objFilePath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(objFilePath, []byte("object"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = workspace.Close()
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	for _, path := range []string{workspace.KeyboardDirectoryPath(), workspace.UserspaceDirectoryPath(), filepath.Dir(objFilePath)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Error("Expected", path, "to be moved to the workspace but got", err)
+		}
+	}
+
+	workspace, err = openWorkspace(config, qmkHomeDirectoryPath, directoryPath, "foo", "remap_1", variants)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	defer workspace.Close()
+	content, err := os.ReadFile(filepath.Join(workspace.KeyboardDirectoryPath(), "config.h"))
+	if err != nil || string(content) != "#pragma once" {
+		t.Error("Expected #pragma once but got", string(content), err)
+	}
+	content, err = os.ReadFile(objFilePath)
+	if err != nil || string(content) != "object" {
+		t.Error("Expected object but got", string(content), err)
+	}
+}
+
+func Test_EvictWorkspaces(t *testing.T) {
+	config := &WorkspaceConfig{BaseDirectoryPath: t.TempDir(), MaxWorkspaces: 2}
+	now := time.Now()
+	names := []string{"remap_1", "remap_2", "remap_3"}
+	for i, name := range names {
+		path := filepath.Join(config.BaseDirectoryPath, "0.22.14", name)
+		err := os.MkdirAll(path, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(time.Duration(i-len(names)) * time.Hour)
+		err = os.Chtimes(path, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	evictWorkspaces(config)
+	for i, name := range names {
+		_, err := os.Stat(filepath.Join(config.BaseDirectoryPath, "0.22.14", name))
+		if i == 0 && !os.IsNotExist(err) {
+			t.Error("Expected", name, "to be evicted but got", err)
+		}
+		if i != 0 && err != nil {
+			t.Error("Expected", name, "to be kept but got", err)
+		}
+	}
+}
+
+func Test_EvictWorkspaces_SkipsOpenWorkspace(t *testing.T) {
+	config := &WorkspaceConfig{BaseDirectoryPath: t.TempDir(), MaxWorkspaces: 0}
+	path := filepath.Join(config.BaseDirectoryPath, "0.22.14", "remap_1")
+	workspace, err := openWorkspace(config, t.TempDir(), path, "foo", "", nil)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	defer workspace.Close()
+	evictWorkspaces(config)
+	if _, err := os.Stat(path); err != nil {
+		t.Error("Expected the open workspace to be kept but got", err)
+	}
+}
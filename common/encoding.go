@@ -0,0 +1,29 @@
+package common
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+const (
+	// FileEncodingUtf8 is the encoding of the text file. The empty encoding is treated as this.
+	FileEncodingUtf8 string = "utf8"
+	// FileEncodingBase64 is the encoding of the binary file, like an OLED logo, a font or a precompiled blob.
+	FileEncodingBase64 string = "base64"
+)
+
+// DecodeContent decodes the content of the file to the bytes written to the disk.
+func DecodeContent(file BuildableFile) ([]byte, error) {
+	switch file.GetEncoding() {
+	case "", FileEncodingUtf8:
+		return []byte(file.GetContent()), nil
+	case FileEncodingBase64:
+		content, err := base64.StdEncoding.DecodeString(file.GetContent())
+		if err != nil {
+			return nil, fmt.Errorf("the content of %s is not valid base64: %s", file.GetPath(), err.Error())
+		}
+		return content, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding of %s: %s", file.GetPath(), file.GetEncoding())
+	}
+}
@@ -5,46 +5,147 @@ import (
 )
 
 type Task struct {
-	Uid              string    `firestore:"uid"`
-	Status           string    `firestore:"status"`
-	FirmwareId       string    `firestore:"firmwareId"`
-	ProjectId        string    `firestore:"projectId"`
-	FirmwareFilePath string    `firestore:"firmwareFilePath"`
-	Stdout           string    `firestore:"stdout"`
-	Stderr           string    `firestore:"stderr"`
-	ParametersJson   string    `firestore:"parametersJson"`
-	CreatedAt        time.Time `firestore:"createdAt"`
-	UpdatedAt        time.Time `firestore:"updatedAt"`
+	Uid                 string                  `firestore:"uid"`
+	Status              string                  `firestore:"status"`
+	FirmwareId          string                  `firestore:"firmwareId"`
+	ProjectId           string                  `firestore:"projectId"`
+	FirmwareFilePath    string                  `firestore:"firmwareFilePath"`
+	Stdout              string                  `firestore:"stdout"`
+	Stderr              string                  `firestore:"stderr"`
+	ParametersJson      string                  `firestore:"parametersJson"`
+	KeymapName          string                  `firestore:"keymapName"`
+	Variants            []BuildVariant          `firestore:"variants"`
+	Artifacts           []TaskArtifact          `firestore:"artifacts"`
+	LintMode            string                  `firestore:"lintMode"`
+	LintMessages        []LintMessage           `firestore:"lintMessages"`
+	ScanViolations      []ScanViolation         `firestore:"scanViolations"`
+	LimitExceeded       string                  `firestore:"limitExceeded"`
+	QmkFirmwareVersion  string                  `firestore:"qmkFirmwareVersion"`
+	Warnings            []string                `firestore:"warnings"`
+	CompilerCacheStats  *CompilerCacheStats     `firestore:"compilerCacheStats"`
+	ManifestFilePath    string                  `firestore:"manifestFilePath"`
+	DefinitionFilePath  string                  `firestore:"definitionFilePath"`
+	DryRun              bool                    `firestore:"dryRun"`
+	RenderedFilePath    string                  `firestore:"renderedFilePath"`
+	Reproducibility     []ReproducibilityResult `firestore:"reproducibility"`
+	Charged             bool                    `firestore:"charged"`
+	InfrastructureError string                  `firestore:"infrastructureError"`
+	StageTimings        []StageTiming           `firestore:"stageTimings"`
+	CreatedAt           time.Time               `firestore:"createdAt"`
+	UpdatedAt           time.Time               `firestore:"updatedAt"`
+}
+
+type ReproducibilityResult struct {
+	KeymapName          string               `firestore:"keymapName"`
+	Revision            string               `firestore:"revision"`
+	Reproducible        bool                 `firestore:"reproducible"`
+	ArtifactDifferences []ArtifactDifference `firestore:"artifactDifferences"`
+	SectionDifferences  []SectionDifference  `firestore:"sectionDifferences"`
+}
+
+type ArtifactDifference struct {
+	FileName     string `firestore:"fileName"`
+	FirstSha256  string `firestore:"firstSha256"`
+	SecondSha256 string `firestore:"secondSha256"`
+}
+
+type SectionDifference struct {
+	Name       string `firestore:"name"`
+	FirstSize  int64  `firestore:"firstSize"`
+	SecondSize int64  `firestore:"secondSize"`
+	Offset     int64  `firestore:"offset"`
+}
+
+type StageTiming struct {
+	Name           string `firestore:"name"`
+	DurationMillis int64  `firestore:"durationMillis"`
+}
+
+type CompilerCacheStats struct {
+	Hits        int `firestore:"hits"`
+	Misses      int `firestore:"misses"`
+	Uncacheable int `firestore:"uncacheable"`
+}
+
+type BuildVariant struct {
+	KeymapName string `firestore:"keymapName"`
+	Revision   string `firestore:"revision"`
+}
+
+type TaskArtifact struct {
+	KeymapName       string `firestore:"keymapName"`
+	Revision         string `firestore:"revision"`
+	FirmwareFilePath string `firestore:"firmwareFilePath"`
+	DownloadFileName string `firestore:"downloadFileName"`
+	Format           string `firestore:"format"`
+	ConvertedFrom    string `firestore:"convertedFrom"`
+}
+
+type LintMessage struct {
+	KeymapName string `firestore:"keymapName"`
+	Revision   string `firestore:"revision"`
+	Level      string `firestore:"level"`
+	Message    string `firestore:"message"`
+}
+
+type ScanViolation struct {
+	Path    string `firestore:"path"`
+	Line    int    `firestore:"line"`
+	Rule    string `firestore:"rule"`
+	Message string `firestore:"message"`
 }
 
 type Firmware struct {
-	KeyboardDefinitionId  string    `firestore:"keyboardDefinitionId"`
-	Uid                   string    `firestore:"uid"`
-	Enabled               bool      `firestore:"enabled"`
-	QmkFirmwareVersion    string    `firestore:"qmkFirmwareVersion"`
-	KeyboardDirectoryName string    `firestore:"keyboardDirectoryName"`
-	CreatedAt             time.Time `firestore:"createdAt"`
-	UpdatedAt             time.Time `firestore:"updatedAt"`
+	KeyboardDefinitionId  string            `firestore:"keyboardDefinitionId"`
+	Uid                   string            `firestore:"uid"`
+	Enabled               bool              `firestore:"enabled"`
+	QmkFirmwareVersion    string            `firestore:"qmkFirmwareVersion"`
+	KeyboardDirectoryName string            `firestore:"keyboardDirectoryName"`
+	KeymapName            string            `firestore:"keymapName"`
+	EnvironmentVariables  map[string]string `firestore:"environmentVariables"`
+	Defines               []string          `firestore:"defines"`
+	SourceRepository      string            `firestore:"sourceRepository"`
+	SourceRef             string            `firestore:"sourceRef"`
+	OverlayPolicy         *OverlayPolicy    `firestore:"overlayPolicy"`
+	CreatedAt             time.Time         `firestore:"createdAt"`
+	UpdatedAt             time.Time         `firestore:"updatedAt"`
+}
+
+type OverlayPolicy struct {
+	Keyboard *OverlayRule `firestore:"keyboard"`
+	Keymap   *OverlayRule `firestore:"keymap"`
+}
+
+type OverlayRule struct {
+	Overridable []string `firestore:"overridable"`
+	Locked      []string `firestore:"locked"`
 }
 
 type WorkbenchProject struct {
-	Name                  string    `firestore:"name"`
-	QmkFirmwareVersion    string    `firestore:"qmkFirmwareVersion"`
-	Uid                   string    `firestore:"uid"`
-	KeyboardDirectoryName string    `firestore:"keyboardDirectoryName"`
-	CreatedAt             time.Time `firestore:"createdAt"`
-	UpdatedAt             time.Time `firestore:"updatedAt"`
+	Name                  string            `firestore:"name"`
+	QmkFirmwareVersion    string            `firestore:"qmkFirmwareVersion"`
+	Uid                   string            `firestore:"uid"`
+	KeyboardDirectoryName string            `firestore:"keyboardDirectoryName"`
+	KeymapName            string            `firestore:"keymapName"`
+	EnvironmentVariables  map[string]string `firestore:"environmentVariables"`
+	Defines               []string          `firestore:"defines"`
+	SourceRepository      string            `firestore:"sourceRepository"`
+	SourceRef             string            `firestore:"sourceRef"`
+	CreatedAt             time.Time         `firestore:"createdAt"`
+	UpdatedAt             time.Time         `firestore:"updatedAt"`
 }
 
 type BuildableFile interface {
 	GetPath() string
 	GetContent() string
+	GetEncoding() string
 }
 
 type FirmwareFile struct {
-	ID      string `firestore:"-"`
-	Path    string `firestore:"path"`
-	Content string `firestore:"content"`
+	ID       string `firestore:"-"`
+	Path     string `firestore:"path"`
+	Content  string `firestore:"content"`
+	Encoding string `firestore:"encoding"`
 }
 
 func (f FirmwareFile) GetPath() string {
@@ -55,10 +156,15 @@ func (f FirmwareFile) GetContent() string {
 	return f.Content
 }
 
+func (f FirmwareFile) GetEncoding() string {
+	return f.Encoding
+}
+
 type WorkbenchProjectFile struct {
 	ID        string    `firestore:"-"`
 	Path      string    `firestore:"path"`
 	Content   string    `firestore:"code"`
+	Encoding  string    `firestore:"encoding"`
 	FileType  string    `firestore:"fileType"`
 	CreatedAt time.Time `firestore:"createdAt"`
 	UpdatedAt time.Time `firestore:"updatedAt"`
@@ -72,6 +178,10 @@ func (w WorkbenchProjectFile) GetContent() string {
 	return w.Content
 }
 
+func (w WorkbenchProjectFile) GetEncoding() string {
+	return w.Encoding
+}
+
 type UserPurchase struct {
 	ID                  string    `firestore:"-"`
 	RemainingBuildCount int       `firestore:"remainingBuildCount"`
@@ -82,6 +192,9 @@ type UserPurchase struct {
 type RequestParameters struct {
 	Uid    string
 	TaskId string
+	Mode   string
+	// RetryCount is the number of the retries of the request by Cloud Tasks.
+	RetryCount int
 }
 
 type ParametersJsonVersion1 struct {
@@ -93,6 +206,18 @@ type ParametersJson struct {
 	Version  int8                       `json:"version"`
 	Keyboard map[string]*ParameterValue `json:"keyboard"`
 	Keymap   map[string]*ParameterValue `json:"keymap"`
+	Overlays *OverlayFiles              `json:"overlays,omitempty"`
+}
+
+type OverlayFiles struct {
+	Keyboard []*OverlayFile `json:"keyboard"`
+	Keymap   []*OverlayFile `json:"keymap"`
+}
+
+type OverlayFile struct {
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"`
 }
 
 type ParameterValue struct {
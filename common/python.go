@@ -0,0 +1,5 @@
+package common
+
+// PythonPackagesDirectoryName is the directory in the QMK Firmware tree materialized from a fork, where the Python
+// packages in the requirements file of the tree are installed. The commands running in the tree find them with PYTHONPATH.
+const PythonPackagesDirectoryName string = ".remap-python"
@@ -0,0 +1,129 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	recordTypeData                   byte = 0x00
+	recordTypeEndOfFile              byte = 0x01
+	recordTypeExtendedSegmentAddress byte = 0x02
+	recordTypeStartSegmentAddress    byte = 0x03
+	recordTypeExtendedLinearAddress  byte = 0x04
+	recordTypeStartLinearAddress     byte = 0x05
+)
+
+const hexRecordDataSize = 16
+
+// ParseIntelHex parses the Intel HEX file.
+// The data records, the extended segment address records and the extended linear address records are supported.
+// The start address records are ignored.
+func ParseIntelHex(content []byte) (*Image, error) {
+	image := &Image{}
+	var upperAddress uint32
+	endOfFile := false
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if endOfFile {
+			return nil, fmt.Errorf("line %d: the record after the end of file record", lineNumber)
+		}
+		if !strings.HasPrefix(line, ":") {
+			return nil, fmt.Errorf("line %d: the record does not start with ':'", lineNumber)
+		}
+		record, err := hex.DecodeString(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err.Error())
+		}
+		if len(record) < 5 || len(record) != int(record[0])+5 {
+			return nil, fmt.Errorf("line %d: invalid record length", lineNumber)
+		}
+		if checksum(record[:len(record)-1]) != record[len(record)-1] {
+			return nil, fmt.Errorf("line %d: checksum mismatch", lineNumber)
+		}
+		offset := uint32(record[1])<<8 | uint32(record[2])
+		data := record[4 : len(record)-1]
+		switch record[3] {
+		case recordTypeData:
+			image.Segments = append(image.Segments, Segment{Address: upperAddress + offset, Data: data})
+		case recordTypeEndOfFile:
+			endOfFile = true
+		case recordTypeExtendedSegmentAddress:
+			if len(data) != 2 {
+				return nil, fmt.Errorf("line %d: invalid extended segment address record", lineNumber)
+			}
+			upperAddress = (uint32(data[0])<<8 | uint32(data[1])) << 4
+		case recordTypeExtendedLinearAddress:
+			if len(data) != 2 {
+				return nil, fmt.Errorf("line %d: invalid extended linear address record", lineNumber)
+			}
+			upperAddress = (uint32(data[0])<<8 | uint32(data[1])) << 16
+		case recordTypeStartSegmentAddress, recordTypeStartLinearAddress:
+		default:
+			return nil, fmt.Errorf("line %d: unknown record type %02X", lineNumber, record[3])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !endOfFile {
+		return nil, fmt.Errorf("the end of file record is missing")
+	}
+	image.normalize()
+	if image.Size() > MaxImageSize {
+		return nil, fmt.Errorf("the image spans %d bytes, which exceeds the limit of %d bytes", image.Size(), MaxImageSize)
+	}
+	return image, nil
+}
+
+// FormatIntelHex formats the image as the Intel HEX file with 16 bytes per data record.
+// The extended linear address record is emitted whenever the upper 16 bits of the address change.
+func FormatIntelHex(image *Image) []byte {
+	var buffer bytes.Buffer
+	upperAddress := uint32(0)
+	for _, segment := range image.Segments {
+		for offset := 0; offset < len(segment.Data); {
+			address := segment.Address + uint32(offset)
+			if address&0xffff0000 != upperAddress {
+				upperAddress = address & 0xffff0000
+				writeRecord(&buffer, 0, recordTypeExtendedLinearAddress, []byte{byte(upperAddress >> 24), byte(upperAddress >> 16)})
+			}
+			size := hexRecordDataSize
+			if remaining := len(segment.Data) - offset; remaining < size {
+				size = remaining
+			}
+			// A data record must not cross the 64KiB boundary.
+			if toBoundary := 0x10000 - int(address&0xffff); toBoundary < size {
+				size = toBoundary
+			}
+			writeRecord(&buffer, uint16(address), recordTypeData, segment.Data[offset:offset+size])
+			offset += size
+		}
+	}
+	writeRecord(&buffer, 0, recordTypeEndOfFile, nil)
+	return buffer.Bytes()
+}
+
+func writeRecord(buffer *bytes.Buffer, address uint16, recordType byte, data []byte) {
+	record := append([]byte{byte(len(data)), byte(address >> 8), byte(address), recordType}, data...)
+	record = append(record, checksum(record))
+	buffer.WriteString(":" + strings.ToUpper(hex.EncodeToString(record)) + "\n")
+}
+
+// checksum returns the two's complement of the sum of the bytes.
+func checksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
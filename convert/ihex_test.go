@@ -0,0 +1,99 @@
+package convert
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func readTestData(t *testing.T, name string) []byte {
+	t.Helper()
+	content, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func Test_ParseIntelHex_Reference(t *testing.T) {
+	image, err := ParseIntelHex(readTestData(t, "reference.hex"))
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(image.Segments) != 2 {
+		t.Fatal("Expected 2 but got", len(image.Segments))
+	}
+	if image.BaseAddress() != 0x0800fff0 {
+		t.Errorf("Expected 0x0800fff0 but got 0x%08x", image.BaseAddress())
+	}
+	if len(image.Segments[0].Data) != 40 {
+		t.Error("Expected 40 but got", len(image.Segments[0].Data))
+	}
+	if image.Segments[1].Address != 0x08010100 {
+		t.Errorf("Expected 0x08010100 but got 0x%08x", image.Segments[1].Address)
+	}
+	expected := readTestData(t, "reference.bin")
+	if actual := image.Binary(); !bytes.Equal(actual, expected) {
+		t.Error("Expected", expected, "but got", actual)
+	}
+}
+
+func Test_ParseIntelHex_CrLfAndSegmentAddress(t *testing.T) {
+	content := ":020000021000EC\r\n:0400000001020304F2\r\n:00000001FF\r\n"
+	image, err := ParseIntelHex([]byte(content))
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if image.BaseAddress() != 0x10000 {
+		t.Errorf("Expected 0x10000 but got 0x%x", image.BaseAddress())
+	}
+	if !bytes.Equal(image.Binary(), []byte{1, 2, 3, 4}) {
+		t.Error("Expected [1 2 3 4] but got", image.Binary())
+	}
+}
+
+func Test_ParseIntelHex_Invalid(t *testing.T) {
+	cases := []string{
+		"",
+		":0400000001020304F2\n",
+		":0400000001020304F3\n:00000001FF\n",
+		"0400000001020304F2\n:00000001FF\n",
+		":0500000001020304F2\n:00000001FF\n",
+		":00000001FF\n:0400000001020304F2\n",
+		":0400000601020304EC\n:00000001FF\n",
+		":0400000001020304F2\n:020000041000EA\n:0400000001020304F2\n:00000001FF\n",
+	}
+	for _, c := range cases {
+		_, err := ParseIntelHex([]byte(c))
+		if err == nil {
+			t.Error("Expected error but got nil for", strings.TrimSpace(c))
+		}
+	}
+}
+
+func Test_FormatIntelHex_Reference(t *testing.T) {
+	expected := readTestData(t, "reference.hex")
+	image, err := ParseIntelHex(expected)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	actual := FormatIntelHex(image)
+	if !bytes.Equal(actual, expected) {
+		t.Error("Expected", string(expected), "but got", string(actual))
+	}
+}
+
+func Test_FormatIntelHex_FromBinary(t *testing.T) {
+	image := NewImage(0x0800fff0, readTestData(t, "reference.bin"))
+	parsed, err := ParseIntelHex(FormatIntelHex(image))
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if parsed.BaseAddress() != 0x0800fff0 {
+		t.Errorf("Expected 0x0800fff0 but got 0x%08x", parsed.BaseAddress())
+	}
+	if !bytes.Equal(parsed.Binary(), image.Binary()) {
+		t.Error("Expected", image.Binary(), "but got", parsed.Binary())
+	}
+}
@@ -0,0 +1,84 @@
+package convert
+
+import (
+	"sort"
+)
+
+// MaxImageSize is the maximum span of the image from the lowest address to the highest address.
+// It prevents the sparse image from allocating the huge binary.
+const MaxImageSize int = 16 * 1024 * 1024
+
+// Image represents the contents of the flash memory described by a firmware file.
+type Image struct {
+	// Segments is the continuous data sorted by the address. The segments do not overlap.
+	Segments []Segment
+}
+
+// Segment represents the continuous data starting at the address.
+type Segment struct {
+	Address uint32
+	Data    []byte
+}
+
+// NewImage creates the image of the raw binary placed at the base address.
+func NewImage(baseAddress uint32, data []byte) *Image {
+	return &Image{Segments: []Segment{{Address: baseAddress, Data: data}}}
+}
+
+// BaseAddress returns the lowest address of the image.
+func (i *Image) BaseAddress() uint32 {
+	if len(i.Segments) == 0 {
+		return 0
+	}
+	return i.Segments[0].Address
+}
+
+// Size returns the size from the lowest address to the highest address of the image.
+func (i *Image) Size() int {
+	if len(i.Segments) == 0 {
+		return 0
+	}
+	last := i.Segments[len(i.Segments)-1]
+	return int(last.Address-i.BaseAddress()) + len(last.Data)
+}
+
+// Binary returns the raw binary from the base address. The gaps between the segments are filled with 0xFF,
+// which is the value of the erased flash memory.
+func (i *Image) Binary() []byte {
+	result := make([]byte, i.Size())
+	for j := range result {
+		result[j] = 0xff
+	}
+	for _, segment := range i.Segments {
+		copy(result[segment.Address-i.BaseAddress():], segment.Data)
+	}
+	return result
+}
+
+// normalize sorts the segments and merges the adjacent segments.
+// The data of the later segment wins when the segments overlap.
+func (i *Image) normalize() {
+	sort.SliceStable(i.Segments, func(a, b int) bool {
+		return i.Segments[a].Address < i.Segments[b].Address
+	})
+	var merged []Segment
+	for _, segment := range i.Segments {
+		if len(segment.Data) == 0 {
+			continue
+		}
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			end := last.Address + uint32(len(last.Data))
+			if segment.Address <= end {
+				offset := segment.Address - last.Address
+				if overflow := int(offset) + len(segment.Data) - len(last.Data); overflow > 0 {
+					last.Data = append(last.Data, make([]byte, overflow)...)
+				}
+				copy(last.Data[offset:], segment.Data)
+				continue
+			}
+		}
+		merged = append(merged, Segment{Address: segment.Address, Data: append([]byte{}, segment.Data...)})
+	}
+	i.Segments = merged
+}
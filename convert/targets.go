@@ -0,0 +1,99 @@
+package convert
+
+import (
+	"strings"
+)
+
+// Target represents how the firmware of the MCU is placed in the flash memory.
+type Target struct {
+	// FamilyId is the UF2 family ID of the MCU.
+	FamilyId uint32
+	// BaseAddress is the address where the application is placed with the bootloader.
+	// It is used to place the raw binary, which does not have any address.
+	BaseAddress uint32
+	// Uf2 reports whether the bootloader accepts the UF2 file.
+	Uf2 bool
+}
+
+const (
+	FamilyIdRp2040  uint32 = 0xe48bff56
+	FamilyIdStm32F0 uint32 = 0x647824b6
+	FamilyIdStm32F1 uint32 = 0x5ee21072
+	FamilyIdStm32F2 uint32 = 0x5d1a0a2e
+	FamilyIdStm32F3 uint32 = 0x6b846188
+	FamilyIdStm32F4 uint32 = 0x57755a57
+	FamilyIdStm32F7 uint32 = 0x53b80f00
+	FamilyIdStm32G0 uint32 = 0x300f5633
+	FamilyIdStm32G4 uint32 = 0x4c71240a
+	FamilyIdStm32H7 uint32 = 0x6db66082
+	FamilyIdStm32L0 uint32 = 0x202e3a91
+	FamilyIdStm32L1 uint32 = 0x1e1f432d
+	FamilyIdStm32L4 uint32 = 0x00ff6919
+	FamilyIdStm32L5 uint32 = 0x04240bdf
+	FamilyIdStm32Wb uint32 = 0x70d16653
+	FamilyIdStm32Wl uint32 = 0x21460ff0
+)
+
+const (
+	rp2040FlashAddress uint32 = 0x10000000
+	stm32FlashAddress  uint32 = 0x08000000
+)
+
+// stm32FamilyIds is the UF2 family IDs keyed by the prefix of the STM32 MCU name.
+var stm32FamilyIds = map[string]uint32{
+	"STM32F0": FamilyIdStm32F0,
+	"STM32F1": FamilyIdStm32F1,
+	"STM32F2": FamilyIdStm32F2,
+	"STM32F3": FamilyIdStm32F3,
+	"STM32F4": FamilyIdStm32F4,
+	"STM32F7": FamilyIdStm32F7,
+	"STM32G0": FamilyIdStm32G0,
+	"STM32G4": FamilyIdStm32G4,
+	"STM32H7": FamilyIdStm32H7,
+	"STM32L0": FamilyIdStm32L0,
+	"STM32L1": FamilyIdStm32L1,
+	"STM32L4": FamilyIdStm32L4,
+	"STM32L5": FamilyIdStm32L5,
+	"STM32WB": FamilyIdStm32Wb,
+	"STM32WL": FamilyIdStm32Wl,
+}
+
+// uf2Bootloaders is the bootloaders which accept the UF2 file. The other bootloaders of the same MCU,
+// like "stm32-dfu", accept only the HEX file or the BIN file.
+var uf2Bootloaders = map[string]bool{
+	"rp2040":  true,
+	"tinyuf2": true,
+	"uf2boot": true,
+}
+
+// stm32ApplicationOffsets is the offset of the application from the beginning of the flash memory,
+// keyed by the bootloader which occupies the beginning of the flash memory.
+var stm32ApplicationOffsets = map[string]uint32{
+	"tinyuf2":    0x10000,
+	"stm32duino": 0x2000,
+	"uf2boot":    0x2000,
+}
+
+// LookupTarget returns the target of the MCU and the bootloader specified in the keyboard,
+// like "RP2040" and "rp2040", or "STM32F411" and "tinyuf2".
+// It returns false if the MCU does not support UF2, like the AVR MCUs.
+// The Uf2 field of the target is true only if the bootloader accepts the UF2 file.
+func LookupTarget(processor string, bootloader string) (Target, bool) {
+	upper := strings.ToUpper(processor)
+	lower := strings.ToLower(bootloader)
+	if upper == "RP2040" {
+		return Target{FamilyId: FamilyIdRp2040, BaseAddress: rp2040FlashAddress, Uf2: uf2Bootloaders[lower]}, true
+	}
+	if len(upper) < 7 {
+		return Target{}, false
+	}
+	familyId, ok := stm32FamilyIds[upper[:7]]
+	if !ok {
+		return Target{}, false
+	}
+	return Target{
+		FamilyId:    familyId,
+		BaseAddress: stm32FlashAddress + stm32ApplicationOffsets[lower],
+		Uf2:         uf2Bootloaders[lower],
+	}, true
+}
@@ -0,0 +1,89 @@
+package convert
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	uf2MagicStart0         uint32 = 0x0a324655
+	uf2MagicStart1         uint32 = 0x9e5d5157
+	uf2MagicEnd            uint32 = 0x0ab16f30
+	uf2FlagFamilyIdPresent uint32 = 0x00002000
+	uf2BlockSize                  = 512
+	uf2PayloadSize                = 256
+	uf2MaxPayloadSize             = 476
+	uf2DataOffset                 = 32
+	uf2MagicEndOffset             = uf2BlockSize - 4
+)
+
+// FormatUf2 formats the image as the UF2 file for the family ID.
+// The image is divided into the 256 bytes blocks aligned to 256 bytes, and the bytes which the image
+// does not cover in each block are filled with zero, in the same way as uf2conv.py.
+func FormatUf2(image *Image, familyId uint32) []byte {
+	var addresses []uint32
+	pages := map[uint32][]byte{}
+	for _, segment := range image.Segments {
+		for i, b := range segment.Data {
+			address := segment.Address + uint32(i)
+			pageAddress := address &^ (uf2PayloadSize - 1)
+			page, ok := pages[pageAddress]
+			if !ok {
+				page = make([]byte, uf2PayloadSize)
+				pages[pageAddress] = page
+				addresses = append(addresses, pageAddress)
+			}
+			page[address-pageAddress] = b
+		}
+	}
+	result := make([]byte, 0, len(addresses)*uf2BlockSize)
+	for blockNumber, address := range addresses {
+		block := make([]byte, uf2BlockSize)
+		binary.LittleEndian.PutUint32(block[0:], uf2MagicStart0)
+		binary.LittleEndian.PutUint32(block[4:], uf2MagicStart1)
+		binary.LittleEndian.PutUint32(block[8:], uf2FlagFamilyIdPresent)
+		binary.LittleEndian.PutUint32(block[12:], address)
+		binary.LittleEndian.PutUint32(block[16:], uf2PayloadSize)
+		binary.LittleEndian.PutUint32(block[20:], uint32(blockNumber))
+		binary.LittleEndian.PutUint32(block[24:], uint32(len(addresses)))
+		binary.LittleEndian.PutUint32(block[28:], familyId)
+		copy(block[uf2DataOffset:], pages[address])
+		binary.LittleEndian.PutUint32(block[uf2MagicEndOffset:], uf2MagicEnd)
+		result = append(result, block...)
+	}
+	return result
+}
+
+// ValidateUf2 checks the magic numbers, the payload sizes, the block numbers and the block counts of the UF2 file.
+// If the family ID is not zero, the family ID of every block must match it.
+func ValidateUf2(content []byte, familyId uint32) error {
+	if len(content) == 0 {
+		return fmt.Errorf("the UF2 file is empty")
+	}
+	if len(content)%uf2BlockSize != 0 {
+		return fmt.Errorf("the size of the UF2 file is not a multiple of %d bytes: %d", uf2BlockSize, len(content))
+	}
+	numBlocks := uint32(len(content) / uf2BlockSize)
+	for i := uint32(0); i < numBlocks; i++ {
+		block := content[i*uf2BlockSize : (i+1)*uf2BlockSize]
+		if binary.LittleEndian.Uint32(block[0:]) != uf2MagicStart0 ||
+			binary.LittleEndian.Uint32(block[4:]) != uf2MagicStart1 ||
+			binary.LittleEndian.Uint32(block[uf2MagicEndOffset:]) != uf2MagicEnd {
+			return fmt.Errorf("block %d: invalid magic number", i)
+		}
+		if payloadSize := binary.LittleEndian.Uint32(block[16:]); payloadSize == 0 || payloadSize > uf2MaxPayloadSize {
+			return fmt.Errorf("block %d: invalid payload size: %d", i, payloadSize)
+		}
+		if blockNumber := binary.LittleEndian.Uint32(block[20:]); blockNumber != i {
+			return fmt.Errorf("block %d: unexpected block number: %d", i, blockNumber)
+		}
+		if count := binary.LittleEndian.Uint32(block[24:]); count != numBlocks {
+			return fmt.Errorf("block %d: the block count %d does not match the number of the blocks %d", i, count, numBlocks)
+		}
+		flags := binary.LittleEndian.Uint32(block[8:])
+		if familyId != 0 && (flags&uf2FlagFamilyIdPresent == 0 || binary.LittleEndian.Uint32(block[28:]) != familyId) {
+			return fmt.Errorf("block %d: the family ID does not match 0x%08x", i, familyId)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func Test_FormatUf2_Reference(t *testing.T) {
+	image, err := ParseIntelHex(readTestData(t, "reference.hex"))
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	expected := readTestData(t, "reference_stm32f4.uf2")
+	actual := FormatUf2(image, FamilyIdStm32F4)
+	if !bytes.Equal(actual, expected) {
+		t.Error("Expected the reference UF2 file but got", len(actual), "bytes")
+	}
+}
+
+func Test_FormatUf2_FromBinary(t *testing.T) {
+	data := make([]byte, 300)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	actual := FormatUf2(NewImage(0x10000000, data), FamilyIdRp2040)
+	if len(actual) != 2*512 {
+		t.Fatal("Expected 1024 but got", len(actual))
+	}
+	second := actual[512:]
+	if address := binary.LittleEndian.Uint32(second[12:]); address != 0x10000100 {
+		t.Errorf("Expected 0x10000100 but got 0x%08x", address)
+	}
+	if blockNumber := binary.LittleEndian.Uint32(second[20:]); blockNumber != 1 {
+		t.Error("Expected 1 but got", blockNumber)
+	}
+	if numBlocks := binary.LittleEndian.Uint32(second[24:]); numBlocks != 2 {
+		t.Error("Expected 2 but got", numBlocks)
+	}
+	if familyId := binary.LittleEndian.Uint32(second[28:]); familyId != FamilyIdRp2040 {
+		t.Errorf("Expected 0x%08x but got 0x%08x", FamilyIdRp2040, familyId)
+	}
+	if !bytes.Equal(second[32:32+44], data[256:]) {
+		t.Error("Expected the rest of the data but got", second[32:32+44])
+	}
+}
+
+func Test_LookupTarget(t *testing.T) {
+	cases := []struct {
+		processor   string
+		bootloader  string
+		ok          bool
+		familyId    uint32
+		baseAddress uint32
+		uf2         bool
+	}{
+		{"RP2040", "rp2040", true, FamilyIdRp2040, 0x10000000, true},
+		{"STM32F411", "tinyuf2", true, FamilyIdStm32F4, 0x08010000, true},
+		{"STM32F411", "uf2boot", true, FamilyIdStm32F4, 0x08002000, true},
+		{"STM32F411", "stm32-dfu", true, FamilyIdStm32F4, 0x08000000, false},
+		{"STM32F103", "stm32duino", true, FamilyIdStm32F1, 0x08002000, false},
+		{"STM32G431", "stm32-dfu", true, FamilyIdStm32G4, 0x08000000, false},
+		{"atmega32u4", "caterina", false, 0, 0, false},
+		{"", "", false, 0, 0, false},
+	}
+	for _, c := range cases {
+		actual, ok := LookupTarget(c.processor, c.bootloader)
+		if ok != c.ok {
+			t.Error("Expected", c.ok, "but got", ok, "for", c.processor)
+			continue
+		}
+		if actual.FamilyId != c.familyId || actual.BaseAddress != c.baseAddress {
+			t.Errorf("Expected 0x%08x/0x%08x but got 0x%08x/0x%08x for %s",
+				c.familyId, c.baseAddress, actual.FamilyId, actual.BaseAddress, c.processor)
+		}
+		if actual.Uf2 != c.uf2 {
+			t.Error("Expected", c.uf2, "but got", actual.Uf2, "for", c.processor, c.bootloader)
+		}
+	}
+}
+
+func Test_ValidateUf2_Reference(t *testing.T) {
+	content := readTestData(t, "reference_stm32f4.uf2")
+	err := ValidateUf2(content, FamilyIdStm32F4)
+	if err != nil {
+		t.Error("Expected nil but got", err)
+	}
+	err = ValidateUf2(content, 0)
+	if err != nil {
+		t.Error("Expected nil but got", err)
+	}
+}
+
+func Test_ValidateUf2_Invalid(t *testing.T) {
+	reference := readTestData(t, "reference_stm32f4.uf2")
+	corrupt := func(offset int, value uint32) []byte {
+		content := append([]byte{}, reference...)
+		binary.LittleEndian.PutUint32(content[offset:], value)
+		return content
+	}
+	cases := map[string][]byte{
+		"empty":              {},
+		"truncated":          reference[:len(reference)-100],
+		"missing block":      reference[:len(reference)-512],
+		"magic start":        corrupt(512, 0),
+		"magic end":          corrupt(512+508, 0),
+		"payload size":       corrupt(16, 512),
+		"block number":       corrupt(512+20, 5),
+		"block count":        corrupt(24, 4),
+		"family ID mismatch": corrupt(28, FamilyIdRp2040),
+	}
+	for name, content := range cases {
+		err := ValidateUf2(content, FamilyIdStm32F4)
+		if err == nil {
+			t.Error("Expected error but got nil for", name)
+		}
+	}
+}
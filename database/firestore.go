@@ -101,6 +101,124 @@ func UpdateTask(ctx context.Context, client *firestore.Client, taskId string, st
 	return err
 }
 
+// UpdateTaskArtifacts updates the firmware files built for each variant of the task.
+func UpdateTaskArtifacts(ctx context.Context, client *firestore.Client, taskId string, artifacts []common.TaskArtifact) error {
+	_, err := client.Collection("build").Doc("v1").Collection("tasks").Doc(taskId).Set(ctx, map[string]interface{}{
+		"artifacts": artifacts,
+		"updatedAt": time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// UpdateTaskLintMessages updates the warnings and errors reported by the lint stage of the task.
+func UpdateTaskLintMessages(ctx context.Context, client *firestore.Client, taskId string, lintMessages []common.LintMessage) error {
+	_, err := client.Collection("build").Doc("v1").Collection("tasks").Doc(taskId).Set(ctx, map[string]interface{}{
+		"lintMessages": lintMessages,
+		"updatedAt":    time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// UpdateTaskScanViolations updates the violations found by the security scan of the task.
+func UpdateTaskScanViolations(ctx context.Context, client *firestore.Client, taskId string, scanViolations []common.ScanViolation) error {
+	_, err := client.Collection("build").Doc("v1").Collection("tasks").Doc(taskId).Set(ctx, map[string]interface{}{
+		"scanViolations": scanViolations,
+		"updatedAt":      time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// UpdateTaskLimitExceeded updates the limit of the sandbox hit during the build of the task.
+func UpdateTaskLimitExceeded(ctx context.Context, client *firestore.Client, taskId string, limitExceeded string) error {
+	_, err := client.Collection("build").Doc("v1").Collection("tasks").Doc(taskId).Set(ctx, map[string]interface{}{
+		"limitExceeded": limitExceeded,
+		"updatedAt":     time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// UpdateTaskQmkFirmwareVersion updates the QMK Firmware version resolved for the task and the warnings about the version.
+func UpdateTaskQmkFirmwareVersion(ctx context.Context, client *firestore.Client, taskId string, qmkFirmwareVersion string, warnings []string) error {
+	_, err := client.Collection("build").Doc("v1").Collection("tasks").Doc(taskId).Set(ctx, map[string]interface{}{
+		"qmkFirmwareVersion": qmkFirmwareVersion,
+		"warnings":           warnings,
+		"updatedAt":          time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// UpdateTaskCompilerCacheStats updates the hits and the misses of the compiler cache during the build of the task.
+func UpdateTaskCompilerCacheStats(ctx context.Context, client *firestore.Client, taskId string, compilerCacheStats *common.CompilerCacheStats) error {
+	_, err := client.Collection("build").Doc("v1").Collection("tasks").Doc(taskId).Set(ctx, map[string]interface{}{
+		"compilerCacheStats": compilerCacheStats,
+		"updatedAt":          time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// UpdateTaskManifestFilePath updates the path of the manifest file of the task in the Cloud Storage.
+func UpdateTaskManifestFilePath(ctx context.Context, client *firestore.Client, taskId string, manifestFilePath string) error {
+	_, err := client.Collection("build").Doc("v1").Collection("tasks").Doc(taskId).Set(ctx, map[string]interface{}{
+		"manifestFilePath": manifestFilePath,
+		"updatedAt":        time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// UpdateTaskDefinitionFilePath updates the path of the keyboard definition file generated for the task in the Cloud Storage.
+func UpdateTaskDefinitionFilePath(ctx context.Context, client *firestore.Client, taskId string, definitionFilePath string) error {
+	_, err := client.Collection("build").Doc("v1").Collection("tasks").Doc(taskId).Set(ctx, map[string]interface{}{
+		"definitionFilePath": definitionFilePath,
+		"updatedAt":          time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// UpdateTaskRenderedFilePath updates the path of the file holding the rendered files of the dry-run task in the Cloud Storage.
+func UpdateTaskRenderedFilePath(ctx context.Context, client *firestore.Client, taskId string, renderedFilePath string) error {
+	_, err := client.Collection("build").Doc("v1").Collection("tasks").Doc(taskId).Set(ctx, map[string]interface{}{
+		"renderedFilePath": renderedFilePath,
+		"updatedAt":        time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// UpdateTaskReproducibility updates the results of the reproducibility check of the task.
+func UpdateTaskReproducibility(ctx context.Context, client *firestore.Client, taskId string, results []common.ReproducibilityResult) error {
+	_, err := client.Collection("build").Doc("v1").Collection("tasks").Doc(taskId).Set(ctx, map[string]interface{}{
+		"reproducibility": results,
+		"updatedAt":       time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// UpdateTaskCharged marks the task as charged, so the retried task does not consume the remaining build count again.
+func UpdateTaskCharged(ctx context.Context, client *firestore.Client, taskId string) error {
+	_, err := client.Collection("build").Doc("v1").Collection("tasks").Doc(taskId).Set(ctx, map[string]interface{}{
+		"charged":   true,
+		"updatedAt": time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// UpdateTaskInfrastructureError updates the failure of the build environment which made the task retried.
+func UpdateTaskInfrastructureError(ctx context.Context, client *firestore.Client, taskId string, infrastructureError string) error {
+	_, err := client.Collection("build").Doc("v1").Collection("tasks").Doc(taskId).Set(ctx, map[string]interface{}{
+		"infrastructureError": infrastructureError,
+		"updatedAt":           time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// UpdateTaskStageTimings updates the time taken by each stage of the build pipeline.
+func UpdateTaskStageTimings(ctx context.Context, client *firestore.Client, taskId string, stageTimings []common.StageTiming) error {
+	_, err := client.Collection("build").Doc("v1").Collection("tasks").Doc(taskId).Set(ctx, map[string]interface{}{
+		"stageTimings": stageTimings,
+		"updatedAt":    time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
 // FetchWorkbenchProjectInfo fetches the workbench project information from the Firestore.
 func FetchWorkbenchProjectInfo(client *firestore.Client, task *common.Task) (*common.WorkbenchProject, error) {
 	log.Println("Fetching the workbench project information from the Firestore.")
@@ -158,6 +276,48 @@ func FetchWorkbenchKeymapFiles(client *firestore.Client, projectId string) ([]*c
 	return keymapFiles, nil
 }
 
+// FetchWorkbenchUserspaceFiles fetches the userspace files from the Firestore.
+func FetchWorkbenchUserspaceFiles(client *firestore.Client, projectId string) ([]*common.WorkbenchProjectFile, error) {
+	log.Println("Fetching the workbench userspace files from the Firestore.")
+	iter := client.Collection("build").Doc("v1").Collection("projects").Doc(projectId).Collection("userspaceFiles").Documents(context.Background())
+	var userspaceFiles []*common.WorkbenchProjectFile
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var userspaceFile common.WorkbenchProjectFile
+		doc.DataTo(&userspaceFile)
+		userspaceFile.ID = doc.Ref.ID
+		userspaceFiles = append(userspaceFiles, &userspaceFile)
+	}
+	return userspaceFiles, nil
+}
+
+// FetchKeyboardDefinitionName fetches the name of the keyboard from the keyboard definition in the Firestore.
+func FetchKeyboardDefinitionName(client *firestore.Client, keyboardDefinitionId string) (string, error) {
+	log.Println("Fetching the keyboard definition name from the Firestore.")
+	definitionDoc, err := client.Collection("keyboards").Doc("v2").Collection("definitions").Doc(keyboardDefinitionId).Get(context.Background())
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return "", fmt.Errorf("keyboard definition not found")
+		}
+		return "", err
+	}
+	name, err := definitionDoc.DataAt("name")
+	if err != nil {
+		return "", err
+	}
+	nameString, ok := name.(string)
+	if !ok {
+		return "", fmt.Errorf("the name of the keyboard definition is not a string")
+	}
+	return nameString, nil
+}
+
 // FetchUserPurchase fetches the user purchase information from the Firestore.
 func FetchUserPurchase(client *firestore.Client, uid string) (*common.UserPurchase, error) {
 	log.Println("Fetching the user purchase information from the Firestore.")
@@ -1,16 +1,19 @@
 package database
 
 import (
+	"bytes"
 	"context"
 	"firebase.google.com/go/storage"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"os"
 )
 
-// UploadFirmwareFileToCloudStorage uploads the firmware file to the Cloud Storage.
-func UploadFirmwareFileToCloudStorage(ctx context.Context, storageClient *storage.Client, uid string, firmwareFileName string, localFirmwareFilePath string) (string, error) {
+// UploadFirmwareFileToCloudStorage uploads the firmware file to the Cloud Storage as the object name.
+// The download file name is set to the Content-Disposition so that the user saves the file with the readable name.
+func UploadFirmwareFileToCloudStorage(ctx context.Context, storageClient *storage.Client, uid string, objectName string, downloadFileName string, localFirmwareFilePath string) (string, error) {
 	log.Println("Uploading the firmware file to the Cloud Storage.")
 
 	file, err := os.Open(localFirmwareFilePath)
@@ -19,18 +22,40 @@ func UploadFirmwareFileToCloudStorage(ctx context.Context, storageClient *storag
 	}
 	defer file.Close()
 
-	bucketName := "remap-b2d08.appspot.com"
-	remoteFirmwareFilePath := fmt.Sprintf("firmware/%s/built/%s", uid, firmwareFileName)
-	bucket, err := storageClient.Bucket(bucketName)
+	remoteFirmwareFilePath := fmt.Sprintf("firmware/%s/built/%s", uid, objectName)
+	contentDisposition := mime.FormatMediaType("attachment", map[string]string{"filename": downloadFileName})
+	err = uploadToCloudStorage(ctx, storageClient, remoteFirmwareFilePath, file, "", contentDisposition)
 	if err != nil {
 		return "", err
 	}
-	writer := bucket.Object(remoteFirmwareFilePath).NewWriter(ctx)
-	if _, err := io.Copy(writer, file); err != nil {
+	return remoteFirmwareFilePath, nil
+}
+
+// UploadJsonFileToCloudStorage uploads the JSON file, like the manifest, next to the firmware files to the Cloud Storage.
+func UploadJsonFileToCloudStorage(ctx context.Context, storageClient *storage.Client, uid string, jsonFileName string, content []byte) (string, error) {
+	log.Printf("Uploading the JSON file [%s] to the Cloud Storage.\n", jsonFileName)
+
+	remoteJsonFilePath := fmt.Sprintf("firmware/%s/built/%s", uid, jsonFileName)
+	err := uploadToCloudStorage(ctx, storageClient, remoteJsonFilePath, bytes.NewReader(content), "application/json", "")
+	if err != nil {
 		return "", err
 	}
-	if err := writer.Close(); err != nil {
-		return "", err
+	return remoteJsonFilePath, nil
+}
+
+// uploadToCloudStorage uploads the content to the path of the Cloud Storage.
+// The empty content type lets the Cloud Storage detect it, and the empty content disposition is not set.
+func uploadToCloudStorage(ctx context.Context, storageClient *storage.Client, remoteFilePath string, content io.Reader, contentType string, contentDisposition string) error {
+	bucketName := "remap-b2d08.appspot.com"
+	bucket, err := storageClient.Bucket(bucketName)
+	if err != nil {
+		return err
 	}
-	return remoteFirmwareFilePath, nil
+	writer := bucket.Object(remoteFilePath).NewWriter(ctx)
+	writer.ContentType = contentType
+	writer.ContentDisposition = contentDisposition
+	if _, err := io.Copy(writer, content); err != nil {
+		return err
+	}
+	return writer.Close()
 }
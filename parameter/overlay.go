@@ -0,0 +1,87 @@
+package parameter
+
+import (
+	"fmt"
+	"log"
+	"path"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+// HasOverlayFiles returns true if the user adds or overrides any file with the overlay files.
+func HasOverlayFiles(parametersJson *common.ParametersJson) bool {
+	overlays := parametersJson.Overlays
+	return overlays != nil && (len(overlays.Keyboard) > 0 || len(overlays.Keymap) > 0)
+}
+
+// ApplyOverlayFiles merges the overlay files of the user on top of the files of the owner by the rule of the owner.
+// The conflicts are decided in the following order:
+//  1. The path matching any locked pattern cannot be added nor overridden.
+//  2. The path of the file of the owner can be overridden only if it matches any overridable pattern.
+//  3. The other paths are added as the new files.
+//
+// The patterns are in the syntax of path.Match. The nil rule means that the owner does not accept the overlay files.
+// The category, like "keyboard", is used in the error messages.
+func ApplyOverlayFiles(category string, files []*common.FirmwareFile, overlayFiles []*common.OverlayFile, rule *common.OverlayRule) ([]*common.FirmwareFile, error) {
+	if len(overlayFiles) == 0 {
+		return files, nil
+	}
+	if rule == nil {
+		return nil, fmt.Errorf("the firmware does not accept the overlay files of the %s", category)
+	}
+	indexes := map[string]int{}
+	for i, file := range files {
+		indexes[file.Path] = i
+	}
+	applied := map[string]bool{}
+	for _, overlayFile := range overlayFiles {
+		if applied[overlayFile.Path] {
+			return nil, fmt.Errorf("the overlay file of the %s is duplicated: %s", category, overlayFile.Path)
+		}
+		applied[overlayFile.Path] = true
+		locked, err := matchAnyPattern(rule.Locked, overlayFile.Path)
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			return nil, fmt.Errorf("the file of the %s is locked by the owner: %s", category, overlayFile.Path)
+		}
+		file := &common.FirmwareFile{
+			Path:     overlayFile.Path,
+			Content:  overlayFile.Content,
+			Encoding: overlayFile.Encoding,
+		}
+		index, exists := indexes[overlayFile.Path]
+		if !exists {
+			log.Printf("[INFO] Adding the overlay file of the %s: %s\n", category, overlayFile.Path)
+			indexes[overlayFile.Path] = len(files)
+			files = append(files, file)
+			continue
+		}
+		overridable, err := matchAnyPattern(rule.Overridable, overlayFile.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !overridable {
+			return nil, fmt.Errorf("the file of the %s cannot be overridden: %s", category, overlayFile.Path)
+		}
+		log.Printf("[INFO] Overriding the file of the %s with the overlay file: %s\n", category, overlayFile.Path)
+		// Keep the ID, so the file is still identified as the file of the owner.
+		file.ID = files[index].ID
+		files[index] = file
+	}
+	return files, nil
+}
+
+func matchAnyPattern(patterns []string, filePath string) (bool, error) {
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, filePath)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern of the overlay policy: %s", pattern)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
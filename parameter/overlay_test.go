@@ -0,0 +1,126 @@
+package parameter
+
+import (
+	"testing"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+func createOwnerFiles() []*common.FirmwareFile {
+	return []*common.FirmwareFile{
+		{ID: "file1", Path: "config.h", Content: "#pragma once"},
+		{ID: "file2", Path: "rules.mk", Content: "VIA_ENABLE = yes"},
+		{ID: "file3", Path: "lib/logo.c", Content: "// logo"},
+	}
+}
+
+func createOverlayRule() *common.OverlayRule {
+	return &common.OverlayRule{
+		Overridable: []string{"config.h", "lib/*"},
+		Locked:      []string{"rules.mk", "*.mk"},
+	}
+}
+
+func Test_HasOverlayFiles(t *testing.T) {
+	if HasOverlayFiles(&common.ParametersJson{}) {
+		t.Error("Expected false but got true for no overlays")
+	}
+	if HasOverlayFiles(&common.ParametersJson{Overlays: &common.OverlayFiles{}}) {
+		t.Error("Expected false but got true for empty overlays")
+	}
+	parametersJson := &common.ParametersJson{Overlays: &common.OverlayFiles{
+		Keymap: []*common.OverlayFile{{Path: "tap_dance.c", Content: "// tap dance"}},
+	}}
+	if !HasOverlayFiles(parametersJson) {
+		t.Error("Expected true but got false")
+	}
+}
+
+func Test_ApplyOverlayFiles_AddAndOverride(t *testing.T) {
+	actual, err := ApplyOverlayFiles("keymap", createOwnerFiles(), []*common.OverlayFile{
+		{Path: "tap_dance.c", Content: "// tap dance"},
+		{Path: "config.h", Content: "#define TAPPING_TERM 180"},
+		{Path: "lib/logo.c", Content: "AAAA", Encoding: common.FileEncodingBase64},
+	}, createOverlayRule())
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual) != 4 {
+		t.Fatal("Expected 4 files but got", len(actual))
+	}
+	if actual[0].ID != "file1" || actual[0].Content != "#define TAPPING_TERM 180" {
+		t.Error("Expected the overridden config.h but got", actual[0])
+	}
+	if actual[1].Content != "VIA_ENABLE = yes" {
+		t.Error("Expected the rules.mk of the owner but got", actual[1])
+	}
+	if actual[2].ID != "file3" || actual[2].Encoding != common.FileEncodingBase64 {
+		t.Error("Expected the overridden lib/logo.c but got", actual[2])
+	}
+	if actual[3].ID != "" || actual[3].Path != "tap_dance.c" || actual[3].Content != "// tap dance" {
+		t.Error("Expected the added tap_dance.c but got", actual[3])
+	}
+}
+
+func Test_ApplyOverlayFiles_NoOverlayFiles(t *testing.T) {
+	actual, err := ApplyOverlayFiles("keymap", createOwnerFiles(), nil, nil)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual) != 3 {
+		t.Error("Expected 3 files but got", len(actual))
+	}
+}
+
+func Test_ApplyOverlayFiles_NotAccepted(t *testing.T) {
+	_, err := ApplyOverlayFiles("keymap", createOwnerFiles(), []*common.OverlayFile{{Path: "tap_dance.c"}}, nil)
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_ApplyOverlayFiles_Locked(t *testing.T) {
+	for _, path := range []string{"rules.mk", "extra.mk"} {
+		_, err := ApplyOverlayFiles("keyboard", createOwnerFiles(), []*common.OverlayFile{{Path: path}}, createOverlayRule())
+		if err == nil {
+			t.Error("Expected error but got nil for", path)
+		}
+	}
+}
+
+func Test_ApplyOverlayFiles_NotOverridable(t *testing.T) {
+	rule := createOverlayRule()
+	rule.Overridable = []string{"lib/*"}
+	_, err := ApplyOverlayFiles("keyboard", createOwnerFiles(), []*common.OverlayFile{{Path: "config.h"}}, rule)
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_ApplyOverlayFiles_Duplicated(t *testing.T) {
+	_, err := ApplyOverlayFiles("keymap", createOwnerFiles(), []*common.OverlayFile{
+		{Path: "tap_dance.c"},
+		{Path: "tap_dance.c"},
+	}, createOverlayRule())
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_ApplyOverlayFiles_InvalidPattern(t *testing.T) {
+	rule := &common.OverlayRule{Locked: []string{"["}}
+	_, err := ApplyOverlayFiles("keymap", createOwnerFiles(), []*common.OverlayFile{{Path: "tap_dance.c"}}, rule)
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_ParseParameterJson_Overlays(t *testing.T) {
+	actual, err := ParseParameterJson(`{"version":2,"keyboard":{},"keymap":{},"overlays":{"keymap":[{"path":"tap_dance.c","content":"// tap dance"}]}}`)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if actual.Overlays == nil || len(actual.Overlays.Keymap) != 1 || actual.Overlays.Keymap[0].Path != "tap_dance.c" {
+		t.Error("Expected the overlay file but got", actual.Overlays)
+	}
+}
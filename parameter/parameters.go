@@ -32,17 +32,43 @@ func ReplaceParameters(files []*common.FirmwareFile, parameterValueMap map[strin
 			// If there is no parameter map for the firmware file, skip this file.
 			continue
 		}
-		var newContent string
 		if parameterValue.Type == "code" {
-			newContent = parameterValue.Code
-		} else {
-			newContent = ReplaceParametersInString(file.Content, parameterValue.Parameters)
+			// The code written by the user is always a text.
+			file.Content = parameterValue.Code
+			file.Encoding = common.FileEncodingUtf8
+		} else if file.Encoding != common.FileEncodingBase64 {
+			// The parameters cannot be embedded in the binary file.
+			file.Content = ReplaceParametersInString(file.Content, parameterValue.Parameters)
 		}
-		file.Content = newContent
 	}
 	return files
 }
 
+// HasCodeParameterValue returns true if any file content is replaced with the code written by the user.
+func HasCodeParameterValue(parametersJson *common.ParametersJson) bool {
+	for _, parameterValueMap := range []map[string]*common.ParameterValue{parametersJson.Keyboard, parametersJson.Keymap} {
+		for _, parameterValue := range parameterValueMap {
+			if parameterValue != nil && parameterValue.Type == "code" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasTextParameterValue returns true if any parameter in the file contents is replaced with the text entered by the user.
+// The text is not validated by the build server, so it is as untrusted as the code.
+func HasTextParameterValue(parametersJson *common.ParametersJson) bool {
+	for _, parameterValueMap := range []map[string]*common.ParameterValue{parametersJson.Keyboard, parametersJson.Keymap} {
+		for _, parameterValue := range parameterValueMap {
+			if parameterValue != nil && parameterValue.Type != "code" && len(parameterValue.Parameters) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // ParseParameterJson parses the ParameterJson string.
 func ParseParameterJson(parametersJson string) (*common.ParametersJson, error) {
 	var parseResult map[string]interface{}
@@ -1,6 +1,10 @@
 package parameter
 
-import "testing"
+import (
+	"testing"
+
+	"remap-keys.app/remap-build-server/common"
+)
 
 func Test_ReplaceParametersInString_EmptySource(t *testing.T) {
 	actual := ReplaceParametersInString("", map[string]string{})
@@ -190,3 +194,62 @@ func Test_ParseParameterJson_Version2(t *testing.T) {
 		t.Error("Expected code2 but got", actual.Keymap["file2"].Code)
 	}
 }
+
+func Test_HasCodeParameterValue_NoCode(t *testing.T) {
+	parametersJson := &common.ParametersJson{
+		Keyboard: map[string]*common.ParameterValue{"file1": {Type: "parameters"}},
+		Keymap:   map[string]*common.ParameterValue{"file2": {Type: "parameters"}},
+	}
+	if HasCodeParameterValue(parametersJson) {
+		t.Error("Expected false but got true")
+	}
+}
+
+func Test_HasCodeParameterValue_Code(t *testing.T) {
+	parametersJson := &common.ParametersJson{
+		Keyboard: map[string]*common.ParameterValue{"file1": {Type: "parameters"}},
+		Keymap:   map[string]*common.ParameterValue{"file2": {Type: "code", Code: "code2"}},
+	}
+	if !HasCodeParameterValue(parametersJson) {
+		t.Error("Expected true but got false")
+	}
+}
+
+func Test_HasTextParameterValue_NoParameters(t *testing.T) {
+	parametersJson := &common.ParametersJson{
+		Keyboard: map[string]*common.ParameterValue{"file1": {Type: "parameters", Parameters: map[string]string{}}},
+		Keymap:   map[string]*common.ParameterValue{"file2": {Type: "code", Code: "code2"}},
+	}
+	if HasTextParameterValue(parametersJson) {
+		t.Error("Expected false but got true")
+	}
+}
+
+func Test_HasTextParameterValue_Parameters(t *testing.T) {
+	parametersJson := &common.ParametersJson{
+		Keyboard: map[string]*common.ParameterValue{"file1": {Type: "parameters", Parameters: map[string]string{"foo": "bar"}}},
+	}
+	if !HasTextParameterValue(parametersJson) {
+		t.Error("Expected true but got false")
+	}
+}
+
+func Test_ReplaceParameters_Base64(t *testing.T) {
+	files := []*common.FirmwareFile{
+		{ID: "file1", Content: "PHJlbWFwIG5hbWU9ImZvbyIgLz4=", Encoding: common.FileEncodingBase64},
+		{ID: "file2", Content: "AAEC", Encoding: common.FileEncodingBase64},
+	}
+	actual := ReplaceParameters(files, map[string]*common.ParameterValue{
+		"file1": {Type: "parameters", Parameters: map[string]string{"foo": "bar"}},
+		"file2": {Type: "code", Code: "code2"},
+	})
+	if actual[0].Content != "PHJlbWFwIG5hbWU9ImZvbyIgLz4=" {
+		t.Error("Expected the binary content to be kept but got", actual[0].Content)
+	}
+	if actual[1].Content != "code2" {
+		t.Error("Expected code2 but got", actual[1].Content)
+	}
+	if actual[1].Encoding != common.FileEncodingUtf8 {
+		t.Error("Expected utf8 but got", actual[1].Encoding)
+	}
+}
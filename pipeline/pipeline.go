@@ -0,0 +1,185 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/storage"
+	"remap-keys.app/remap-build-server/build"
+	"remap-keys.app/remap-build-server/common"
+	"remap-keys.app/remap-build-server/database"
+	"remap-keys.app/remap-build-server/versions"
+	"remap-keys.app/remap-build-server/web"
+)
+
+// The names of the stages. They are recorded with the time taken by each stage on the task.
+const (
+	StageAuthorize   = "authorize"
+	StageLoadSources = "loadSources"
+	StageCharge      = "charge"
+	StageRender      = "render"
+	StagePrepareTree = "prepareTree"
+	StageLint        = "lint"
+	StageCompile     = "compile"
+	StageCollect     = "collect"
+	StagePublish     = "publish"
+	StageFinalize    = "finalize"
+)
+
+// Pipeline builds the firmware of a task through the stages. The stages common to all source types
+// are implemented once, and the differences of each source type are implemented by its Strategy.
+type Pipeline struct {
+	firestoreClient *firestore.Client
+	storageClient   *storage.Client
+	versionRegistry *versions.Registry
+}
+
+// New creates the pipeline using the clients and the registry of the installed QMK Firmware versions.
+func New(firestoreClient *firestore.Client, storageClient *storage.Client, versionRegistry *versions.Registry) *Pipeline {
+	return &Pipeline{
+		firestoreClient: firestoreClient,
+		storageClient:   storageClient,
+		versionRegistry: versionRegistry,
+	}
+}
+
+// Stage is a step of the pipeline. The stage returns a Failure to report the outputs of the build to the user,
+// and a build.InfrastructureError to make Cloud Tasks retry the task. Any other error fails the task.
+type Stage struct {
+	Name string
+	Run  func(ctx context.Context, p *Pipeline, state *State) error
+}
+
+// Settings is the settings of the source shared by all source types.
+type Settings struct {
+	QmkFirmwareVersion   string
+	SourceRepository     string
+	SourceRef            string
+	KeymapName           string
+	EnvironmentVariables map[string]string
+	Defines              []string
+	// KeyboardDirectoryName is the name of the keyboard directory. The empty string means that it is generated.
+	KeyboardDirectoryName string
+	// KeyboardName is the name of the keyboard shown to the user, like in the name of the downloaded firmware file.
+	KeyboardName string
+}
+
+// State is passed through the stages of a task.
+type State struct {
+	Request  *http.Request
+	Task     *common.Task
+	Params   *common.RequestParameters
+	Strategy Strategy
+	// Settings is loaded by the load sources stage. The QMK Firmware version is resolved to the installed version.
+	Settings *Settings
+	Variants []common.BuildVariant
+	// Files is the rendered files of each category, like "keyboard".
+	Files map[string][]common.BuildableFile
+	// Options is created by the prepare tree stage. The strategy sets the keyboard ID, the userspace and the sandbox.
+	Options  build.BuildOptions
+	Manifest *build.Manifest
+
+	buildResults []variantBuildResult
+	uploads      []artifactUpload
+	artifacts    []common.TaskArtifact
+	stdout       string
+	message      string
+	cleanups     []func()
+	timings      []common.StageTiming
+}
+
+// variantBuildResult is the result of the build of a variant with the build options used for it.
+type variantBuildResult struct {
+	variant common.BuildVariant
+	options build.BuildOptions
+	result  build.BuildResult
+}
+
+// artifactUpload is a verified firmware file with the names of it in the Cloud Storage.
+type artifactUpload struct {
+	variant          common.BuildVariant
+	artifact         build.Artifact
+	objectName       string
+	downloadFileName string
+}
+
+// addCleanup registers the function run after the response is sent. The functions run in the reverse order.
+func (s *State) addCleanup(cleanup func()) {
+	s.cleanups = append(s.cleanups, cleanup)
+}
+
+func (s *State) cleanup() {
+	for i := len(s.cleanups) - 1; i >= 0; i-- {
+		s.cleanups[i]()
+	}
+}
+
+// recordTiming records the time taken by the stage.
+func (s *State) recordTiming(name string, duration time.Duration) {
+	log.Printf("[INFO] The stage [%s] took %d ms\n", name, duration.Milliseconds())
+	s.timings = append(s.timings, common.StageTiming{Name: name, DurationMillis: duration.Milliseconds()})
+}
+
+// stagesOf returns the stages of the task. The dry run only lints and publishes the rendered files instead of compiling,
+// and the reproducibility check compiles each variant twice instead of publishing the firmware files.
+func stagesOf(task *common.Task, params *common.RequestParameters) []Stage {
+	stages := []Stage{
+		{Name: StageAuthorize, Run: authorize},
+		{Name: StageLoadSources, Run: loadSources},
+		{Name: StageCharge, Run: charge},
+		{Name: StageRender, Run: render},
+		{Name: StagePrepareTree, Run: prepareTree},
+	}
+	if task.DryRun {
+		return append(stages,
+			Stage{Name: StageLint, Run: lint},
+			Stage{Name: StagePublish, Run: publishRenderedFiles},
+			Stage{Name: StageFinalize, Run: finalize},
+		)
+	}
+	if params.Mode == web.ModeReproducibility {
+		return append(stages,
+			Stage{Name: StageCompile, Run: checkReproducibility},
+			Stage{Name: StageFinalize, Run: finalize},
+		)
+	}
+	return append(stages,
+		Stage{Name: StageCompile, Run: compile},
+		Stage{Name: StageCollect, Run: collect},
+		Stage{Name: StagePublish, Run: publish},
+		Stage{Name: StageFinalize, Run: finalize},
+	)
+}
+
+// Run runs the stages of the task in order and writes the response. The first failed stage stops the pipeline.
+// The time taken by each stage is stored on the task, and the QMK Firmware tree is restored after the response is sent.
+func (p *Pipeline) Run(ctx context.Context, w http.ResponseWriter, r *http.Request, task *common.Task, params *common.RequestParameters) {
+	state := &State{Request: r, Task: task, Params: params}
+	defer state.cleanup()
+	for _, stage := range stagesOf(task, params) {
+		log.Printf("[INFO] The stage [%s] started.\n", stage.Name)
+		start := time.Now()
+		err := stage.Run(ctx, p, state)
+		state.recordTiming(stage.Name, time.Since(start))
+		if err != nil {
+			p.storeTimings(ctx, state)
+			p.sendError(ctx, w, params, err)
+			return
+		}
+	}
+	p.storeTimings(ctx, state)
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, state.message)
+}
+
+func (p *Pipeline) storeTimings(ctx context.Context, state *State) {
+	err := database.UpdateTaskStageTimings(ctx, p.firestoreClient, state.Params.TaskId, state.timings)
+	if err != nil {
+		// Ignore the error, because the timings do not affect the result of the task.
+		log.Printf("[ERROR] %s\n", err.Error())
+	}
+}
@@ -0,0 +1,209 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"remap-keys.app/remap-build-server/common"
+	"remap-keys.app/remap-build-server/web"
+)
+
+func stageNames(stages []Stage) []string {
+	names := make([]string, len(stages))
+	for i, stage := range stages {
+		names[i] = stage.Name
+	}
+	return names
+}
+
+func assertStageNames(t *testing.T, actual []Stage, expected []string) {
+	t.Helper()
+	names := stageNames(actual)
+	if len(names) != len(expected) {
+		t.Fatal("Expected", expected, "but got", names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Error("Expected", expected, "but got", names)
+			return
+		}
+	}
+}
+
+func Test_stagesOf_Build(t *testing.T) {
+	actual := stagesOf(&common.Task{}, &common.RequestParameters{Mode: web.ModeBuild})
+	assertStageNames(t, actual, []string{
+		StageAuthorize, StageLoadSources, StageCharge, StageRender, StagePrepareTree,
+		StageCompile, StageCollect, StagePublish, StageFinalize,
+	})
+}
+
+func Test_stagesOf_DryRun(t *testing.T) {
+	actual := stagesOf(&common.Task{DryRun: true}, &common.RequestParameters{Mode: web.ModeReproducibility})
+	assertStageNames(t, actual, []string{
+		StageAuthorize, StageLoadSources, StageCharge, StageRender, StagePrepareTree,
+		StageLint, StagePublish, StageFinalize,
+	})
+}
+
+func Test_stagesOf_Reproducibility(t *testing.T) {
+	actual := stagesOf(&common.Task{}, &common.RequestParameters{Mode: web.ModeReproducibility})
+	assertStageNames(t, actual, []string{
+		StageAuthorize, StageLoadSources, StageCharge, StageRender, StagePrepareTree,
+		StageCompile, StageFinalize,
+	})
+}
+
+func Test_newStrategy(t *testing.T) {
+	strategy, err := newStrategy(&common.Task{FirmwareId: "firmware1"})
+	if _, ok := strategy.(*registeredStrategy); !ok || err != nil {
+		t.Error("Expected registeredStrategy but got", strategy, err)
+	}
+	strategy, err = newStrategy(&common.Task{ProjectId: "project1"})
+	if _, ok := strategy.(*workbenchStrategy); !ok || err != nil {
+		t.Error("Expected workbenchStrategy but got", strategy, err)
+	}
+	_, err = newStrategy(&common.Task{})
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_Strategy_Chargeable(t *testing.T) {
+	if (&registeredStrategy{}).Chargeable() {
+		t.Error("Expected the registered firmware not to be chargeable")
+	}
+	if !(&workbenchStrategy{}).Chargeable() {
+		t.Error("Expected the Workbench project to be chargeable")
+	}
+}
+
+func Test_registeredStrategy_Render(t *testing.T) {
+	strategy := &registeredStrategy{
+		firmware: &common.Firmware{OverlayPolicy: &common.OverlayPolicy{
+			Keymap: &common.OverlayRule{Overridable: []string{"config.h"}},
+		}},
+		parametersJson: &common.ParametersJson{
+			Keyboard: map[string]*common.ParameterValue{
+				"file1": {Type: "parameters", Parameters: map[string]string{"rows": "4"}},
+			},
+			Overlays: &common.OverlayFiles{Keymap: []*common.OverlayFile{
+				{Path: "config.h", Content: "#define TAPPING_TERM 180"},
+				{Path: "tap_dance.c", Content: "// tap dance"},
+			}},
+		},
+		keyboardFiles: []*common.FirmwareFile{{ID: "file1", Path: "config.h", Content: `#define ROWS <remap name="rows" />`}},
+		keymapFiles:   []*common.FirmwareFile{{ID: "file2", Path: "config.h", Content: "#pragma once"}},
+	}
+	actual, err := strategy.Render(&State{})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual["keyboard"]) != 1 || actual["keyboard"][0].GetContent() != "#define ROWS 4" {
+		t.Error("Expected the replaced keyboard file but got", actual["keyboard"])
+	}
+	if len(actual["keymap"]) != 2 || actual["keymap"][0].GetContent() != "#define TAPPING_TERM 180" || actual["keymap"][1].GetPath() != "tap_dance.c" {
+		t.Error("Expected the keymap files with the overlay files but got", actual["keymap"])
+	}
+}
+
+func Test_registeredStrategy_ScanPolicy(t *testing.T) {
+	strategy := &registeredStrategy{
+		firmware: &common.Firmware{OverlayPolicy: &common.OverlayPolicy{
+			Keymap: &common.OverlayRule{Overridable: []string{"*"}},
+		}},
+		parametersJson: &common.ParametersJson{
+			Keyboard: map[string]*common.ParameterValue{
+				"file1": {Type: "code", Code: "MCU = atmega32u4"},
+			},
+			Overlays: &common.OverlayFiles{Keymap: []*common.OverlayFile{{Path: "extra.mk"}}},
+		},
+		keyboardFiles: []*common.FirmwareFile{{ID: "file1", Path: "rules.mk"}, {ID: "file2", Path: "common.mk"}},
+		keymapFiles:   []*common.FirmwareFile{{ID: "file3", Path: "rules.mk"}},
+	}
+	_, err := strategy.Render(&State{})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	expected := map[string]bool{"keyboard/rules.mk": true, "keyboard/common.mk": false, "keymap/rules.mk": false, "keymap/extra.mk": true}
+	for filePath, userSupplied := range expected {
+		category, name, _ := strings.Cut(filePath, "/")
+		actual := strategy.ScanPolicy(category, name)
+		if actual.AllowExternalIncludes == userSupplied {
+			t.Error("Expected the user-supplied file to be", userSupplied, "for", filePath, "but got", actual)
+		}
+	}
+	if (&workbenchStrategy{}).ScanPolicy("keymap", "rules.mk").AllowExternalIncludes {
+		t.Error("Expected the default policy for the Workbench files")
+	}
+}
+
+func Test_registeredStrategy_Render_OverlayNotAccepted(t *testing.T) {
+	strategy := &registeredStrategy{
+		firmware: &common.Firmware{},
+		parametersJson: &common.ParametersJson{Overlays: &common.OverlayFiles{
+			Keyboard: []*common.OverlayFile{{Path: "extra.h"}},
+		}},
+	}
+	_, err := strategy.Render(&State{})
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_workbenchStrategy_Render(t *testing.T) {
+	strategy := &workbenchStrategy{
+		keyboardFiles:  []*common.WorkbenchProjectFile{{Path: "config.h"}},
+		keymapFiles:    []*common.WorkbenchProjectFile{{Path: "keymap.c"}, {Path: "rules.mk"}},
+		userspaceFiles: nil,
+	}
+	actual, err := strategy.Render(&State{})
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual["keyboard"]) != 1 || len(actual["keymap"]) != 2 || len(actual["userspace"]) != 0 {
+		t.Error("Expected the files of each category but got", actual)
+	}
+	if actual["keymap"][1].GetPath() != "rules.mk" {
+		t.Error("Expected rules.mk but got", actual["keymap"][1].GetPath())
+	}
+}
+
+func Test_sortedCategories(t *testing.T) {
+	actual := sortedCategories(map[string][]common.BuildableFile{"userspace": nil, "keyboard": nil, "keymap": nil})
+	expected := []string{"keyboard", "keymap", "userspace"}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Error("Expected", expected, "but got", actual)
+		}
+	}
+}
+
+func Test_State_Cleanup(t *testing.T) {
+	state := &State{}
+	var order []int
+	state.addCleanup(func() { order = append(order, 1) })
+	state.addCleanup(func() { order = append(order, 2) })
+	state.cleanup()
+	if len(order) != 2 || order[0] != 2 || order[1] != 1 {
+		t.Error("Expected [2 1] but got", order)
+	}
+}
+
+func Test_State_RecordTiming(t *testing.T) {
+	state := &State{}
+	state.recordTiming(StageCompile, 1500*time.Millisecond)
+	if len(state.timings) != 1 || state.timings[0].Name != StageCompile || state.timings[0].DurationMillis != 1500 {
+		t.Error("Expected the timing of the compile stage but got", state.timings)
+	}
+}
+
+func Test_Failure(t *testing.T) {
+	var err error = &Failure{Message: "Building failed", Stdout: "out", Stderr: "err"}
+	var failure *Failure
+	if !errors.As(err, &failure) || err.Error() != "Building failed" {
+		t.Error("Expected Failure but got", err)
+	}
+}
@@ -0,0 +1,205 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+
+	"remap-keys.app/remap-build-server/build"
+	"remap-keys.app/remap-build-server/common"
+	"remap-keys.app/remap-build-server/database"
+	"remap-keys.app/remap-build-server/parameter"
+	"remap-keys.app/remap-build-server/scanner"
+)
+
+// registeredStrategy builds the firmware from the source files registered by each keyboard owner.
+// The user customizes them with the parameters and the overlay files.
+type registeredStrategy struct {
+	firmware       *common.Firmware
+	parametersJson *common.ParametersJson
+	keyboardFiles  []*common.FirmwareFile
+	keymapFiles    []*common.FirmwareFile
+	// userSuppliedPaths is the paths, like "keymap/rules.mk", of the files including the content supplied by the user.
+	userSuppliedPaths map[string]bool
+}
+
+// Chargeable returns false, because the registered firmware is free.
+func (s *registeredStrategy) Chargeable() bool {
+	return false
+}
+
+func (s *registeredStrategy) LoadSettings(ctx context.Context, p *Pipeline, state *State) (*Settings, error) {
+	// Parse the parameters JSON string.
+	parametersJson, err := parameter.ParseParameterJson(state.Task.ParametersJson)
+	if err != nil {
+		return nil, err
+	}
+	s.parametersJson = parametersJson
+
+	// Fetch the firmware information from the Firestore.
+	firmware, err := database.FetchFirmwareInfo(p.firestoreClient, state.Task)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] The firmware [%+v] exists. The keyboard definition ID is [%+v]\n", state.Task.FirmwareId, firmware.KeyboardDefinitionId)
+	s.firmware = firmware
+
+	// Check whether the firmware is enabled.
+	// The keyboard owner can validate the disabled firmware with the dry run before enabling it.
+	if !firmware.Enabled && !(state.Task.DryRun && firmware.Uid == state.Task.Uid) {
+		return nil, fmt.Errorf("the firmware is not enabled")
+	}
+	// The keyboard directory name is the name of the keyboard in QMK. Without it, the name in the keyboard definition is used.
+	keyboardName := firmware.KeyboardDirectoryName
+	if keyboardName == "" {
+		keyboardName, err = database.FetchKeyboardDefinitionName(p.firestoreClient, firmware.KeyboardDefinitionId)
+		if err != nil {
+			// Ignore the error, because the name is used only for the names of the downloaded files.
+			log.Printf("[ERROR] %s\n", err.Error())
+		}
+	}
+	return &Settings{
+		QmkFirmwareVersion:    firmware.QmkFirmwareVersion,
+		SourceRepository:      firmware.SourceRepository,
+		SourceRef:             firmware.SourceRef,
+		KeymapName:            firmware.KeymapName,
+		EnvironmentVariables:  firmware.EnvironmentVariables,
+		Defines:               firmware.Defines,
+		KeyboardDirectoryName: firmware.KeyboardDirectoryName,
+		KeyboardName:          keyboardName,
+	}, nil
+}
+
+func (s *registeredStrategy) LoadFiles(ctx context.Context, p *Pipeline, state *State) error {
+	// Fetch the keyboard files from the Firestore.
+	keyboardFiles, err := database.FetchKeyboardFiles(p.firestoreClient, state.Task.FirmwareId)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] keyboardFiles: %+v\n", keyboardFiles)
+	s.keyboardFiles = keyboardFiles
+
+	// Fetch the keymap files from the Firestore.
+	keymapFiles, err := database.FetchKeymapFiles(p.firestoreClient, state.Task.FirmwareId)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] keymapFiles: %+v\n", keymapFiles)
+	s.keymapFiles = keymapFiles
+	return nil
+}
+
+// Render replaces the parameters, then merges the overlay files of the user on top of the files of the owner.
+// The files replaced with the parameters and the overlay files are recorded as the files supplied by the user.
+func (s *registeredStrategy) Render(state *State) (map[string][]common.BuildableFile, error) {
+	s.userSuppliedPaths = map[string]bool{}
+	s.recordParameterFiles("keyboard", s.keyboardFiles, s.parametersJson.Keyboard)
+	s.recordParameterFiles("keymap", s.keymapFiles, s.parametersJson.Keymap)
+	keyboardFiles := parameter.ReplaceParameters(s.keyboardFiles, s.parametersJson.Keyboard)
+	keymapFiles := parameter.ReplaceParameters(s.keymapFiles, s.parametersJson.Keymap)
+	if parameter.HasOverlayFiles(s.parametersJson) {
+		var keyboardRule, keymapRule *common.OverlayRule
+		if s.firmware.OverlayPolicy != nil {
+			keyboardRule = s.firmware.OverlayPolicy.Keyboard
+			keymapRule = s.firmware.OverlayPolicy.Keymap
+		}
+		var err error
+		keyboardFiles, err = parameter.ApplyOverlayFiles("keyboard", keyboardFiles, s.parametersJson.Overlays.Keyboard, keyboardRule)
+		if err != nil {
+			return nil, err
+		}
+		keymapFiles, err = parameter.ApplyOverlayFiles("keymap", keymapFiles, s.parametersJson.Overlays.Keymap, keymapRule)
+		if err != nil {
+			return nil, err
+		}
+		for _, overlayFile := range s.parametersJson.Overlays.Keyboard {
+			s.userSuppliedPaths[path.Join("keyboard", overlayFile.Path)] = true
+		}
+		for _, overlayFile := range s.parametersJson.Overlays.Keymap {
+			s.userSuppliedPaths[path.Join("keymap", overlayFile.Path)] = true
+		}
+	}
+	return map[string][]common.BuildableFile{
+		"keyboard": toBuildableFiles(keyboardFiles),
+		"keymap":   toBuildableFiles(keymapFiles),
+	}, nil
+}
+
+// recordParameterFiles records the files whose content is replaced with the code or the text entered by the user.
+func (s *registeredStrategy) recordParameterFiles(category string, files []*common.FirmwareFile, parameterValueMap map[string]*common.ParameterValue) {
+	for _, file := range files {
+		parameterValue := parameterValueMap[file.ID]
+		if parameterValue != nil && (parameterValue.Type == "code" || len(parameterValue.Parameters) > 0) {
+			s.userSuppliedPaths[path.Join(category, file.Path)] = true
+		}
+	}
+}
+
+// ScanPolicy returns the default policy for the files including the content supplied by the user,
+// and the owner policy for the files registered by the owner as they are.
+func (s *registeredStrategy) ScanPolicy(category string, filePath string) scanner.Policy {
+	if s.userSuppliedPaths[path.Join(category, filePath)] {
+		return scanner.DefaultPolicy
+	}
+	return scanner.OwnerPolicy
+}
+
+// PrepareTree creates the keyboard directory in the QMK Firmware tree, which is deleted after the build.
+// The code and the text written by the user are untrusted, so the compile step runs in the sandbox if the user replaced
+// any code or parameter, or added any overlay file.
+func (s *registeredStrategy) PrepareTree(state *State) (func(), error) {
+	// Generate the keyboard ID.
+	keyboardId := build.GenerateKeyboardId(state.Settings.KeyboardDirectoryName)
+	log.Printf("[INFO] keyboardId: %s\n", keyboardId)
+	qmkFirmwareVersion := state.Settings.QmkFirmwareVersion
+
+	// Prepare the keyboard directory.
+	keyboardDirectoryPath, err := build.PrepareKeyboardDirectory(keyboardId, qmkFirmwareVersion)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Keyboard directory path: %s\n", keyboardDirectoryPath)
+
+	// Delete the keyboard directory and the build outputs after the build.
+	cleanup := func() {
+		err := build.DeleteKeyboardDirectory(keyboardId, qmkFirmwareVersion)
+		if err != nil {
+			log.Printf("[ERROR] %s\n", err.Error())
+		}
+		log.Printf("[INFO] Deleted the keyboard directory: %s\n", keyboardDirectoryPath)
+		err = build.CleanBuildOutputs(keyboardId, qmkFirmwareVersion, state.Variants)
+		if err != nil {
+			log.Printf("[ERROR] %s\n", err.Error())
+		}
+	}
+
+	// Remove the outputs left by the previous build, so the objects and the dependency files planted by it are not used.
+	err = build.CleanBuildOutputs(keyboardId, qmkFirmwareVersion, state.Variants)
+	if err != nil {
+		return cleanup, err
+	}
+
+	// Create the keyboard files.
+	err = build.CreateFiles(keyboardDirectoryPath, state.Files["keyboard"])
+	if err != nil {
+		return cleanup, err
+	}
+
+	// Create the keymap files for each keymap.
+	err = build.CreateKeymapFiles(keyboardDirectoryPath, state.Variants, state.Files["keymap"])
+	if err != nil {
+		return cleanup, err
+	}
+
+	state.Options.KeyboardId = keyboardId
+	if parameter.HasCodeParameterValue(s.parametersJson) || parameter.HasTextParameterValue(s.parametersJson) ||
+		parameter.HasOverlayFiles(s.parametersJson) {
+		state.Options.Sandbox = build.DefaultSandboxConfig()
+	}
+	return cleanup, nil
+}
+
+func (s *registeredStrategy) NewManifest(state *State) *build.Manifest {
+	return build.NewManifest(state.Params.TaskId, state.Task, s.firmware, s.parametersJson, state.Options)
+}
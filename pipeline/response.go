@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"remap-keys.app/remap-build-server/build"
+	"remap-keys.app/remap-build-server/common"
+	"remap-keys.app/remap-build-server/database"
+)
+
+// maxInfrastructureRetryCount is the number of the retries after which an infrastructure error fails the task.
+const maxInfrastructureRetryCount = 5
+
+// Failure is the failure of the task reported to the user with the outputs of the build, like the compiler errors.
+type Failure struct {
+	Message string
+	Stdout  string
+	Stderr  string
+}
+
+func (f *Failure) Error() string {
+	return f.Message
+}
+
+// sendError updates the task by the kind of the error and writes the response.
+func (p *Pipeline) sendError(ctx context.Context, w http.ResponseWriter, params *common.RequestParameters, cause error) {
+	var infrastructureError *build.InfrastructureError
+	if errors.As(cause, &infrastructureError) {
+		p.sendInfrastructureErrorResponse(ctx, w, params, cause)
+		return
+	}
+	var failure *Failure
+	if errors.As(cause, &failure) {
+		p.sendFailureResponseWithStdoutAndStderr(ctx, w, params.TaskId, failure.Message, failure.Stdout, failure.Stderr)
+		return
+	}
+	p.sendFailureResponseWithStdoutAndStderr(ctx, w, params.TaskId, cause.Error(), "", cause.Error())
+}
+
+func (p *Pipeline) sendFailureResponseWithStdoutAndStderr(ctx context.Context, w http.ResponseWriter, taskId string, message string, stdout string, stderr string) {
+	log.Printf("[ERROR] %s\n", message)
+	// Update the task status to "failure".
+	err := database.UpdateTask(ctx, p.firestoreClient, taskId, "failure", stdout, stderr, "")
+	if err != nil {
+		// Ignore the error about updating the task status.
+		log.Printf("[ERROR] %s\n", err.Error())
+	}
+	// Return the error message, but return the status code 200 to avoid the retry with Cloud Tasks.
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, message)
+}
+
+// Report the failure of the build environment. The status code 503 makes Cloud Tasks retry the task,
+// and the task keeps the "building" status. After the retries are exhausted, the task fails.
+func (p *Pipeline) sendInfrastructureErrorResponse(ctx context.Context, w http.ResponseWriter, params *common.RequestParameters, cause error) {
+	if params.RetryCount >= maxInfrastructureRetryCount {
+		p.sendFailureResponseWithStdoutAndStderr(ctx, w, params.TaskId, cause.Error(), "", cause.Error())
+		return
+	}
+	log.Printf("[ERROR] Infrastructure error (retry %d): %s\n", params.RetryCount, cause.Error())
+	err := database.UpdateTaskInfrastructureError(ctx, p.firestoreClient, params.TaskId, cause.Error())
+	if err != nil {
+		log.Printf("[ERROR] %s\n", err.Error())
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	io.WriteString(w, cause.Error())
+}
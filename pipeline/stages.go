@@ -0,0 +1,486 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"remap-keys.app/remap-build-server/auth"
+	"remap-keys.app/remap-build-server/build"
+	"remap-keys.app/remap-build-server/common"
+	"remap-keys.app/remap-build-server/database"
+	"remap-keys.app/remap-build-server/scanner"
+	"remap-keys.app/remap-build-server/versions"
+	"remap-keys.app/remap-build-server/web"
+)
+
+// authorize checks whether the task belongs to the user of the request and the request is authenticated,
+// then selects the strategy of the source type of the task.
+func authorize(ctx context.Context, p *Pipeline, state *State) error {
+	// Check whether the uid in the task information and passed uid are the same.
+	if state.Task.Uid != state.Params.Uid {
+		return fmt.Errorf("uid in the task information and passed uid are not the same")
+	}
+
+	// Check the authentication token. The reproducibility check is allowed only for the administrators.
+	var err error
+	if state.Params.Mode == web.ModeReproducibility {
+		err = auth.CheckAdminAuthenticationToken(state.Request)
+	} else {
+		err = auth.CheckAuthenticationToken(state.Request)
+	}
+	if err != nil {
+		return err
+	}
+
+	strategy, err := newStrategy(state.Task)
+	if err != nil {
+		return err
+	}
+	state.Strategy = strategy
+	return nil
+}
+
+// charge decreases the remaining build count of the user if the source type is chargeable.
+// The dry run does not compile and the reproducibility check is run by the administrators,
+// so they do not consume the remaining build count.
+// The task retried after an infrastructure error has already been charged.
+func charge(ctx context.Context, p *Pipeline, state *State) error {
+	if !state.Strategy.Chargeable() || state.Task.DryRun || state.Params.Mode != web.ModeBuild || state.Task.Charged {
+		return nil
+	}
+	// Check whether the remaining build count is greater than 0.
+	userPurchase, err := database.FetchUserPurchase(p.firestoreClient, state.Params.Uid)
+	if err != nil {
+		return err
+	}
+	if userPurchase.RemainingBuildCount <= 0 {
+		return fmt.Errorf("the user has no remaining build count")
+	}
+	// Decrease the remaining build count by 1.
+	err = database.DecreaseRemainingBuildCount(p.firestoreClient, state.Params.Uid)
+	if err != nil {
+		return err
+	}
+	return database.UpdateTaskCharged(ctx, p.firestoreClient, state.Params.TaskId)
+}
+
+// loadSources loads the settings of the source, resolves the QMK Firmware version and the variants,
+// checks the extra build flags and the lint mode, then loads the source files.
+// It runs before the charge stage, so the invalid task does not consume the remaining build count.
+func loadSources(ctx context.Context, p *Pipeline, state *State) error {
+	settings, err := state.Strategy.LoadSettings(ctx, p, state)
+	if err != nil {
+		return err
+	}
+	state.Settings = settings
+
+	// Resolve the QMK Firmware version. The aliases and the retired versions are resolved to the installed version.
+	qmkFirmwareVersion, err := p.resolveQmkFirmwareVersion(ctx, state.Params, settings.QmkFirmwareVersion, settings.SourceRepository, settings.SourceRef)
+	if err != nil {
+		return err
+	}
+	settings.QmkFirmwareVersion = qmkFirmwareVersion
+	if settings.SourceRepository != "" {
+		// The materialized tree can be evicted after the build.
+		state.addCleanup(func() {
+			p.versionRegistry.Release(qmkFirmwareVersion)
+		})
+	}
+
+	// Resolve the keymaps and the revisions to build.
+	variants, err := build.ResolveBuildVariants(state.Task, settings.KeymapName)
+	if err != nil {
+		return err
+	}
+	state.Variants = variants
+
+	// Check the extra build flags.
+	err = build.ValidateBuildFlags(settings.EnvironmentVariables, settings.Defines)
+	if err != nil {
+		return err
+	}
+
+	// Check the lint mode.
+	err = build.ValidateLintMode(state.Task.LintMode)
+	if err != nil {
+		return err
+	}
+
+	// Update the task status to "building".
+	err = database.UpdateTaskStatusToBuilding(ctx, p.firestoreClient, state.Params.TaskId)
+	if err != nil {
+		return err
+	}
+	return state.Strategy.LoadFiles(ctx, p, state)
+}
+
+// resolveQmkFirmwareVersion resolves the requested QMK Firmware version and records the result on the task.
+// If the source repository is specified, the tree of the ref is materialized from the mirror instead,
+// and the caller must release it after the build.
+func (p *Pipeline) resolveQmkFirmwareVersion(ctx context.Context, params *common.RequestParameters, requestedVersion string, sourceRepository string, sourceRef string) (string, error) {
+	var resolution *versions.Resolution
+	var err error
+	if sourceRepository != "" {
+		var version *versions.Version
+		version, err = p.versionRegistry.Materialize(sourceRepository, sourceRef)
+		if err == nil {
+			resolution = &versions.Resolution{Requested: sourceRepository + "@" + sourceRef, Version: *version}
+			requestedVersion = resolution.Requested
+		}
+	} else {
+		resolution, err = p.versionRegistry.Resolve(requestedVersion, time.Now())
+	}
+	// The missing tree of the configured version is a failure of the build environment, so the task is retried.
+	var treeNotInstalledError *versions.TreeNotInstalledError
+	if errors.As(err, &treeNotInstalledError) {
+		return "", &build.InfrastructureError{Message: err.Error()}
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, warning := range resolution.Warnings {
+		log.Printf("[INFO] %s\n", warning)
+	}
+	log.Printf("[INFO] The QMK Firmware version [%s] is resolved to [%s].\n", requestedVersion, resolution.Version.Name)
+	err = database.UpdateTaskQmkFirmwareVersion(ctx, p.firestoreClient, params.TaskId, resolution.Version.Name, resolution.Warnings)
+	if err != nil {
+		if sourceRepository != "" {
+			p.versionRegistry.Release(resolution.Version.Name)
+		}
+		return "", err
+	}
+	return resolution.Version.Name, nil
+}
+
+// render renders the files with the strategy, then checks the encodings, the sizes and the makefile fragments
+// of the files before they reach the build.
+func render(ctx context.Context, p *Pipeline, state *State) error {
+	files, err := state.Strategy.Render(state)
+	if err != nil {
+		return err
+	}
+	categories := sortedCategories(files)
+	buildableFilesList := make([][]common.BuildableFile, len(categories))
+	for i, category := range categories {
+		buildableFilesList[i] = files[category]
+	}
+	err = build.ValidateBuildableFiles(buildableFilesList...)
+	if err != nil {
+		return err
+	}
+	err = p.scanMakefileFragments(ctx, state.Params, state.Strategy, files)
+	if err != nil {
+		return err
+	}
+	state.Files = files
+	return nil
+}
+
+func sortedCategories(files map[string][]common.BuildableFile) []string {
+	categories := make([]string, 0, len(files))
+	for category := range files {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// scanMakefileFragments scans the makefile fragments of each category with the policy of the strategy for each file.
+// The violations are recorded on the task and reported as a Failure.
+func (p *Pipeline) scanMakefileFragments(ctx context.Context, params *common.RequestParameters, strategy Strategy, files map[string][]common.BuildableFile) error {
+	var violations []common.ScanViolation
+	for _, category := range sortedCategories(files) {
+		for _, file := range files[category] {
+			policy := strategy.ScanPolicy(category, file.GetPath())
+			violations = append(violations, scanner.ScanFiles(category, []common.BuildableFile{file}, policy)...)
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	log.Printf("[INFO] %d violations are found by the security scan\n", len(violations))
+	err := database.UpdateTaskScanViolations(ctx, p.firestoreClient, params.TaskId, violations)
+	if err != nil {
+		log.Printf("[ERROR] %s\n", err.Error())
+	}
+	return &Failure{Message: "Security scan failed", Stderr: scanner.FormatViolations(violations)}
+}
+
+// prepareTree creates the build options from the settings, then writes the files into the QMK Firmware tree
+// with the strategy.
+func prepareTree(ctx context.Context, p *Pipeline, state *State) error {
+	state.Options = build.BuildOptions{
+		QmkFirmwareVersion:   state.Settings.QmkFirmwareVersion,
+		EnvironmentVariables: state.Settings.EnvironmentVariables,
+		Defines:              state.Settings.Defines,
+		CompilerCache:        build.DefaultCompilerCacheConfig(),
+		BuildInfo:            build.NewBuildInfo(state.Params.TaskId, state.Task, state.Settings.QmkFirmwareVersion),
+	}
+	cleanup, err := state.Strategy.PrepareTree(state)
+	if cleanup != nil {
+		state.addCleanup(cleanup)
+	}
+	return err
+}
+
+// lint lints the keyboard and the keymap of each variant with the lint mode of the task.
+// The strict lint reports the messages as a Failure.
+func lint(ctx context.Context, p *Pipeline, state *State) error {
+	task := state.Task
+	if task.LintMode == build.LintModeDisabled {
+		return nil
+	}
+	options := state.Options
+	var lintMessages []common.LintMessage
+	var lintStdout, lintStderr string
+	lintSucceeded := true
+	for _, variant := range state.Variants {
+		options.KeymapName = variant.KeymapName
+		options.Revision = variant.Revision
+		lintResult := build.LintQmkFirmware(options)
+		log.Printf("[INFO] lintResult: %v, %d messages\n", lintResult.Success, len(lintResult.Messages))
+		lintSucceeded = lintSucceeded && lintResult.Success
+		lintMessages = append(lintMessages, lintResult.Messages...)
+		lintStdout += lintResult.Stdout
+		lintStderr += lintResult.Stderr
+	}
+	err := database.UpdateTaskLintMessages(ctx, p.firestoreClient, state.Params.TaskId, lintMessages)
+	if err != nil {
+		return err
+	}
+	if task.LintMode == build.LintModeStrict && (!lintSucceeded || len(lintMessages) > 0) {
+		return &Failure{Message: "Linting failed", Stdout: lintStdout, Stderr: lintStderr}
+	}
+	return nil
+}
+
+// publishRenderedFiles uploads the rendered files of the dry run to the Cloud Storage,
+// so the user can check the result of the parameter replacement.
+func publishRenderedFiles(ctx context.Context, p *Pipeline, state *State) error {
+	renderedFiles, err := build.CreateRenderedFiles(state.Files)
+	if err != nil {
+		return err
+	}
+	content, err := build.MarshalRenderedFiles(renderedFiles)
+	if err != nil {
+		return err
+	}
+	remoteRenderedFilePath, err := database.UploadJsonFileToCloudStorage(ctx, p.storageClient, state.Params.Uid, build.CreateRenderedFilesFileName(state.Params.TaskId), content)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] remoteRenderedFilePath: %s\n", remoteRenderedFilePath)
+	err = database.UpdateTaskRenderedFilePath(ctx, p.firestoreClient, state.Params.TaskId, remoteRenderedFilePath)
+	if err != nil {
+		return err
+	}
+	state.message = "Validating succeeded"
+	return nil
+}
+
+// checkReproducibility builds the firmware of each variant twice in the isolated trees and compares the results.
+// The results are stored in the task, and the differences of the non-reproducible builds are reported as a Failure.
+func checkReproducibility(ctx context.Context, p *Pipeline, state *State) error {
+	log.Printf("[INFO] The task [%s] is a reproducibility check.\n", state.Params.TaskId)
+	err := build.Preflight(state.Options, state.Variants)
+	if err != nil {
+		return err
+	}
+	options := state.Options
+	results := make([]common.ReproducibilityResult, 0, len(state.Variants))
+	reproducible := true
+	for _, variant := range state.Variants {
+		options.KeymapName = variant.KeymapName
+		options.Revision = variant.Revision
+		result, err := build.CheckReproducibility(options)
+		if err != nil {
+			return err
+		}
+		results = append(results, *result)
+		reproducible = reproducible && result.Reproducible
+	}
+	err = database.UpdateTaskReproducibility(ctx, p.firestoreClient, state.Params.TaskId, results)
+	if err != nil {
+		return err
+	}
+	if !reproducible {
+		return &Failure{Message: "Building is not reproducible", Stderr: build.FormatReproducibilityResults(results)}
+	}
+	state.message = "Building is reproducible"
+	return nil
+}
+
+// compile checks the build environment, lints the variants, then builds the firmware of each variant.
+// The first failed build stops the stage.
+func compile(ctx context.Context, p *Pipeline, state *State) error {
+	// Check the build environment before building.
+	err := build.Preflight(state.Options, state.Variants)
+	if err != nil {
+		return err
+	}
+
+	// Lint the keyboard and the keymap of each variant before building.
+	err = lint(ctx, p, state)
+	if err != nil {
+		return err
+	}
+
+	state.Manifest = state.Strategy.NewManifest(state)
+	options := state.Options
+	compilerCacheStats := &common.CompilerCacheStats{}
+	for _, variant := range state.Variants {
+		log.Printf("[INFO] Building the variant: keymap=%s, revision=%s\n", variant.KeymapName, variant.Revision)
+
+		// Build the QMK Firmware.
+		options.KeymapName = variant.KeymapName
+		options.Revision = variant.Revision
+		buildResult := build.BuildQmkFirmware(options)
+		log.Printf("[INFO] buildResult: %v\n", buildResult.Success)
+		state.stdout += buildResult.Stdout
+		if buildResult.CompilerCacheStats != nil {
+			compilerCacheStats.Hits += buildResult.CompilerCacheStats.Hits
+			compilerCacheStats.Misses += buildResult.CompilerCacheStats.Misses
+			compilerCacheStats.Uncacheable += buildResult.CompilerCacheStats.Uncacheable
+			err := database.UpdateTaskCompilerCacheStats(ctx, p.firestoreClient, state.Params.TaskId, compilerCacheStats)
+			if err != nil {
+				log.Printf("[ERROR] %s\n", err.Error())
+			}
+		}
+		if buildResult.LimitExceeded != "" {
+			err := database.UpdateTaskLimitExceeded(ctx, p.firestoreClient, state.Params.TaskId, buildResult.LimitExceeded)
+			if err != nil {
+				log.Printf("[ERROR] %s\n", err.Error())
+			}
+		}
+		if !buildResult.Success {
+			message := "Building failed"
+			if buildResult.LimitExceeded != "" {
+				message = fmt.Sprintf("Building failed: the %s limit was exceeded", buildResult.LimitExceeded)
+			}
+			return &Failure{Message: message, Stdout: state.stdout, Stderr: buildResult.Stderr}
+		}
+		log.Printf("[INFO] Building succeeded\n")
+		state.buildResults = append(state.buildResults, variantBuildResult{variant: variant, options: options, result: buildResult})
+	}
+	return nil
+}
+
+// collect verifies the firmware files of each variant before handing them to the user,
+// and names them in the Cloud Storage.
+func collect(ctx context.Context, p *Pipeline, state *State) error {
+	for _, buildResult := range state.buildResults {
+		err := build.VerifyArtifacts(buildResult.result.Artifacts, buildResult.result.Hardware)
+		if err != nil {
+			return &Failure{Message: "Verifying the firmware files failed", Stdout: state.stdout, Stderr: err.Error()}
+		}
+		for _, artifact := range buildResult.result.Artifacts {
+			log.Printf("[INFO] localFirmwareFilePath: %s\n", artifact.FilePath)
+			objectName, err := build.CreateArtifactObjectName(state.Params.TaskId, buildResult.options, artifact)
+			if err != nil {
+				return err
+			}
+			state.uploads = append(state.uploads, artifactUpload{
+				variant:          buildResult.variant,
+				artifact:         artifact,
+				objectName:       objectName,
+				downloadFileName: build.CreateDownloadFileName(state.Settings.KeyboardName, buildResult.options, artifact),
+			})
+		}
+	}
+	return nil
+}
+
+// publish uploads the firmware files and the manifest describing them to the Cloud Storage,
+// and stores the artifacts of all variants on the task.
+func publish(ctx context.Context, p *Pipeline, state *State) error {
+	// Upload each firmware file to the Cloud Storage.
+	for _, upload := range state.uploads {
+		remoteFirmwareFilePath, err := database.UploadFirmwareFileToCloudStorage(ctx, p.storageClient, state.Params.Uid, upload.objectName, upload.downloadFileName, upload.artifact.FilePath)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] remoteFirmwareFilePath: %s\n", remoteFirmwareFilePath)
+		err = state.Manifest.AddArtifact(upload.variant, upload.artifact, remoteFirmwareFilePath)
+		if err != nil {
+			return err
+		}
+		state.artifacts = append(state.artifacts, common.TaskArtifact{
+			KeymapName:       upload.variant.KeymapName,
+			Revision:         upload.variant.Revision,
+			FirmwareFilePath: remoteFirmwareFilePath,
+			DownloadFileName: upload.downloadFileName,
+			Format:           upload.artifact.Format,
+			ConvertedFrom:    upload.artifact.ConvertedFrom,
+		})
+	}
+
+	// Upload the manifest describing the artifacts of all variants.
+	// The hardware is the same for all variants, so the one of the last variant is recorded.
+	state.Manifest.Finish(state.buildResults[len(state.buildResults)-1].result.Hardware)
+	manifestContent, err := state.Manifest.Marshal()
+	if err != nil {
+		return err
+	}
+	remoteManifestFilePath, err := database.UploadJsonFileToCloudStorage(ctx, p.storageClient, state.Params.Uid, build.CreateManifestFileName(state.Params.TaskId), manifestContent)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] remoteManifestFilePath: %s\n", remoteManifestFilePath)
+	err = database.UpdateTaskManifestFilePath(ctx, p.firestoreClient, state.Params.TaskId, remoteManifestFilePath)
+	if err != nil {
+		return err
+	}
+
+	// Generate the skeleton of the keyboard definition for the keyboard owner.
+	// The firmware files are usable without it, so the failure does not fail the task.
+	err = p.uploadKeyboardDefinition(ctx, state.Params, state.buildResults[0].options)
+	if err != nil {
+		log.Printf("[ERROR] Generating the keyboard definition failed: %s\n", err.Error())
+	}
+
+	// Store the artifacts of all variants.
+	err = database.UpdateTaskArtifacts(ctx, p.firestoreClient, state.Params.TaskId, state.artifacts)
+	if err != nil {
+		return err
+	}
+	state.message = "Building succeeded"
+	return nil
+}
+
+// uploadKeyboardDefinition generates the keyboard definition from the keyboard information resolved by QMK
+// with the build options of a variant, then uploads it to the Cloud Storage and records it on the task.
+func (p *Pipeline) uploadKeyboardDefinition(ctx context.Context, params *common.RequestParameters, options build.BuildOptions) error {
+	info, err := build.FetchKeyboardInfo(options)
+	if err != nil {
+		return err
+	}
+	definition, err := build.CreateViaDefinition(info)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(definition, "", "  ")
+	if err != nil {
+		return err
+	}
+	remoteDefinitionFilePath, err := database.UploadJsonFileToCloudStorage(ctx, p.storageClient, params.Uid, build.CreateDefinitionFileName(params.TaskId), content)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] remoteDefinitionFilePath: %s\n", remoteDefinitionFilePath)
+	return database.UpdateTaskDefinitionFilePath(ctx, p.firestoreClient, params.TaskId, remoteDefinitionFilePath)
+}
+
+// finalize updates the task status to "success".
+// The firmwareFilePath field keeps the firmware file of the first variant for the compatibility.
+func finalize(ctx context.Context, p *Pipeline, state *State) error {
+	var firmwareFilePath string
+	if len(state.artifacts) > 0 {
+		firmwareFilePath = state.artifacts[0].FirmwareFilePath
+	}
+	return database.UpdateTask(ctx, p.firestoreClient, state.Params.TaskId, "success", state.stdout, "", firmwareFilePath)
+}
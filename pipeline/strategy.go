@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+
+	"remap-keys.app/remap-build-server/build"
+	"remap-keys.app/remap-build-server/common"
+	"remap-keys.app/remap-build-server/scanner"
+)
+
+// Strategy implements the stages which differ by the source type of the task.
+// A new source type is supported by implementing this interface and adding it to newStrategy.
+type Strategy interface {
+	// Chargeable reports whether the build consumes the remaining build count of the user.
+	Chargeable() bool
+	// LoadSettings fetches the settings of the source, like the firmware or the Workbench project.
+	LoadSettings(ctx context.Context, p *Pipeline, state *State) (*Settings, error)
+	// LoadFiles fetches the source files. It is called after the task status is updated to "building".
+	LoadFiles(ctx context.Context, p *Pipeline, state *State) error
+	// Render creates the files written for the build of each category.
+	// The files are validated and scanned by the render stage.
+	Render(state *State) (map[string][]common.BuildableFile, error)
+	// ScanPolicy returns the policy of the security scan for the rendered file of the category.
+	ScanPolicy(category string, filePath string) scanner.Policy
+	// PrepareTree writes the rendered files into the QMK Firmware tree, and sets the keyboard ID,
+	// the userspace and the sandbox of the build options of the state.
+	// The returned function restores the tree after the response is sent.
+	PrepareTree(state *State) (func(), error)
+	// NewManifest creates the manifest describing the source of the build.
+	NewManifest(state *State) *build.Manifest
+}
+
+// newStrategy returns the strategy of the source type of the task.
+func newStrategy(task *common.Task) (Strategy, error) {
+	if task.FirmwareId != "" {
+		return &registeredStrategy{}, nil
+	}
+	if task.ProjectId != "" {
+		return &workbenchStrategy{}, nil
+	}
+	return nil, fmt.Errorf("the task does not have firmwareId or projectId")
+}
+
+// toBuildableFiles converts the files of a source type to the buildable files.
+func toBuildableFiles[T common.BuildableFile](files []T) []common.BuildableFile {
+	buildableFiles := make([]common.BuildableFile, len(files))
+	for i, file := range files {
+		buildableFiles[i] = file
+	}
+	return buildableFiles
+}
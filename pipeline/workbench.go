@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"context"
+	"log"
+
+	"remap-keys.app/remap-build-server/build"
+	"remap-keys.app/remap-build-server/common"
+	"remap-keys.app/remap-build-server/database"
+	"remap-keys.app/remap-build-server/scanner"
+)
+
+// workbenchStrategy builds the firmware from the source files created with the Workbench feature.
+type workbenchStrategy struct {
+	keyboardFiles  []*common.WorkbenchProjectFile
+	keymapFiles    []*common.WorkbenchProjectFile
+	userspaceFiles []*common.WorkbenchProjectFile
+}
+
+// Chargeable returns true, because each build of the Workbench project consumes the remaining build count.
+func (s *workbenchStrategy) Chargeable() bool {
+	return true
+}
+
+func (s *workbenchStrategy) LoadSettings(ctx context.Context, p *Pipeline, state *State) (*Settings, error) {
+	// Fetch the workbench project information from the Firestore.
+	project, err := database.FetchWorkbenchProjectInfo(p.firestoreClient, state.Task)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] The workbench project [%+v] exists.\n", state.Task.ProjectId)
+	// The keyboard directory name is the name of the keyboard in QMK. Without it, the name of the project is used.
+	keyboardName := project.KeyboardDirectoryName
+	if keyboardName == "" {
+		keyboardName = project.Name
+	}
+	return &Settings{
+		QmkFirmwareVersion:    project.QmkFirmwareVersion,
+		SourceRepository:      project.SourceRepository,
+		SourceRef:             project.SourceRef,
+		KeymapName:            project.KeymapName,
+		EnvironmentVariables:  project.EnvironmentVariables,
+		Defines:               project.Defines,
+		KeyboardDirectoryName: project.KeyboardDirectoryName,
+		KeyboardName:          keyboardName,
+	}, nil
+}
+
+func (s *workbenchStrategy) LoadFiles(ctx context.Context, p *Pipeline, state *State) error {
+	// Fetch the workbench keyboard files from the Firestore.
+	keyboardFiles, err := database.FetchWorkbenchKeyboardFiles(p.firestoreClient, state.Task.ProjectId)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] keyboardFiles: %+v\n", keyboardFiles)
+	s.keyboardFiles = keyboardFiles
+
+	// Fetch the workbench keymap files from the Firestore.
+	keymapFiles, err := database.FetchWorkbenchKeymapFiles(p.firestoreClient, state.Task.ProjectId)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] keymapFiles: %+v\n", keymapFiles)
+	s.keymapFiles = keymapFiles
+
+	// Fetch the workbench userspace files from the Firestore.
+	userspaceFiles, err := database.FetchWorkbenchUserspaceFiles(p.firestoreClient, state.Task.ProjectId)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] userspaceFiles: %+v\n", userspaceFiles)
+	s.userspaceFiles = userspaceFiles
+	return nil
+}
+
+// Render returns the files as they are, because the Workbench files have no parameters.
+func (s *workbenchStrategy) Render(state *State) (map[string][]common.BuildableFile, error) {
+	return map[string][]common.BuildableFile{
+		"keyboard":  toBuildableFiles(s.keyboardFiles),
+		"keymap":    toBuildableFiles(s.keymapFiles),
+		"userspace": toBuildableFiles(s.userspaceFiles),
+	}, nil
+}
+
+// ScanPolicy returns the default policy, because all the Workbench files are supplied by the user.
+func (s *workbenchStrategy) ScanPolicy(category string, filePath string) scanner.Policy {
+	return scanner.DefaultPolicy
+}
+
+// PrepareTree opens the workspace of the project and writes the changed files into it.
+// The keyboard ID and the userspace name are stable across the builds of the project,
+// so the object files of the previous build can be reused.
+// The Workbench source files are untrusted, so the compile step always runs in the sandbox.
+func (s *workbenchStrategy) PrepareTree(state *State) (func(), error) {
+	workspaceName := build.CreateWorkspaceName(state.Task.ProjectId)
+	keyboardId := state.Settings.KeyboardDirectoryName
+	if keyboardId == "" {
+		keyboardId = workspaceName
+	}
+	log.Printf("[INFO] keyboardId: %s\n", keyboardId)
+	var userName string
+	if len(s.userspaceFiles) > 0 {
+		userName = workspaceName
+	}
+
+	// Open the workspace of the project. The keyboard directory, the userspace directory and the object directories
+	// of the previous build are restored into the QMK Firmware directory.
+	workspace, err := build.OpenWorkspace(build.DefaultWorkspaceConfig(), state.Settings.QmkFirmwareVersion, state.Task.ProjectId, keyboardId, userName, state.Variants)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Keyboard directory path: %s\n", workspace.KeyboardDirectoryPath())
+
+	// Move the directories back to the workspace and delete the other build outputs after the build.
+	cleanup := func() {
+		err := workspace.Close()
+		if err != nil {
+			log.Printf("[ERROR] %s\n", err.Error())
+		}
+	}
+
+	// Write the changed keyboard files and keymap files for each keymap.
+	err = build.SyncKeyboardFiles(workspace.KeyboardDirectoryPath(), state.Variants, state.Files["keyboard"], state.Files["keymap"])
+	if err != nil {
+		return cleanup, err
+	}
+
+	// Write the changed userspace files into the isolated userspace directory.
+	if userName != "" {
+		log.Printf("[INFO] Userspace directory path: %s\n", workspace.UserspaceDirectoryPath())
+		err = build.SyncFiles(workspace.UserspaceDirectoryPath(), state.Files["userspace"])
+		if err != nil {
+			return cleanup, err
+		}
+	}
+
+	state.Options.KeyboardId = keyboardId
+	state.Options.UserName = userName
+	state.Options.Sandbox = build.DefaultSandboxConfig()
+	return cleanup, nil
+}
+
+func (s *workbenchStrategy) NewManifest(state *State) *build.Manifest {
+	return build.NewManifest(state.Params.TaskId, state.Task, nil, nil, state.Options)
+}
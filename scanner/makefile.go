@@ -0,0 +1,314 @@
+package scanner
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+const (
+	RuleShell              string = "shell"
+	RuleShellMetacharacter string = "shell-metacharacter"
+	RuleEval               string = "eval"
+	RuleFile               string = "file"
+	RuleLoad               string = "load"
+	RuleInclude            string = "include"
+	RuleRecipe             string = "recipe"
+	RuleForbiddenVariable  string = "forbidden-variable"
+	RuleEncoding           string = "encoding"
+)
+
+// Policy represents which constructs are allowed in the makefile fragments.
+type Policy struct {
+	// AllowShell allows the $(shell ...) function, the "!=" assignment and the shell metacharacters in values.
+	AllowShell bool
+	// AllowShellMetacharacters allows only the shell metacharacters in values, like "&&" in the compiler flags.
+	AllowShellMetacharacters bool
+	// AllowEval allows the $(eval ...) and $(value ...) functions.
+	AllowEval bool
+	// AllowRecipes allows the rule definitions and the recipe lines.
+	AllowRecipes bool
+	// AllowExternalIncludes allows the include directives with absolute paths, ".." or variable references.
+	AllowExternalIncludes bool
+	// ForbiddenVariables is the list of the variables which must not be assigned, exported or overridden.
+	ForbiddenVariables []string
+}
+
+// DefaultPolicy is the policy for the makefile fragments supplied by users.
+var DefaultPolicy = Policy{
+	AllowShell:            false,
+	AllowEval:             false,
+	AllowRecipes:          false,
+	AllowExternalIncludes: false,
+	ForbiddenVariables: []string{
+		"AR", "AS", "AWK", "CC", "CC_PREFIX", "COPY", "CPP", "CXX", "GIT", "LD", "MAKE", "MAKEFLAGS",
+		"MAKESHELL", "MKDIR", "NM", "OBJCOPY", "OBJDUMP", "PATH", "PYTHON", "QMK_BIN", "REMOVE",
+		"SHELL", ".SHELLFLAGS", "SIZE", "TOOLCHAIN", "VPATH", "USER_NAME",
+	},
+}
+
+// OwnerPolicy is the policy for the makefile fragments registered by the keyboard owners.
+// The owners port the fragments from the QMK Firmware repository, which include the other fragments through variables
+// like $(KEYBOARD_PATH_1) and have the shell metacharacters in values. Running commands while make parses
+// the fragments and replacing the tools are still not allowed, because the registered firmware is built
+// for every user without the sandbox.
+var OwnerPolicy = Policy{
+	AllowShell:               false,
+	AllowShellMetacharacters: true,
+	AllowEval:                false,
+	AllowRecipes:             false,
+	AllowExternalIncludes:    true,
+	ForbiddenVariables:       DefaultPolicy.ForbiddenVariables,
+}
+
+var makefileNamePattern = regexp.MustCompile(`^(Makefile|makefile|GNUmakefile|.+\.mk)$`)
+var assignmentPattern = regexp.MustCompile(`^((?:override|export|private|unexport)\s+)*([^\s:#=!?+]+)\s*(::=|:::=|:=|\+=|\?=|!=|=)`)
+var exportPattern = regexp.MustCompile(`^(?:export|unexport)\s+(.+)$`)
+var includePattern = regexp.MustCompile(`^(?:include|-include|sinclude)\s+(.*)$`)
+var definePattern = regexp.MustCompile(`^((?:override|export|private)\s+)*define\s+([^\s:#=!?+]+)`)
+var shellFunctionPattern = regexp.MustCompile(`\$[({]\s*(call\s+)?shell\s*[\s,)}]`)
+var evalFunctionPattern = regexp.MustCompile(`\$[({]\s*(?:call\s+)?(eval|value)\s*[\s,)}]`)
+var computedCallPattern = regexp.MustCompile(`\$[({]\s*call\s+[^,)}]*\$`)
+var fileFunctionPattern = regexp.MustCompile(`\$[({]\s*(file|guile)[\s)}]`)
+var shellMetacharacterPattern = regexp.MustCompile("`|\\$\\$|;|\\||&|<|>")
+var loadPattern = regexp.MustCompile(`^-?load\s`)
+var conditionalPattern = regexp.MustCompile(`^(ifeq|ifneq|ifdef|ifndef|else|endif|endef|vpath)(\s|$)`)
+
+// IsMakefile returns true if the file of the passed path is interpreted by GNU make.
+func IsMakefile(filePath string) bool {
+	return makefileNamePattern.MatchString(path.Base(filePath))
+}
+
+// ScanFiles scans the makefile fragments in the passed files and returns the violations of the policy.
+// The path of each violation is prefixed with the passed category, like "keymap/rules.mk".
+// The files which are not makefile fragments are skipped.
+func ScanFiles(category string, files []common.BuildableFile, policy Policy) []common.ScanViolation {
+	violations := []common.ScanViolation{}
+	for _, file := range files {
+		if !IsMakefile(file.GetPath()) {
+			continue
+		}
+		// The binary encoding must not hide the makefile fragment from the scan.
+		content, err := common.DecodeContent(file)
+		if err != nil {
+			violations = append(violations, common.ScanViolation{
+				Path:    path.Join(category, file.GetPath()),
+				Rule:    RuleEncoding,
+				Message: err.Error(),
+			})
+			continue
+		}
+		violations = append(violations, ScanMakefile(path.Join(category, file.GetPath()), string(content), policy)...)
+	}
+	return violations
+}
+
+// FormatViolations formats the violations to the lines like "keyboard/rules.mk:2: the shell function is not allowed".
+func FormatViolations(violations []common.ScanViolation) string {
+	var lines []string
+	for _, violation := range violations {
+		lines = append(lines, fmt.Sprintf("%s:%d: %s", violation.Path, violation.Line, violation.Message))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ScanMakefile scans the content of a makefile fragment and returns the violations of the policy.
+// The lines continued with the "\" character are joined and reported with the number of the first line.
+func ScanMakefile(filePath string, content string, policy Policy) []common.ScanViolation {
+	violations := []common.ScanViolation{}
+	report := func(line int, rule string, message string) {
+		violations = append(violations, common.ScanViolation{
+			Path:    filePath,
+			Line:    line,
+			Rule:    rule,
+			Message: message,
+		})
+	}
+	forbiddenVariables := map[string]bool{}
+	for _, name := range policy.ForbiddenVariables {
+		forbiddenVariables[name] = true
+	}
+
+	for _, logicalLine := range splitLogicalLines(content) {
+		line := logicalLine.text
+		number := logicalLine.number
+
+		// The recipe lines start with the tab character.
+		if strings.HasPrefix(line, "\t") && strings.TrimSpace(line) != "" {
+			if !policy.AllowRecipes {
+				report(number, RuleRecipe, "recipe lines are not allowed")
+			}
+			continue
+		}
+
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+
+		if !policy.AllowShell {
+			if shellFunctionPattern.MatchString(line) {
+				report(number, RuleShell, "the shell function is not allowed")
+			}
+		}
+		if !policy.AllowEval {
+			if matches := evalFunctionPattern.FindStringSubmatch(line); matches != nil {
+				report(number, RuleEval, fmt.Sprintf("the %s function is not allowed", matches[1]))
+			}
+			// The function called with the computed name, like $(call $(FOO),id), can be any function.
+			if computedCallPattern.MatchString(line) {
+				report(number, RuleEval, "the call function with a computed name is not allowed")
+			}
+		}
+		if matches := fileFunctionPattern.FindStringSubmatch(line); matches != nil {
+			report(number, RuleFile, fmt.Sprintf("the %s function is not allowed", matches[1]))
+		}
+		if loadPattern.MatchString(line) {
+			report(number, RuleLoad, "the load directive is not allowed")
+			continue
+		}
+
+		if matches := includePattern.FindStringSubmatch(line); matches != nil {
+			if !policy.AllowExternalIncludes {
+				for _, includePath := range strings.Fields(matches[1]) {
+					if !isInternalPath(includePath) {
+						report(number, RuleInclude, fmt.Sprintf("including %s is not allowed", includePath))
+					} else if !IsMakefile(includePath) {
+						// Only the makefile fragments are scanned, so including any other file would bypass the scan.
+						report(number, RuleInclude, fmt.Sprintf("including %s is not allowed, because it is not a makefile fragment", includePath))
+					}
+				}
+			}
+			continue
+		}
+
+		// The multi-line variable definition assigns the variable as well.
+		if matches := definePattern.FindStringSubmatch(line); matches != nil {
+			name := matches[2]
+			if forbiddenVariables[name] {
+				report(number, RuleForbiddenVariable, fmt.Sprintf("defining %s is not allowed", name))
+			}
+			continue
+		}
+
+		if matches := assignmentPattern.FindStringSubmatch(line); matches != nil {
+			name := matches[2]
+			operator := matches[3]
+			if forbiddenVariables[name] {
+				report(number, RuleForbiddenVariable, fmt.Sprintf("assigning %s is not allowed", name))
+			}
+			if !policy.AllowShell {
+				if operator == "!=" {
+					report(number, RuleShell, "the shell assignment is not allowed")
+				}
+				value := line[len(matches[0]):]
+				if !policy.AllowShellMetacharacters && shellMetacharacterPattern.MatchString(value) {
+					report(number, RuleShellMetacharacter, "shell metacharacters are not allowed in values")
+				}
+			}
+			continue
+		}
+
+		if matches := exportPattern.FindStringSubmatch(line); matches != nil {
+			for _, name := range strings.Fields(matches[1]) {
+				if forbiddenVariables[name] {
+					report(number, RuleForbiddenVariable, fmt.Sprintf("exporting %s is not allowed", name))
+				}
+			}
+			continue
+		}
+
+		if conditionalPattern.MatchString(line) {
+			continue
+		}
+
+		// The remaining lines including the ":" character out of the variable references are rule definitions.
+		if containsTopLevelColon(line) && !policy.AllowRecipes {
+			report(number, RuleRecipe, "rule definitions are not allowed")
+		}
+	}
+	return violations
+}
+
+type logicalLine struct {
+	number int
+	text   string
+}
+
+// splitLogicalLines splits the content into lines, joining the lines continued with the "\" character.
+func splitLogicalLines(content string) []logicalLine {
+	var lines []logicalLine
+	var current strings.Builder
+	start := 0
+	for i, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		if current.Len() == 0 {
+			start = i + 1
+		}
+		if strings.HasSuffix(line, "\\") {
+			current.WriteString(strings.TrimSuffix(line, "\\"))
+			current.WriteString(" ")
+			continue
+		}
+		current.WriteString(line)
+		lines = append(lines, logicalLine{number: start, text: current.String()})
+		current.Reset()
+	}
+	if current.Len() > 0 {
+		lines = append(lines, logicalLine{number: start, text: current.String()})
+	}
+	return lines
+}
+
+// stripComment removes the comment starting with the "#" character which is not escaped.
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if line[i] == '#' {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// containsTopLevelColon returns true if the line includes the ":" character out of the parentheses and braces.
+func containsTopLevelColon(line string) bool {
+	depth := 0
+	for _, c := range line {
+		switch c {
+		case '(', '{':
+			depth++
+		case ')', '}':
+			if depth > 0 {
+				depth--
+			}
+		case ':':
+			if depth == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isInternalPath returns true if the path is relative and does not go out of the QMK Firmware tree.
+// The wildcard characters are not allowed, because they can match any file.
+func isInternalPath(includePath string) bool {
+	if strings.ContainsAny(includePath, "$*?[") {
+		return false
+	}
+	if path.IsAbs(includePath) || strings.HasPrefix(includePath, "~") {
+		return false
+	}
+	for _, element := range strings.Split(includePath, "/") {
+		if element == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,202 @@
+package scanner
+
+import (
+	"testing"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+func Test_IsMakefile(t *testing.T) {
+	for _, filePath := range []string{"rules.mk", "keymaps/remap/rules.mk", "post_rules.mk", "Makefile"} {
+		if !IsMakefile(filePath) {
+			t.Error("Expected true but got false for", filePath)
+		}
+	}
+	for _, filePath := range []string{"keymap.c", "config.h", "info.json", "rules.mk.txt"} {
+		if IsMakefile(filePath) {
+			t.Error("Expected false but got true for", filePath)
+		}
+	}
+}
+
+func Test_ScanMakefile_Safe(t *testing.T) {
+	content := `# MCU name
+MCU = atmega32u4
+BOOTLOADER = caterina
+
+ifeq ($(strip $(OLED_ENABLE)), yes)
+    SRC += oled.c \
+        logo.c
+endif
+OPT_DEFS += -DFOO
+include keyboards/foo/common.mk
+`
+	actual := ScanMakefile("rules.mk", content, DefaultPolicy)
+	if len(actual) != 0 {
+		t.Error("Expected no violations but got", actual)
+	}
+}
+
+func Test_ScanMakefile_Shell(t *testing.T) {
+	actual := ScanMakefile("rules.mk", "MCU = atmega32u4\nFOO := $(shell curl http://example.com)\n", DefaultPolicy)
+	assertViolations(t, actual, []common.ScanViolation{{Path: "rules.mk", Line: 2, Rule: RuleShell}})
+}
+
+func Test_ScanMakefile_ShellAssignment(t *testing.T) {
+	actual := ScanMakefile("rules.mk", "FOO != id\n", DefaultPolicy)
+	assertViolations(t, actual, []common.ScanViolation{{Path: "rules.mk", Line: 1, Rule: RuleShell}})
+}
+
+func Test_ScanMakefile_ShellMetacharacter(t *testing.T) {
+	actual := ScanMakefile("rules.mk", "OPT_DEFS += -DFOO `id`\nEXTRAFLAGS += ; rm -rf /\n", DefaultPolicy)
+	assertViolations(t, actual, []common.ScanViolation{
+		{Path: "rules.mk", Line: 1, Rule: RuleShellMetacharacter},
+		{Path: "rules.mk", Line: 2, Rule: RuleShellMetacharacter},
+	})
+}
+
+func Test_ScanMakefile_Eval(t *testing.T) {
+	actual := ScanMakefile("rules.mk", "$(eval FOO := bar)\n", DefaultPolicy)
+	assertViolations(t, actual, []common.ScanViolation{{Path: "rules.mk", Line: 1, Rule: RuleEval}})
+}
+
+func Test_ScanMakefile_Include(t *testing.T) {
+	content := "include /etc/passwd\n-include ../../foo.mk\nsinclude $(HOME)/foo.mk\n"
+	actual := ScanMakefile("rules.mk", content, DefaultPolicy)
+	assertViolations(t, actual, []common.ScanViolation{
+		{Path: "rules.mk", Line: 1, Rule: RuleInclude},
+		{Path: "rules.mk", Line: 2, Rule: RuleInclude},
+		{Path: "rules.mk", Line: 3, Rule: RuleInclude},
+	})
+}
+
+func Test_ScanMakefile_ForbiddenVariable(t *testing.T) {
+	content := "CC = /tmp/evil-gcc\noverride SHELL := /bin/evil\nexport PATH\n"
+	actual := ScanMakefile("rules.mk", content, DefaultPolicy)
+	assertViolations(t, actual, []common.ScanViolation{
+		{Path: "rules.mk", Line: 1, Rule: RuleForbiddenVariable},
+		{Path: "rules.mk", Line: 2, Rule: RuleForbiddenVariable},
+		{Path: "rules.mk", Line: 3, Rule: RuleForbiddenVariable},
+	})
+}
+
+func Test_ScanMakefile_Recipe(t *testing.T) {
+	content := "all: foo\n\tcurl http://example.com\n"
+	actual := ScanMakefile("rules.mk", content, DefaultPolicy)
+	assertViolations(t, actual, []common.ScanViolation{
+		{Path: "rules.mk", Line: 1, Rule: RuleRecipe},
+		{Path: "rules.mk", Line: 2, Rule: RuleRecipe},
+	})
+}
+
+func Test_ScanMakefile_ContinuedLine(t *testing.T) {
+	content := "SRC += foo.c\nFOO = bar \\\n    $(shell id)\n"
+	actual := ScanMakefile("rules.mk", content, DefaultPolicy)
+	assertViolations(t, actual, []common.ScanViolation{{Path: "rules.mk", Line: 2, Rule: RuleShell}})
+}
+
+func Test_ScanMakefile_Comment(t *testing.T) {
+	actual := ScanMakefile("rules.mk", "# FOO := $(shell id)\nMCU = RP2040 # $(shell id)\n", DefaultPolicy)
+	if len(actual) != 0 {
+		t.Error("Expected no violations but got", actual)
+	}
+}
+
+func Test_ScanMakefile_AllowedByPolicy(t *testing.T) {
+	policy := Policy{AllowShell: true, AllowEval: true, AllowRecipes: true, AllowExternalIncludes: true}
+	content := "FOO := $(shell id)\n$(eval BAR := baz)\ninclude /foo.mk\nall:\n\techo foo\n"
+	actual := ScanMakefile("rules.mk", content, policy)
+	if len(actual) != 0 {
+		t.Error("Expected no violations but got", actual)
+	}
+}
+
+func Test_ScanMakefile_OwnerPolicy(t *testing.T) {
+	content := "include $(KEYBOARD_PATH_1)/common.mk\nOPT_DEFS += -DFOO=\"a|b\"\n"
+	actual := ScanMakefile("rules.mk", content, OwnerPolicy)
+	if len(actual) != 0 {
+		t.Error("Expected no violations but got", actual)
+	}
+	content = "FOO := $(shell id)\nCC = gcc\n"
+	actual = ScanMakefile("rules.mk", content, OwnerPolicy)
+	assertViolations(t, actual, []common.ScanViolation{
+		{Path: "rules.mk", Line: 1, Rule: RuleShell},
+		{Path: "rules.mk", Line: 2, Rule: RuleForbiddenVariable},
+	})
+}
+
+func Test_ScanFiles_SkipsNonMakefiles(t *testing.T) {
+	files := []common.BuildableFile{
+		common.FirmwareFile{Path: "keymap.c", Content: "FOO := $(shell id)"},
+		common.FirmwareFile{Path: "rules.mk", Content: "FOO := $(shell id)"},
+	}
+	actual := ScanFiles("keymap", files, DefaultPolicy)
+	assertViolations(t, actual, []common.ScanViolation{{Path: "keymap/rules.mk", Line: 1, Rule: RuleShell}})
+}
+
+func Test_ScanFiles_Base64(t *testing.T) {
+	files := []common.BuildableFile{
+		// "FOO := $(shell id)"
+		common.FirmwareFile{Path: "rules.mk", Content: "Rk9PIDo9ICQoc2hlbGwgaWQp", Encoding: common.FileEncodingBase64},
+		common.FirmwareFile{Path: "post_rules.mk", Content: "not base64!", Encoding: common.FileEncodingBase64},
+	}
+	actual := ScanFiles("keymap", files, DefaultPolicy)
+	assertViolations(t, actual, []common.ScanViolation{
+		{Path: "keymap/rules.mk", Line: 1, Rule: RuleShell},
+		{Path: "keymap/post_rules.mk", Line: 0, Rule: RuleEncoding},
+	})
+}
+
+func Test_FormatViolations(t *testing.T) {
+	actual := FormatViolations([]common.ScanViolation{
+		{Path: "keyboard/rules.mk", Line: 2, Rule: RuleShell, Message: "the shell function is not allowed"},
+		{Path: "keymap/rules.mk", Line: 5, Rule: RuleRecipe, Message: "recipe lines are not allowed"},
+	})
+	expected := "keyboard/rules.mk:2: the shell function is not allowed\nkeymap/rules.mk:5: recipe lines are not allowed"
+	if actual != expected {
+		t.Error("Expected", expected, "but got", actual)
+	}
+}
+
+func assertViolations(t *testing.T, actual []common.ScanViolation, expected []common.ScanViolation) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatal("Expected", expected, "but got", actual)
+	}
+	for i := range expected {
+		if actual[i].Path != expected[i].Path || actual[i].Line != expected[i].Line || actual[i].Rule != expected[i].Rule {
+			t.Error("Expected", expected[i], "but got", actual[i])
+		}
+		if actual[i].Message == "" {
+			t.Error("Expected the message but got empty string")
+		}
+	}
+}
+
+func Test_ScanMakefile_IncludeNonMakefile(t *testing.T) {
+	content := "include keyboards/foo/evil.txt\n-include keyboards/foo/*\ninclude keyboards/foo/common.mk\n"
+	actual := ScanMakefile("rules.mk", content, DefaultPolicy)
+	assertViolations(t, actual, []common.ScanViolation{
+		{Path: "rules.mk", Line: 1, Rule: RuleInclude},
+		{Path: "rules.mk", Line: 2, Rule: RuleInclude},
+	})
+}
+
+func Test_ScanMakefile_Define(t *testing.T) {
+	content := "define SHELL\n/bin/evil\nendef\noverride define CC =\n/tmp/evil-gcc\nendef\ndefine MY_SOURCES\nfoo.c\nendef\n"
+	actual := ScanMakefile("rules.mk", content, DefaultPolicy)
+	assertViolations(t, actual, []common.ScanViolation{
+		{Path: "rules.mk", Line: 1, Rule: RuleForbiddenVariable},
+		{Path: "rules.mk", Line: 4, Rule: RuleForbiddenVariable},
+	})
+}
+
+func Test_ScanMakefile_Call(t *testing.T) {
+	content := "FOO := $(call shell,id)\n$(call eval,BAR := baz)\nBAZ := $(call $(FUNC),id)\nQUX := $(call my_function,id)\n"
+	actual := ScanMakefile("rules.mk", content, DefaultPolicy)
+	assertViolations(t, actual, []common.ScanViolation{
+		{Path: "rules.mk", Line: 1, Rule: RuleShell},
+		{Path: "rules.mk", Line: 2, Rule: RuleEval},
+		{Path: "rules.mk", Line: 3, Rule: RuleEval},
+	})
+}
@@ -0,0 +1,330 @@
+package versions
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+// DefaultMirrorBaseDirectoryPath is the directory holding the bare-repo mirrors of the QMK Firmware forks.
+// The mirror of "https://github.com/<owner>/<repo>" is placed at "<owner>/<repo>.git".
+const DefaultMirrorBaseDirectoryPath string = "/root/mirrors/"
+
+// SourceFileName is the name of the file describing the source of the materialized tree.
+const SourceFileName string = ".remap-source.json"
+
+// DefaultSourceRef is the ref used when the ref is not specified.
+const DefaultSourceRef string = "HEAD"
+
+// DefaultMaxForkTrees is the maximum number of the trees materialized from the mirrors kept on the disk.
+// The least recently used trees are evicted when the number exceeds it.
+const DefaultMaxForkTrees = 4
+
+const forkVersionNamePrefix = "fork-"
+
+// requirementsInstallCommand installs the Python packages required by the tree. The packages are installed into
+// the directory in the tree instead of the site-packages shared with the other trees, and only the prebuilt wheels
+// are allowed, so no code in the fork runs while installing them.
+// The arguments "--target <directory> -r <requirements file>" are appended.
+var requirementsInstallCommand = []string{"python3", "-m", "pip", "install", "--quiet", "--only-binary=:all:"}
+
+var repositoryPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*/[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+var refPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_./-]*$`)
+var forkVersionNamePattern = regexp.MustCompile(`^fork-[A-Za-z0-9_.-]+-[0-9a-f]{12}$`)
+
+// materializeMutex protects the users of the materialized trees and the registration and the eviction of the trees.
+// It is not held while the tree is checked out, so the other trees can be acquired and released meanwhile.
+var materializeMutex sync.Mutex
+
+var materializeLocksMutex sync.Mutex
+var materializeLocks = map[string]*materializeLock{}
+
+// materializeLock serializes the materialization of the same tree. users is the number of the materializations
+// holding or waiting for it.
+type materializeLock struct {
+	mutex sync.Mutex
+	users int
+}
+
+type source struct {
+	Repository string `json:"repository"`
+	Commit     string `json:"commit"`
+}
+
+// ValidateSourceRepository checks whether the repository is a form of "<owner>/<repo>".
+func ValidateSourceRepository(repository string) error {
+	if !repositoryPattern.MatchString(repository) || strings.Contains(repository, "..") {
+		return fmt.Errorf("invalid source repository: %s", repository)
+	}
+	return nil
+}
+
+// ValidateSourceRef checks whether the ref can be passed to git safely.
+func ValidateSourceRef(ref string) error {
+	if len(ref) > 255 || !refPattern.MatchString(ref) || strings.Contains(ref, "..") {
+		return fmt.Errorf("invalid source ref: %s", ref)
+	}
+	return nil
+}
+
+// Materialize creates the QMK Firmware tree of the ref in the repository from the local mirror, and registers it.
+// The tree is named from the repository and the commit, so the materialized tree is reused for the same commit.
+// The submodules are also fetched from the mirrors, and the Python packages in the requirements file are installed.
+// The tree is used by the build until Release is called, so it is not evicted during the build.
+// The different trees are materialized concurrently, but the same tree is materialized only once.
+func (r *Registry) Materialize(repository string, ref string) (*Version, error) {
+	if ref == "" {
+		ref = DefaultSourceRef
+	}
+	err := ValidateSourceRepository(repository)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateSourceRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	mirrorDirectoryPath := filepath.Join(r.mirrorBaseDirectoryPath, repository+".git")
+	_, err = os.Stat(mirrorDirectoryPath)
+	if err != nil {
+		return nil, fmt.Errorf("the mirror of the source repository %s does not exist", repository)
+	}
+	output, err := exec.Command("git", "--git-dir", mirrorDirectoryPath, "rev-parse", "--verify", "--quiet", ref+"^{commit}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("the ref %s does not exist in the source repository %s", ref, repository)
+	}
+	commit := strings.TrimSpace(string(output))
+	name := createForkVersionName(repository, commit)
+
+	acquireMaterializeLock(name)
+	defer releaseMaterializeLock(name)
+	materializeMutex.Lock()
+	version, err := r.Lookup(name)
+	if err == nil {
+		log.Printf("[INFO] The QMK Firmware tree [%s] has already been materialized.\n", name)
+		r.acquireForkTree(name)
+		materializeMutex.Unlock()
+		return version, nil
+	}
+	materializeMutex.Unlock()
+
+	log.Printf("[INFO] Materializing the QMK Firmware tree [%s] from %s at %s.\n", name, repository, ref)
+	temporaryDirectoryPath := filepath.Join(r.baseDirectoryPath, ".tmp-"+name)
+	err = os.RemoveAll(temporaryDirectoryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(temporaryDirectoryPath)
+	err = r.checkoutTree(mirrorDirectoryPath, commit, temporaryDirectoryPath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(source{Repository: repository, Commit: commit})
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(filepath.Join(temporaryDirectoryPath, SourceFileName), content, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = installRequirements(temporaryDirectoryPath)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(temporaryDirectoryPath, filepath.Join(r.baseDirectoryPath, name))
+	if err != nil {
+		return nil, err
+	}
+
+	version = &Version{
+		Name:        name,
+		ReleaseDate: fetchReleaseDate(filepath.Join(r.baseDirectoryPath, name)),
+		Repository:  repository,
+		Commit:      commit,
+	}
+	materializeMutex.Lock()
+	defer materializeMutex.Unlock()
+	r.mutex.Lock()
+	r.versions[name] = version
+	r.mutex.Unlock()
+	r.acquireForkTree(name)
+	r.evictForkTrees()
+	result := *version
+	return &result, nil
+}
+
+// installRequirements installs the Python packages in the requirements file of the tree into the packages directory
+// of the tree. The tree without the requirements file needs nothing.
+func installRequirements(treeDirectoryPath string) error {
+	requirementsFilePath := filepath.Join(treeDirectoryPath, "requirements.txt")
+	if _, err := os.Stat(requirementsFilePath); os.IsNotExist(err) {
+		return nil
+	}
+	log.Printf("[INFO] Installing the requirements: %s\n", requirementsFilePath)
+	packagesDirectoryPath := filepath.Join(treeDirectoryPath, common.PythonPackagesDirectoryName)
+	args := append(append([]string{}, requirementsInstallCommand[1:]...),
+		"--target", packagesDirectoryPath, "-r", requirementsFilePath)
+	output, err := exec.Command(requirementsInstallCommand[0], args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("installing the requirements failed: %s: %s", err.Error(), strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func acquireMaterializeLock(name string) {
+	materializeLocksMutex.Lock()
+	lock, ok := materializeLocks[name]
+	if !ok {
+		lock = &materializeLock{}
+		materializeLocks[name] = lock
+	}
+	lock.users++
+	materializeLocksMutex.Unlock()
+	lock.mutex.Lock()
+}
+
+func releaseMaterializeLock(name string) {
+	materializeLocksMutex.Lock()
+	defer materializeLocksMutex.Unlock()
+	lock := materializeLocks[name]
+	lock.mutex.Unlock()
+	lock.users--
+	if lock.users == 0 {
+		delete(materializeLocks, name)
+	}
+}
+
+// acquireForkTree marks the materialized tree as used by a build and as used now. It must be called with materializeMutex.
+func (r *Registry) acquireForkTree(name string) {
+	now := time.Now()
+	err := os.Chtimes(filepath.Join(r.baseDirectoryPath, name), now, now)
+	if err != nil {
+		log.Printf("[ERROR] %s\n", err.Error())
+	}
+	r.forkTreeUsers[name]++
+}
+
+// Release marks the tree as not used by the build. It does nothing for the upstream trees.
+func (r *Registry) Release(name string) {
+	materializeMutex.Lock()
+	defer materializeMutex.Unlock()
+	if _, ok := r.forkTreeUsers[name]; !ok {
+		return
+	}
+	r.forkTreeUsers[name]--
+	if r.forkTreeUsers[name] <= 0 {
+		delete(r.forkTreeUsers, name)
+	}
+}
+
+// evictForkTrees removes the least recently used trees materialized from the mirrors over the maximum number,
+// and unregisters them. The trees used by the running builds are not removed. It must be called with materializeMutex.
+func (r *Registry) evictForkTrees() {
+	type entry struct {
+		name    string
+		modTime time.Time
+	}
+	var entries []entry
+	for _, version := range r.List() {
+		if version.Repository == "" {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(r.baseDirectoryPath, version.Name))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry{name: version.Name, modTime: info.ModTime()})
+	}
+	if len(entries) <= r.maxForkTrees {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	count := len(entries)
+	for _, e := range entries {
+		if count <= r.maxForkTrees {
+			break
+		}
+		if _, ok := r.forkTreeUsers[e.name]; ok {
+			continue
+		}
+		log.Printf("[INFO] Evicting the QMK Firmware tree: %s\n", e.name)
+		r.mutex.Lock()
+		delete(r.versions, e.name)
+		r.mutex.Unlock()
+		err := os.RemoveAll(filepath.Join(r.baseDirectoryPath, e.name))
+		if err != nil {
+			log.Printf("[ERROR] %s\n", err.Error())
+			continue
+		}
+		count--
+	}
+}
+
+// checkoutTree checks out the commit with the submodules into the directory,
+// and removes the keyboards in the same way as the upstream trees in the Dockerfile.
+func (r *Registry) checkoutTree(mirrorDirectoryPath string, commit string, directoryPath string) error {
+	commands := [][]string{
+		{"git", "clone", "--quiet", "--no-checkout", mirrorDirectoryPath, directoryPath},
+		{"git", "-C", directoryPath, "checkout", "--quiet", "--detach", commit},
+		{"git", "-C", directoryPath,
+			"-c", "protocol.file.allow=always",
+			"-c", "url." + filepath.Clean(r.mirrorBaseDirectoryPath) + "/.insteadOf=https://github.com/",
+			"submodule", "update", "--quiet", "--init", "--recursive"},
+	}
+	for _, command := range commands {
+		output, err := exec.Command(command[0], command[1:]...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("materializing the QMK Firmware tree failed: %s: %s", err.Error(), strings.TrimSpace(string(output)))
+		}
+	}
+	keyboardsDirectoryPath := filepath.Join(directoryPath, "keyboards")
+	entries, err := os.ReadDir(keyboardsDirectoryPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		err = os.RemoveAll(filepath.Join(keyboardsDirectoryPath, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	keyboardAliasesFilePath := filepath.Join(directoryPath, "data", "mappings", "keyboard_aliases.hjson")
+	if _, err := os.Stat(keyboardAliasesFilePath); err == nil {
+		err = os.WriteFile(keyboardAliasesFilePath, []byte("{}\n"), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createForkVersionName creates the directory name of the tree materialized from the repository.
+func createForkVersionName(repository string, commit string) string {
+	return forkVersionNamePrefix + strings.ReplaceAll(repository, "/", "-") + "-" + commit[:12]
+}
+
+// loadSource loads the source of the materialized tree. It returns nil if the tree is not materialized.
+func loadSource(treeDirectoryPath string) *source {
+	content, err := os.ReadFile(filepath.Join(treeDirectoryPath, SourceFileName))
+	if err != nil {
+		return nil
+	}
+	var result source
+	err = json.Unmarshal(content, &result)
+	if err != nil {
+		return nil
+	}
+	return &result
+}
@@ -0,0 +1,227 @@
+package versions
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"remap-keys.app/remap-build-server/common"
+)
+
+func runGit(t *testing.T, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(err, string(output))
+	}
+	return string(output)
+}
+
+func createMirror(t *testing.T, mirrorBaseDirectoryPath string, repository string) {
+	t.Helper()
+	workDirectoryPath := t.TempDir()
+	runGit(t, "-C", workDirectoryPath, "init", "--quiet")
+	err := os.MkdirAll(filepath.Join(workDirectoryPath, "keyboards", "foo"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(workDirectoryPath, "keyboards", "foo", "rules.mk"), []byte(""), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(workDirectoryPath, "Makefile"), []byte("all:\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "-C", workDirectoryPath, "add", "-A")
+	runGit(t, "-C", workDirectoryPath, "commit", "--quiet", "-m", "initial")
+	runGit(t, "-C", workDirectoryPath, "tag", "v1")
+	runGit(t, "clone", "--quiet", "--bare", workDirectoryPath, filepath.Join(mirrorBaseDirectoryPath, repository+".git"))
+}
+
+func Test_Registry_Materialize(t *testing.T) {
+	baseDirectoryPath := createBaseDirectory(t, "0.22.14")
+	mirrorBaseDirectoryPath := t.TempDir()
+	createMirror(t, mirrorBaseDirectoryPath, "vial-kb/vial-qmk")
+	registry := NewRegistry(baseDirectoryPath, mirrorBaseDirectoryPath)
+	err := registry.Discover()
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+
+	actual, err := registry.Materialize("vial-kb/vial-qmk", "v1")
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if actual.Repository != "vial-kb/vial-qmk" {
+		t.Error("Expected vial-kb/vial-qmk but got", actual.Repository)
+	}
+	if !forkVersionNamePattern.MatchString(actual.Name) {
+		t.Error("Expected the fork version name but got", actual.Name)
+	}
+	treeDirectoryPath := filepath.Join(baseDirectoryPath, actual.Name)
+	if _, err := os.Stat(filepath.Join(treeDirectoryPath, "Makefile")); err != nil {
+		t.Error("Expected the Makefile but got", err)
+	}
+	if _, err := os.Stat(filepath.Join(treeDirectoryPath, "keyboards", "foo")); !os.IsNotExist(err) {
+		t.Error("Expected the keyboards to be removed but got", err)
+	}
+	if _, err := registry.Lookup(actual.Name); err != nil {
+		t.Error("Expected nil but got", err)
+	}
+	if _, err := registry.Resolve(actual.Name, time.Now()); err == nil {
+		t.Error("Expected the fork tree not to be resolved directly but got nil")
+	}
+
+	again, err := registry.Materialize("vial-kb/vial-qmk", "")
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if again.Name != actual.Name {
+		t.Error("Expected", actual.Name, "but got", again.Name)
+	}
+	registry.Release(actual.Name)
+	registry.Release(again.Name)
+
+	// The materialized tree is found by the discovery, but it is not an alias target.
+	rediscovered := NewRegistry(baseDirectoryPath, mirrorBaseDirectoryPath)
+	err = rediscovered.Discover()
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	list := rediscovered.List()
+	if len(list) != 2 || list[0].Name != "0.22.14" || list[1].Name != actual.Name {
+		t.Error("Expected [0.22.14", actual.Name, "] but got", list)
+	}
+	resolution, err := rediscovered.Resolve(AliasLatest, time.Now())
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if resolution.Version.Name != "0.22.14" {
+		t.Error("Expected 0.22.14 but got", resolution.Version.Name)
+	}
+}
+
+func Test_Registry_Materialize_Invalid(t *testing.T) {
+	mirrorBaseDirectoryPath := t.TempDir()
+	createMirror(t, mirrorBaseDirectoryPath, "vial-kb/vial-qmk")
+	registry := NewRegistry(t.TempDir(), mirrorBaseDirectoryPath)
+	cases := []struct {
+		repository string
+		ref        string
+	}{
+		{"../vial-kb/vial-qmk", "v1"},
+		{"vial-kb/..", "v1"},
+		{"vial-kb", "v1"},
+		{"qmk/qmk_firmware", "v1"},
+		{"vial-kb/vial-qmk", "--upload-pack=evil"},
+		{"vial-kb/vial-qmk", "v1..v2"},
+		{"vial-kb/vial-qmk", "unknown"},
+	}
+	for _, c := range cases {
+		_, err := registry.Materialize(c.repository, c.ref)
+		if err == nil {
+			t.Error("Expected error but got nil for", c.repository, c.ref)
+		}
+	}
+}
+
+func Test_Registry_Materialize_Requirements(t *testing.T) {
+	mirrorBaseDirectoryPath := t.TempDir()
+	createMirror(t, mirrorBaseDirectoryPath, "vial-kb/vial-qmk")
+	registry := NewRegistry(t.TempDir(), mirrorBaseDirectoryPath)
+	markerFilePath := filepath.Join(t.TempDir(), "installed")
+	original := requirementsInstallCommand
+	defer func() { requirementsInstallCommand = original }()
+	// The arguments are "--target <directory> -r <requirements file>".
+	requirementsInstallCommand = []string{"sh", "-c", `mkdir "$2" && cp "$4" "$0"`, markerFilePath}
+
+	// The tree without the requirements file needs nothing.
+	actual, err := registry.Materialize("vial-kb/vial-qmk", "v1")
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	registry.Release(actual.Name)
+	if _, err := os.Stat(markerFilePath); !os.IsNotExist(err) {
+		t.Error("Expected the requirements not to be installed but got", err)
+	}
+
+	treeDirectoryPath := t.TempDir()
+	err = os.WriteFile(filepath.Join(treeDirectoryPath, "requirements.txt"), []byte("hjson\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = installRequirements(treeDirectoryPath)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	content, err := os.ReadFile(markerFilePath)
+	if err != nil || string(content) != "hjson\n" {
+		t.Error("Expected the requirements to be installed but got", string(content), err)
+	}
+	if _, err := os.Stat(filepath.Join(treeDirectoryPath, common.PythonPackagesDirectoryName)); err != nil {
+		t.Error("Expected the packages directory in the tree but got", err)
+	}
+
+	requirementsInstallCommand = []string{"false"}
+	err = installRequirements(treeDirectoryPath)
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_Registry_Materialize_Eviction(t *testing.T) {
+	baseDirectoryPath := createBaseDirectory(t, "0.22.14")
+	mirrorBaseDirectoryPath := t.TempDir()
+	repositories := []string{"vial-kb/vial-qmk", "foo/qmk", "bar/qmk"}
+	for _, repository := range repositories {
+		createMirror(t, mirrorBaseDirectoryPath, repository)
+	}
+	registry := NewRegistry(baseDirectoryPath, mirrorBaseDirectoryPath)
+	registry.maxForkTrees = 1
+	err := registry.Discover()
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+
+	// The first tree is still used by the build, so it is not evicted.
+	first, err := registry.Materialize(repositories[0], "v1")
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	second, err := registry.Materialize(repositories[1], "v1")
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	registry.Release(second.Name)
+	if _, err := registry.Lookup(first.Name); err != nil {
+		t.Error("Expected the used tree to be kept but got", err)
+	}
+
+	registry.Release(first.Name)
+	third, err := registry.Materialize(repositories[2], "v1")
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	defer registry.Release(third.Name)
+	for _, name := range []string{first.Name, second.Name} {
+		if _, err := registry.Lookup(name); err == nil {
+			t.Error("Expected", name, "to be evicted but got nil")
+		}
+		if _, err := os.Stat(filepath.Join(baseDirectoryPath, name)); !os.IsNotExist(err) {
+			t.Error("Expected", name, "to be removed but got", err)
+		}
+	}
+	if _, err := registry.Lookup(third.Name); err != nil {
+		t.Error("Expected nil but got", err)
+	}
+	if _, err := registry.Lookup("0.22.14"); err != nil {
+		t.Error("Expected the upstream tree to be kept but got", err)
+	}
+}
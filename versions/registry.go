@@ -0,0 +1,364 @@
+package versions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetadataFileName is the name of the optional file in the base directory describing the versions.
+// For instance:
+//
+//	{
+//	  "versions": {"0.22.14": {"releaseDate": "2023-11-26", "deprecated": true}},
+//	  "aliases": {"stable": "0.28.3"},
+//	  "retired": {"0.19.3": {"successor": "0.22.14", "sunsetDate": "2026-12-31"}}
+//	}
+const MetadataFileName string = "versions.json"
+
+const (
+	// AliasLatest is resolved to the newest installed version.
+	AliasLatest string = "latest"
+	// AliasStable is resolved to the version configured in the metadata file,
+	// or the newest installed version which is not deprecated.
+	AliasStable string = "stable"
+)
+
+const sunsetDateLayout = "2006-01-02"
+
+var versionNamePattern = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+
+// Version represents a QMK Firmware tree installed in the base directory.
+type Version struct {
+	Name        string `json:"name"`
+	ReleaseDate string `json:"releaseDate"`
+	Deprecated  bool   `json:"deprecated"`
+	// Repository and Commit are the source of the tree materialized from the mirror.
+	// They are empty for the upstream trees.
+	Repository string `json:"repository,omitempty"`
+	Commit     string `json:"commit,omitempty"`
+}
+
+// Retirement represents a version removed from the base directory.
+// The builds for the retired version are redirected to the successor until the sunset date.
+type Retirement struct {
+	Successor  string `json:"successor"`
+	SunsetDate string `json:"sunsetDate"`
+}
+
+// Resolution represents the result of resolving the requested version.
+type Resolution struct {
+	Requested string
+	Version   Version
+	Warnings  []string
+}
+
+// TreeNotInstalledError represents the version which is configured in the metadata file or was discovered,
+// but whose tree is not in the base directory. It is a failure of the build environment, not of the requested version.
+type TreeNotInstalledError struct {
+	Name string
+}
+
+func (e *TreeNotInstalledError) Error() string {
+	return fmt.Sprintf("the tree of QMK Firmware version %s is not installed", e.Name)
+}
+
+type metadata struct {
+	ReleaseDate string `json:"releaseDate"`
+	Deprecated  bool   `json:"deprecated"`
+}
+
+type metadataFile struct {
+	Versions map[string]metadata   `json:"versions"`
+	Aliases  map[string]string     `json:"aliases"`
+	Retired  map[string]Retirement `json:"retired"`
+}
+
+// Registry holds the QMK Firmware versions which can be used for building.
+type Registry struct {
+	mutex                   sync.RWMutex
+	baseDirectoryPath       string
+	mirrorBaseDirectoryPath string
+	versions                map[string]*Version
+	// configured is the names of the versions referred by the metadata file, including the aliased versions
+	// and the successors of the retired versions.
+	configured map[string]bool
+	aliases    map[string]string
+	retired    map[string]Retirement
+	// forkTreeUsers is the number of the running builds using each tree materialized from the mirrors.
+	forkTreeUsers map[string]int
+	// maxForkTrees is the maximum number of the trees materialized from the mirrors kept on the disk.
+	maxForkTrees int
+}
+
+// NewRegistry creates a registry for the QMK Firmware trees in the passed base directory.
+// The trees of the forks are materialized from the mirrors in the passed mirror base directory.
+// Call Discover to find the installed trees.
+func NewRegistry(baseDirectoryPath string, mirrorBaseDirectoryPath string) *Registry {
+	return &Registry{
+		baseDirectoryPath:       baseDirectoryPath,
+		mirrorBaseDirectoryPath: mirrorBaseDirectoryPath,
+		versions:                map[string]*Version{},
+		configured:              map[string]bool{},
+		forkTreeUsers:           map[string]int{},
+		maxForkTrees:            DefaultMaxForkTrees,
+		aliases:                 map[string]string{},
+		retired:                 map[string]Retirement{},
+	}
+}
+
+// Discover finds the QMK Firmware trees installed in the base directory and replaces the registered versions.
+// The directory is treated as a tree if its name is a form of "X.Y.Z", or it is a tree materialized from the mirror.
+func (r *Registry) Discover() error {
+	log.Println("Discovering the QMK Firmware versions.")
+	entries, err := os.ReadDir(r.baseDirectoryPath)
+	if err != nil {
+		return err
+	}
+	metadata, err := r.loadMetadata()
+	if err != nil {
+		return err
+	}
+	versions := map[string]*Version{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		version := &Version{Name: entry.Name()}
+		if forkVersionNamePattern.MatchString(entry.Name()) {
+			source := loadSource(filepath.Join(r.baseDirectoryPath, entry.Name()))
+			if source == nil {
+				continue
+			}
+			version.Repository = source.Repository
+			version.Commit = source.Commit
+		} else if !versionNamePattern.MatchString(entry.Name()) {
+			continue
+		}
+		if m, ok := metadata.Versions[entry.Name()]; ok {
+			version.ReleaseDate = m.ReleaseDate
+			version.Deprecated = m.Deprecated
+		}
+		if version.ReleaseDate == "" {
+			version.ReleaseDate = fetchReleaseDate(filepath.Join(r.baseDirectoryPath, entry.Name()))
+		}
+		log.Printf("[INFO] Found the QMK Firmware version: %+v\n", *version)
+		versions[entry.Name()] = version
+	}
+	configured := map[string]bool{}
+	for name := range metadata.Versions {
+		configured[name] = true
+	}
+	for _, name := range metadata.Aliases {
+		configured[name] = true
+	}
+	for _, retirement := range metadata.Retired {
+		configured[retirement.Successor] = true
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.versions = versions
+	r.configured = configured
+	r.aliases = metadata.Aliases
+	r.retired = metadata.Retired
+	return nil
+}
+
+// loadMetadata loads the metadata file. If the file does not exist, it returns the empty metadata.
+func (r *Registry) loadMetadata() (*metadataFile, error) {
+	result := &metadataFile{
+		Versions: map[string]metadata{},
+		Aliases:  map[string]string{},
+		Retired:  map[string]Retirement{},
+	}
+	content, err := os.ReadFile(filepath.Join(r.baseDirectoryPath, MetadataFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return result, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(content, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// fetchReleaseDate fetches the date of the commit checked out in the tree as the release date.
+// It returns the empty string if the date cannot be fetched.
+func fetchReleaseDate(treeDirectoryPath string) string {
+	output, err := exec.Command("git", "-C", treeDirectoryPath, "log", "-1", "--format=%cs").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// Lookup returns the registered version. It returns an error if the version is not registered,
+// so the passed string can be used as the directory name of the tree safely.
+func (r *Registry) Lookup(name string) (*Version, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	version, ok := r.versions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown QMK Firmware version: %s", name)
+	}
+	result := *version
+	return &result, nil
+}
+
+// Resolve resolves the requested version to the installed version.
+//   - The aliases "latest" and "stable", and the aliases in the metadata file are resolved to the version.
+//   - The retired version is redirected to the successor with the warning until the sunset date.
+//     After the sunset date, an error is returned.
+//   - The deprecated version is used with the warning.
+//
+// An unknown version returns an error. The configured version whose tree is missing returns a TreeNotInstalledError.
+func (r *Registry) Resolve(name string, now time.Time) (*Resolution, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	resolution := &Resolution{Requested: name}
+	resolvedName := name
+	if alias, ok := r.resolveAlias(name); ok {
+		resolvedName = alias
+	}
+	if retirement, ok := r.retired[resolvedName]; ok {
+		sunsetDate, err := time.Parse(sunsetDateLayout, retirement.SunsetDate)
+		if err != nil {
+			return nil, err
+		}
+		if !now.Before(sunsetDate) {
+			return nil, fmt.Errorf("QMK Firmware version %s was retired on %s. Use %s or newer", resolvedName, retirement.SunsetDate, retirement.Successor)
+		}
+		resolution.Warnings = append(resolution.Warnings, fmt.Sprintf(
+			"QMK Firmware version %s is retired, so %s is used instead. Building with %s will be refused from %s",
+			resolvedName, retirement.Successor, resolvedName, retirement.SunsetDate))
+		resolvedName = retirement.Successor
+	}
+	// The trees of the forks are acquired and released by Materialize, so they must not be used directly.
+	if forkVersionNamePattern.MatchString(resolvedName) {
+		return nil, fmt.Errorf("unknown QMK Firmware version: %s", name)
+	}
+	version, ok := r.versions[resolvedName]
+	if !ok {
+		if r.configured[resolvedName] {
+			return nil, &TreeNotInstalledError{Name: resolvedName}
+		}
+		return nil, fmt.Errorf("unknown QMK Firmware version: %s", name)
+	}
+	// The tree may be removed after it was discovered.
+	_, err := os.Stat(filepath.Join(r.baseDirectoryPath, resolvedName))
+	if err != nil {
+		return nil, &TreeNotInstalledError{Name: resolvedName}
+	}
+	if version.Deprecated {
+		resolution.Warnings = append(resolution.Warnings, fmt.Sprintf("QMK Firmware version %s is deprecated", resolvedName))
+	}
+	resolution.Version = *version
+	return resolution, nil
+}
+
+// resolveAlias resolves the alias to the version name. It must be called with the lock.
+func (r *Registry) resolveAlias(name string) (string, bool) {
+	if alias, ok := r.aliases[name]; ok {
+		return alias, true
+	}
+	switch name {
+	case AliasLatest:
+		return r.newestVersionName(false)
+	case AliasStable:
+		return r.newestVersionName(true)
+	}
+	return "", false
+}
+
+// newestVersionName returns the newest registered upstream version name. It must be called with the lock.
+func (r *Registry) newestVersionName(excludeDeprecated bool) (string, bool) {
+	var newest string
+	for name, version := range r.versions {
+		if version.Repository != "" || (excludeDeprecated && version.Deprecated) {
+			continue
+		}
+		if newest == "" || CompareVersionNames(name, newest) > 0 {
+			newest = name
+		}
+	}
+	return newest, newest != ""
+}
+
+// Aliases returns the aliases and the versions which they are resolved to.
+func (r *Registry) Aliases() map[string]string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	result := map[string]string{}
+	for _, name := range []string{AliasLatest, AliasStable} {
+		if version, ok := r.resolveAlias(name); ok {
+			result[name] = version
+		}
+	}
+	for name, version := range r.aliases {
+		result[name] = version
+	}
+	return result
+}
+
+// Retired returns the retired versions.
+func (r *Registry) Retired() map[string]Retirement {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	result := map[string]Retirement{}
+	for name, retirement := range r.retired {
+		result[name] = retirement
+	}
+	return result
+}
+
+// List returns all registered versions. The upstream versions are sorted from the newest,
+// and followed by the trees materialized from the mirrors.
+func (r *Registry) List() []Version {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	result := make([]Version, 0, len(r.versions))
+	for _, version := range r.versions {
+		result = append(result, *version)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if (result[i].Repository == "") != (result[j].Repository == "") {
+			return result[i].Repository == ""
+		}
+		return CompareVersionNames(result[i].Name, result[j].Name) > 0
+	})
+	return result
+}
+
+// CompareVersionNames compares the version names numerically.
+// It returns a negative number if a < b, zero if a == b and a positive number if a > b.
+func CompareVersionNames(a string, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNumber, aErr := strconv.Atoi(aParts[i])
+		bNumber, bErr := strconv.Atoi(bParts[i])
+		if aErr != nil || bErr != nil {
+			if c := strings.Compare(aParts[i], bParts[i]); c != 0 {
+				return c
+			}
+			continue
+		}
+		if aNumber != bNumber {
+			return aNumber - bNumber
+		}
+	}
+	return len(aParts) - len(bParts)
+}
@@ -0,0 +1,239 @@
+package versions
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createBaseDirectory(t *testing.T, names ...string) string {
+	t.Helper()
+	baseDirectoryPath := t.TempDir()
+	for _, name := range names {
+		err := os.MkdirAll(filepath.Join(baseDirectoryPath, name), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return baseDirectoryPath
+}
+
+func Test_Registry_Discover(t *testing.T) {
+	baseDirectoryPath := createBaseDirectory(t, "0.22.14", "0.28.3", "0.32.8", "foo", ".build")
+	registry := NewRegistry(baseDirectoryPath, "")
+	err := registry.Discover()
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	actual := registry.List()
+	if len(actual) != 3 {
+		t.Fatal("Expected 3 but got", len(actual))
+	}
+	expected := []string{"0.32.8", "0.28.3", "0.22.14"}
+	for i, name := range expected {
+		if actual[i].Name != name {
+			t.Error("Expected", name, "but got", actual[i].Name)
+		}
+	}
+}
+
+func Test_Registry_Discover_WithMetadata(t *testing.T) {
+	baseDirectoryPath := createBaseDirectory(t, "0.22.14", "0.28.3")
+	err := os.WriteFile(filepath.Join(baseDirectoryPath, MetadataFileName),
+		[]byte(`{"versions": {"0.22.14": {"releaseDate": "2023-11-26", "deprecated": true}}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewRegistry(baseDirectoryPath, "")
+	err = registry.Discover()
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	actual, err := registry.Lookup("0.22.14")
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if actual.ReleaseDate != "2023-11-26" {
+		t.Error("Expected 2023-11-26 but got", actual.ReleaseDate)
+	}
+	if !actual.Deprecated {
+		t.Error("Expected deprecated but got not deprecated")
+	}
+	actual, err = registry.Lookup("0.28.3")
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if actual.Deprecated {
+		t.Error("Expected not deprecated but got deprecated")
+	}
+}
+
+func Test_Registry_Lookup_Unknown(t *testing.T) {
+	baseDirectoryPath := createBaseDirectory(t, "0.22.14")
+	registry := NewRegistry(baseDirectoryPath, "")
+	err := registry.Discover()
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	for _, name := range []string{"", "0.28.3", "../0.22.14", "0.22.14/../../etc", "/etc", "."} {
+		_, err := registry.Lookup(name)
+		if err == nil {
+			t.Error("Expected error but got nil for", name)
+		}
+	}
+}
+
+func Test_CompareVersionNames(t *testing.T) {
+	cases := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"0.22.14", "0.22.14", 0},
+		{"0.22.14", "0.28.3", -1},
+		{"0.32.8", "0.28.3", 1},
+		{"0.22.14", "0.22.9", 1},
+	}
+	for _, c := range cases {
+		actual := CompareVersionNames(c.a, c.b)
+		if (actual < 0 && c.expected >= 0) || (actual == 0 && c.expected != 0) || (actual > 0 && c.expected <= 0) {
+			t.Error("Expected", c.expected, "but got", actual, "for", c.a, c.b)
+		}
+	}
+}
+
+func createRegistryWithMetadata(t *testing.T, metadata string, names ...string) *Registry {
+	t.Helper()
+	baseDirectoryPath := createBaseDirectory(t, names...)
+	err := os.WriteFile(filepath.Join(baseDirectoryPath, MetadataFileName), []byte(metadata), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewRegistry(baseDirectoryPath, "")
+	err = registry.Discover()
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	return registry
+}
+
+func Test_Registry_Resolve_Exact(t *testing.T) {
+	registry := createRegistryWithMetadata(t, `{}`, "0.22.14", "0.28.3")
+	actual, err := registry.Resolve("0.22.14", time.Now())
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if actual.Version.Name != "0.22.14" {
+		t.Error("Expected 0.22.14 but got", actual.Version.Name)
+	}
+	if len(actual.Warnings) != 0 {
+		t.Error("Expected no warnings but got", actual.Warnings)
+	}
+}
+
+func Test_Registry_Resolve_Aliases(t *testing.T) {
+	registry := createRegistryWithMetadata(t,
+		`{"versions": {"0.32.8": {"deprecated": true}}, "aliases": {"legacy": "0.22.14"}}`,
+		"0.22.14", "0.28.3", "0.32.8")
+	cases := map[string]string{
+		AliasLatest: "0.32.8",
+		AliasStable: "0.28.3",
+		"legacy":    "0.22.14",
+	}
+	for alias, expected := range cases {
+		actual, err := registry.Resolve(alias, time.Now())
+		if err != nil {
+			t.Fatal("Expected nil but got", err)
+		}
+		if actual.Version.Name != expected {
+			t.Error("Expected", expected, "but got", actual.Version.Name, "for", alias)
+		}
+		if actual.Requested != alias {
+			t.Error("Expected", alias, "but got", actual.Requested)
+		}
+	}
+}
+
+func Test_Registry_Resolve_ConfiguredStable(t *testing.T) {
+	registry := createRegistryWithMetadata(t, `{"aliases": {"stable": "0.22.14"}}`, "0.22.14", "0.28.3")
+	actual, err := registry.Resolve(AliasStable, time.Now())
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if actual.Version.Name != "0.22.14" {
+		t.Error("Expected 0.22.14 but got", actual.Version.Name)
+	}
+}
+
+func Test_Registry_Resolve_Retired(t *testing.T) {
+	registry := createRegistryWithMetadata(t,
+		`{"retired": {"0.19.3": {"successor": "0.22.14", "sunsetDate": "2026-12-31"}}}`,
+		"0.22.14")
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	actual, err := registry.Resolve("0.19.3", now)
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if actual.Version.Name != "0.22.14" {
+		t.Error("Expected 0.22.14 but got", actual.Version.Name)
+	}
+	if len(actual.Warnings) != 1 {
+		t.Error("Expected 1 warning but got", actual.Warnings)
+	}
+}
+
+func Test_Registry_Resolve_RetiredAfterSunset(t *testing.T) {
+	registry := createRegistryWithMetadata(t,
+		`{"retired": {"0.19.3": {"successor": "0.22.14", "sunsetDate": "2026-12-31"}}}`,
+		"0.22.14")
+	now := time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)
+	_, err := registry.Resolve("0.19.3", now)
+	if err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
+
+func Test_Registry_Resolve_Deprecated(t *testing.T) {
+	registry := createRegistryWithMetadata(t, `{"versions": {"0.22.14": {"deprecated": true}}}`, "0.22.14")
+	actual, err := registry.Resolve("0.22.14", time.Now())
+	if err != nil {
+		t.Fatal("Expected nil but got", err)
+	}
+	if len(actual.Warnings) != 1 {
+		t.Error("Expected 1 warning but got", actual.Warnings)
+	}
+}
+
+func Test_Registry_Resolve_Unknown(t *testing.T) {
+	registry := createRegistryWithMetadata(t, `{}`, "0.22.14")
+	for _, name := range []string{"0.28.3", "../0.22.14", "stable/../0.22.14"} {
+		_, err := registry.Resolve(name, time.Now())
+		if err == nil {
+			t.Error("Expected error but got nil for", name)
+		}
+	}
+}
+
+func Test_Registry_Resolve_TreeNotInstalled(t *testing.T) {
+	registry := createRegistryWithMetadata(t,
+		`{"versions": {"0.28.3": {}}, "aliases": {"stable": "0.32.8"}}`,
+		"0.22.14", "0.23.0")
+	err := os.RemoveAll(filepath.Join(registry.baseDirectoryPath, "0.23.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"0.28.3", AliasStable, "0.23.0"} {
+		_, err := registry.Resolve(name, time.Now())
+		var treeNotInstalledError *TreeNotInstalledError
+		if !errors.As(err, &treeNotInstalledError) {
+			t.Error("Expected TreeNotInstalledError but got", err, "for", name)
+		}
+	}
+	_, err = registry.Resolve("0.19.3", time.Now())
+	var treeNotInstalledError *TreeNotInstalledError
+	if err == nil || errors.As(err, &treeNotInstalledError) {
+		t.Error("Expected the unknown version error but got", err)
+	}
+}
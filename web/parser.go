@@ -4,11 +4,24 @@ import (
 	"fmt"
 	"net/http"
 	"remap-keys.app/remap-build-server/common"
+	"strconv"
+)
+
+const (
+	// ModeBuild builds the firmware and uploads it.
+	ModeBuild string = ""
+	// ModeReproducibility builds the firmware twice and compares the results without uploading them.
+	// It is used by the administrators calling the server directly, and does not consume the remaining build count.
+	// The authentication token must be of an account configured by the REMAP_ADMIN_EMAILS environment variable.
+	ModeReproducibility string = "reproducibility"
 )
 
 // ParseQueryParameters parses the query parameters. The query parameters are as follows:
 //   - uid: The user's UID.
 //   - taskId: The task ID.
+//   - mode: The optional mode of the task. See ModeBuild and ModeReproducibility.
+//
+// The retry count is taken from the header set by Cloud Tasks.
 func ParseQueryParameters(r *http.Request) (*common.RequestParameters, error) {
 	queryParams := r.URL.Query()
 	uid := queryParams.Get("uid")
@@ -16,8 +29,16 @@ func ParseQueryParameters(r *http.Request) (*common.RequestParameters, error) {
 	if uid == "" || taskId == "" {
 		return nil, fmt.Errorf("uid or taskId is empty")
 	}
+	mode := queryParams.Get("mode")
+	if mode != ModeBuild && mode != ModeReproducibility {
+		return nil, fmt.Errorf("invalid mode: %s", mode)
+	}
+	// The header is absent when the server is called directly, so the invalid value is treated as the first attempt.
+	retryCount, _ := strconv.Atoi(r.Header.Get("X-CloudTasks-TaskRetryCount"))
 	return &common.RequestParameters{
-		Uid:    uid,
-		TaskId: taskId,
+		Uid:        uid,
+		TaskId:     taskId,
+		Mode:       mode,
+		RetryCount: retryCount,
 	}, nil
 }